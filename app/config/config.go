@@ -1,8 +1,13 @@
 package config
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -16,6 +21,44 @@ type Config struct {
 
 	JWTSecret string
 	AppPort   string
+
+	StoreName    string
+	StoreAddress string
+	StoreEmail   string
+	StorePhone   string
+	StoreTaxID   string
+	TaxRate      float64
+
+	StorageDriver   string
+	StorageLocalDir string
+	S3Endpoint      string
+	S3Region        string
+	S3Bucket        string
+	S3AccessKey     string
+	S3SecretKey     string
+
+	NotifyDriver        string
+	SMTPHost            string
+	SMTPPort            string
+	SMTPUser            string
+	SMTPPass            string
+	SMTPFrom            string
+	NotifyWebhookURL    string
+	NotifyWebhookSecret string
+
+	RecommendationsRefresh time.Duration
+	BackorderCheck         time.Duration
+
+	AllocationStrategy string
+
+	FlashSaleDriver string
+	FlashSaleHold   time.Duration
+	RedisAddr       string
+	RedisPassword   string
+
+	DownloadSecret  string
+	DownloadLinkTTL time.Duration
+	DownloadLimit   int
 }
 
 func Load() *Config {
@@ -28,14 +71,92 @@ func Load() *Config {
 		DBName:    get("DB_NAME", os.Getenv("DB_NAME")),
 		JWTSecret: get("JWT_SECRET", os.Getenv("JWT_SECRET")),
 		AppPort:   get("APP_PORT", os.Getenv("PORT")),
+
+		StoreName:    get("STORE_NAME", "Bookstore"),
+		StoreAddress: os.Getenv("STORE_ADDRESS"),
+		StoreEmail:   os.Getenv("STORE_EMAIL"),
+		StorePhone:   os.Getenv("STORE_PHONE"),
+		StoreTaxID:   os.Getenv("STORE_TAX_ID"),
+
+		StorageDriver:   get("STORAGE_DRIVER", "local"),
+		StorageLocalDir: get("STORAGE_LOCAL_DIR", "uploads"),
+		S3Endpoint:      os.Getenv("S3_ENDPOINT"),
+		S3Region:        get("S3_REGION", "us-east-1"),
+		S3Bucket:        os.Getenv("S3_BUCKET"),
+		S3AccessKey:     os.Getenv("S3_ACCESS_KEY"),
+		S3SecretKey:     os.Getenv("S3_SECRET_KEY"),
+
+		NotifyDriver:        get("NOTIFY_DRIVER", "log"),
+		SMTPHost:            os.Getenv("SMTP_HOST"),
+		SMTPPort:            get("SMTP_PORT", "587"),
+		SMTPUser:            os.Getenv("SMTP_USER"),
+		SMTPPass:            os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:            os.Getenv("SMTP_FROM"),
+		NotifyWebhookURL:    os.Getenv("NOTIFY_WEBHOOK_URL"),
+		NotifyWebhookSecret: os.Getenv("NOTIFY_WEBHOOK_SECRET"),
+
+		AllocationStrategy: get("ALLOCATION_STRATEGY", "primary_first"),
+
+		FlashSaleDriver: get("FLASH_SALE_DRIVER", "memory"),
+		RedisAddr:       get("REDIS_ADDR", "localhost:6379"),
+		RedisPassword:   os.Getenv("REDIS_PASSWORD"),
+	}
+
+	taxRate, err := strconv.ParseFloat(get("TAX_RATE", "0"), 64)
+	if err != nil {
+		log.Fatalf("TAX_RATE must be a number: %v", err)
+	}
+	cfg.TaxRate = taxRate
+
+	refresh, err := time.ParseDuration(get("RECOMMENDATIONS_REFRESH", "1h"))
+	if err != nil {
+		log.Fatalf("RECOMMENDATIONS_REFRESH must be a duration such as 30m: %v", err)
+	}
+	cfg.RecommendationsRefresh = refresh
+
+	backorderCheck, err := time.ParseDuration(get("BACKORDER_CHECK", "15m"))
+	if err != nil {
+		log.Fatalf("BACKORDER_CHECK must be a duration such as 15m: %v", err)
+	}
+	cfg.BackorderCheck = backorderCheck
+
+	hold, err := time.ParseDuration(get("FLASH_SALE_HOLD", "5m"))
+	if err != nil || hold <= 0 {
+		log.Fatalf("FLASH_SALE_HOLD must be a positive duration such as 5m")
+	}
+	cfg.FlashSaleHold = hold
+
+	linkTTL, err := time.ParseDuration(get("DOWNLOAD_LINK_TTL", "15m"))
+	if err != nil || linkTTL <= 0 {
+		log.Fatalf("DOWNLOAD_LINK_TTL must be a positive duration such as 15m")
+	}
+	cfg.DownloadLinkTTL = linkTTL
+
+	downloadLimit, err := strconv.Atoi(get("DOWNLOAD_LIMIT", "5"))
+	if err != nil || downloadLimit <= 0 {
+		log.Fatalf("DOWNLOAD_LIMIT must be a positive number")
+	}
+	cfg.DownloadLimit = downloadLimit
+
+	if cfg.AllocationStrategy != "primary_first" && cfg.AllocationStrategy != "fewest_splits" {
+		log.Fatalf("ALLOCATION_STRATEGY must be primary_first or fewest_splits, got %q", cfg.AllocationStrategy)
 	}
 
 	if cfg.JWTSecret == "" {
 		log.Fatal("JWT_SECRET must be set")
 	}
+	// download links get their own key, derived from the JWT secret unless
+	// given one, so neither can be used to forge the other
+	cfg.DownloadSecret = get("DOWNLOAD_SECRET", deriveKey(cfg.JWTSecret, "downloads"))
 	return cfg
 }
 
+func deriveKey(secret, purpose string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 func get(k, fallback string) string {
 	v := os.Getenv(k)
 	if v == "" {
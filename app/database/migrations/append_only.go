@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// appendOnly installs a trigger that rejects every update and delete on
+// table. noun names what callers should record instead in the error, e.g.
+// "movement" for stock_movements.
+func appendOnly(db *gorm.DB, table, noun string) error {
+	statements := []string{
+		fmt.Sprintf(`CREATE OR REPLACE FUNCTION %[1]s_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION '%[1]s is append-only, record a new %[2]s instead';
+		END $$ LANGUAGE plpgsql`, table, noun),
+		fmt.Sprintf(`DROP TRIGGER IF EXISTS %[1]s_append_only_trigger ON %[1]s`, table),
+		fmt.Sprintf(`CREATE TRIGGER %[1]s_append_only_trigger
+			BEFORE UPDATE OR DELETE ON %[1]s
+			FOR EACH ROW EXECUTE FUNCTION %[1]s_append_only()`, table),
+	}
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
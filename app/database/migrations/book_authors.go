@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"log"
+
+	"bookstore-api/app/models"
+	"bookstore-api/app/services"
+
+	"gorm.io/gorm"
+)
+
+// BookAuthors links every book that has no credited authors yet to author
+// rows built from its free-text author, so it can be re-run at any time.
+func BookAuthors(db *gorm.DB) error {
+	var books []models.Book
+	if err := db.Where("NOT EXISTS (SELECT 1 FROM book_authors ba WHERE ba.book_id = books.id)").
+		Select("id", "author").Find(&books).Error; err != nil {
+		return err
+	}
+
+	for _, b := range books {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return services.LinkAuthorNames(tx, b.ID, b.Author)
+		}); err != nil {
+			return err
+		}
+	}
+
+	var authors int64
+	db.Model(&models.Author{}).Count(&authors)
+	log.Printf("Linked %d books, %d authors in total", len(books), authors)
+	return nil
+}
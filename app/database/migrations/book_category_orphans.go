@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// BookCategoryOrphans files books whose category no longer exists under an
+// "Uncategorized" category. Categories used to be deleted without checking
+// for books, and such rows would stop the foreign key on books.category_id
+// from being created, so this runs before migrating.
+func BookCategoryOrphans(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasTable("books") || !m.HasTable("categories") {
+		return nil
+	}
+	orphans := "category_id IS NULL OR category_id NOT IN (SELECT id FROM categories)"
+	var count int64
+	if err := db.Table("books").Where(orphans).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		// raw SQL, the categories table may predate the current model
+		if err := tx.Exec("INSERT INTO categories (name) VALUES ('Uncategorized') ON CONFLICT (name) DO NOTHING").Error; err != nil {
+			return err
+		}
+		var id uint
+		if err := tx.Raw("SELECT id FROM categories WHERE name = 'Uncategorized'").Scan(&id).Error; err != nil {
+			return err
+		}
+		log.Printf("Moving %d books with a missing category to Uncategorized", count)
+		return tx.Exec("UPDATE books SET category_id = ? WHERE "+orphans, id).Error
+	})
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// BookCoPurchases creates book_co_purchases, a materialized view counting
+// for every pair of books the paid orders that contain both. It is
+// refreshed periodically (see services.RefreshCoPurchases) rather than
+// computed per request; the unique index lets it refresh concurrently.
+// Every statement is idempotent, so it runs on every start.
+func BookCoPurchases(db *gorm.DB) error {
+	statements := []string{
+		`CREATE MATERIALIZED VIEW IF NOT EXISTS book_co_purchases AS
+			SELECT a.book_id, b.book_id AS related_book_id, COUNT(DISTINCT a.order_id) AS orders
+			FROM order_items a
+			JOIN order_items b ON b.order_id = a.order_id AND b.book_id <> a.book_id
+			JOIN orders o ON o.id = a.order_id
+			WHERE o.status = 'PAID'
+			GROUP BY a.book_id, b.book_id`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_book_co_purchases_pair ON book_co_purchases (book_id, related_book_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_book_co_purchases_rank ON book_co_purchases (book_id, orders DESC)`,
+	}
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
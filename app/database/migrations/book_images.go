@@ -0,0 +1,208 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"bookstore-api/app/imaging"
+	"bookstore-api/app/models"
+	"bookstore-api/app/storage"
+	"bookstore-api/app/utils"
+
+	"gorm.io/gorm"
+)
+
+// BookImages moves covers stored as base64 text in books.image_base64 into
+// the storage backend, rendering the usual cover variants. Images the
+// imaging rules reject are stored as they are, as a single file. Rows that
+// don't hold a recognisable image are left in place for manual review, and
+// the column is only dropped once no row is left, so the command can be
+// re-run after fixing them or after a storage failure.
+func BookImages(db *gorm.DB, store storage.Storage) error {
+	if !db.Migrator().HasColumn(&models.Book{}, "image_base64") {
+		log.Println("books.image_base64 does not exist, nothing to migrate")
+		return nil
+	}
+
+	type row struct {
+		ID          uint
+		ImageBase64 string
+	}
+	var lastID uint
+	converted, skipped, failed := 0, 0, 0
+	for {
+		// small batches, each image may be megabytes of text
+		var rows []row
+		if err := db.Raw(`SELECT id, image_base64 FROM books
+			WHERE id > ? AND image_base64 IS NOT NULL AND image_base64 <> ''
+			ORDER BY id LIMIT 50`, lastID).Scan(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, r := range rows {
+			lastID = r.ID
+			err := migrateBookImage(db, store, r.ID, r.ImageBase64)
+			switch {
+			case errors.Is(err, errInvalidBase64) || errors.Is(err, errUnknownImage):
+				log.Printf("book %d: %v, left for manual review", r.ID, err)
+				skipped++
+			case err != nil:
+				log.Printf("book %d: %v", r.ID, err)
+				failed++
+			default:
+				converted++
+			}
+		}
+	}
+
+	log.Printf("Converted %d book images, %d left for review, %d failed", converted, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d book images could not be converted", failed)
+	}
+	if skipped > 0 {
+		log.Printf("Keeping books.image_base64 until the %d remaining images are fixed or removed", skipped)
+		return nil
+	}
+	return db.Migrator().DropColumn(&models.Book{}, "image_base64")
+}
+
+// SingleFileCovers renders the variants of covers stored as a single file
+// before there were variants. Covers the imaging rules now reject stay
+// single files, which are still served for every variant.
+func SingleFileCovers(db *gorm.DB, store storage.Storage) error {
+	type row struct {
+		ID       uint
+		ImageKey string
+	}
+	var lastID uint
+	converted, kept, failed := 0, 0, 0
+	for {
+		var rows []row
+		if err := db.Raw(`SELECT id, image_key FROM books
+			WHERE id > ? AND image_key <> '' AND COALESCE(image_ext, '') = ''
+			ORDER BY id LIMIT 50`, lastID).Scan(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, r := range rows {
+			lastID = r.ID
+			err := migrateSingleFileCover(db, store, r.ID, r.ImageKey)
+			switch {
+			case imaging.IsInvalid(err):
+				log.Printf("book %d: %v, keeping it as a single file", r.ID, err)
+				kept++
+			case err != nil:
+				log.Printf("book %d: %v", r.ID, err)
+				failed++
+			default:
+				converted++
+			}
+		}
+	}
+
+	log.Printf("Rendered variants of %d single file covers, %d kept as they are, %d failed", converted, kept, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d single file covers could not be converted", failed)
+	}
+	return nil
+}
+
+func migrateSingleFileCover(db *gorm.DB, store storage.Storage, id uint, key string) error {
+	ctx := context.Background()
+	rc, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, imaging.MaxFileSize+1))
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	if len(data) > imaging.MaxFileSize {
+		return imaging.ErrTooLarge
+	}
+	base, ext, err := imaging.SaveCover(ctx, store, data)
+	if err != nil {
+		return err
+	}
+	// only if the cover wasn't replaced in the meantime
+	res := db.Exec("UPDATE books SET image_key = ?, image_ext = ? WHERE id = ? AND image_key = ?", base, ext, id, key)
+	if res.Error != nil || res.RowsAffected == 0 {
+		imaging.DeleteCover(ctx, store, base, ext)
+		return res.Error
+	}
+	store.Delete(ctx, key)
+	return nil
+}
+
+// DropBookImageURL drops books.image_url. Cover URLs are derived from
+// image_key now, which every row with an image_url also has.
+func DropBookImageURL(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&models.Book{}, "image_url") {
+		return nil
+	}
+	var orphans int64
+	if err := db.Raw(`SELECT COUNT(*) FROM books
+		WHERE image_url IS NOT NULL AND image_url <> '' AND COALESCE(image_key, '') = ''`).Scan(&orphans).Error; err != nil {
+		return err
+	}
+	if orphans > 0 {
+		log.Printf("%d books have an image_url without an image_key, keeping books.image_url", orphans)
+		return nil
+	}
+	return db.Migrator().DropColumn(&models.Book{}, "image_url")
+}
+
+var (
+	errInvalidBase64 = errors.New("invalid base64")
+	errUnknownImage  = errors.New("not a recognised image")
+)
+
+// originalExts are the image types a rejected cover is still stored as.
+var originalExts = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+func migrateBookImage(db *gorm.DB, store storage.Storage, id uint, encoded string) error {
+	// accept data URLs as well as bare base64
+	if i := strings.Index(encoded, ";base64,"); strings.HasPrefix(encoded, "data:") && i >= 0 {
+		encoded = encoded[i+len(";base64,"):]
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return errInvalidBase64
+	}
+
+	ctx := context.Background()
+	key, ext, err := imaging.SaveCover(ctx, store, data)
+	if imaging.IsInvalid(err) {
+		// keep the image as it is, served as a single file for every variant
+		contentType := http.DetectContentType(data)
+		fileExt, ok := originalExts[contentType]
+		if !ok {
+			return errUnknownImage
+		}
+		log.Printf("book %d: %v, storing the original", id, err)
+		key, ext = utils.NewFileKey("covers", fileExt), ""
+		err = store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType)
+	}
+	if err != nil {
+		return err
+	}
+	return db.Exec("UPDATE books SET image_key = ?, image_ext = ?, image_base64 = NULL WHERE id = ?",
+		key, ext, id).Error
+}
@@ -0,0 +1,74 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// BookSearch sets up full-text search on books: two text search
+// configurations that ignore accents, bookstore_simple (plain words) and
+// bookstore_id (Indonesian stems when the server ships the stemmer), and a
+// books.search_vector column kept current by triggers and indexed with GIN.
+// Every statement is idempotent, so it runs on every start.
+func BookSearch(db *gorm.DB) error {
+	statements := []string{
+		`CREATE EXTENSION IF NOT EXISTS unaccent`,
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'bookstore_simple') THEN
+				CREATE TEXT SEARCH CONFIGURATION bookstore_simple (COPY = simple);
+				ALTER TEXT SEARCH CONFIGURATION bookstore_simple
+					ALTER MAPPING FOR hword, hword_part, word WITH unaccent, simple;
+			END IF;
+			IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'bookstore_id') THEN
+				CREATE TEXT SEARCH CONFIGURATION bookstore_id (COPY = simple);
+				IF EXISTS (SELECT 1 FROM pg_ts_dict WHERE dictname = 'indonesian_stem') THEN
+					ALTER TEXT SEARCH CONFIGURATION bookstore_id
+						ALTER MAPPING FOR asciiword, asciihword, hword_asciipart WITH indonesian_stem;
+					ALTER TEXT SEARCH CONFIGURATION bookstore_id
+						ALTER MAPPING FOR hword, hword_part, word WITH unaccent, indonesian_stem;
+				ELSE
+					ALTER TEXT SEARCH CONFIGURATION bookstore_id
+						ALTER MAPPING FOR hword, hword_part, word WITH unaccent, simple;
+				END IF;
+			END IF;
+		END $$`,
+		`ALTER TABLE books ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+		// titles and descriptions are indexed both stemmed and as written,
+		// so prefix searches match the words as typed
+		`CREATE OR REPLACE FUNCTION books_search_vector_update() RETURNS trigger AS $$
+		BEGIN
+			NEW.search_vector :=
+				setweight(to_tsvector('bookstore_id', coalesce(NEW.title, '')), 'A') ||
+				setweight(to_tsvector('bookstore_simple', coalesce(NEW.title, '')), 'A') ||
+				setweight(to_tsvector('bookstore_simple', coalesce(NEW.isbn, '')), 'A') ||
+				setweight(to_tsvector('bookstore_simple', coalesce(NEW.author, '')), 'B') ||
+				setweight(to_tsvector('bookstore_simple',
+					coalesce((SELECT name FROM categories WHERE id = NEW.category_id), '')), 'C') ||
+				setweight(to_tsvector('bookstore_id', coalesce(NEW.description, '')), 'D') ||
+				setweight(to_tsvector('bookstore_simple', coalesce(NEW.description, '')), 'D');
+			RETURN NEW;
+		END $$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS books_search_vector_trigger ON books`,
+		`CREATE TRIGGER books_search_vector_trigger
+			BEFORE INSERT OR UPDATE OF title, author, description, isbn, category_id ON books
+			FOR EACH ROW EXECUTE FUNCTION books_search_vector_update()`,
+		// renaming a category changes the vector of every book in it
+		`CREATE OR REPLACE FUNCTION categories_search_vector_update() RETURNS trigger AS $$
+		BEGIN
+			IF NEW.name IS DISTINCT FROM OLD.name THEN
+				UPDATE books SET category_id = category_id WHERE category_id = NEW.id;
+			END IF;
+			RETURN NULL;
+		END $$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS categories_search_vector_trigger ON categories`,
+		`CREATE TRIGGER categories_search_vector_trigger
+			AFTER UPDATE OF name ON categories
+			FOR EACH ROW EXECUTE FUNCTION categories_search_vector_update()`,
+		`CREATE INDEX IF NOT EXISTS idx_books_search_vector ON books USING GIN (search_vector)`,
+		`UPDATE books SET title = title WHERE search_vector IS NULL`,
+	}
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
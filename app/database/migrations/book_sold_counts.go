@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// BookSoldCounts fills books.sold_count from the items of paid orders. It
+// only needs to run once, right after the column is added; from then on
+// PayOrder keeps the counts up to date.
+func BookSoldCounts(db *gorm.DB) error {
+	res := db.Exec(`
+		UPDATE books SET sold_count = s.quantity
+		FROM (
+			SELECT oi.book_id, SUM(oi.quantity) AS quantity
+			FROM order_items oi JOIN orders o ON o.id = oi.order_id
+			WHERE o.status = 'PAID'
+			GROUP BY oi.book_id
+		) s
+		WHERE s.book_id = books.id
+	`)
+	if res.Error != nil {
+		return res.Error
+	}
+	log.Printf("Backfilled sold counts of %d books", res.RowsAffected)
+	return nil
+}
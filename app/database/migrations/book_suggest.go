@@ -0,0 +1,27 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// BookSuggest adds the trigram indexes behind the typeahead endpoint. Titles,
+// author and category names are indexed lower-cased and without accents
+// through bookstore_fold, an immutable wrapper around unaccent, so the same
+// expression can be used in queries. Trigram GIN indexes serve both prefix
+// LIKE and fuzzy matches. Every statement is idempotent, so it runs on every
+// start, after BookSearch has created the unaccent extension.
+func BookSuggest(db *gorm.DB) error {
+	statements := []string{
+		`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+		`CREATE OR REPLACE FUNCTION bookstore_fold(text) RETURNS text AS $$
+			SELECT lower(public.unaccent('public.unaccent'::regdictionary, $1))
+		$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT`,
+		`CREATE INDEX IF NOT EXISTS idx_books_title_trgm ON books USING GIN (bookstore_fold(title) gin_trgm_ops)`,
+		`CREATE INDEX IF NOT EXISTS idx_authors_name_trgm ON authors USING GIN (bookstore_fold(name) gin_trgm_ops)`,
+		`CREATE INDEX IF NOT EXISTS idx_categories_name_trgm ON categories USING GIN (bookstore_fold(name) gin_trgm_ops)`,
+	}
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
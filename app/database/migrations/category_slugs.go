@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"bookstore-api/app/models"
+	"bookstore-api/app/services"
+
+	"gorm.io/gorm"
+)
+
+// CategorySlugs gives categories created before slugs existed a slug
+// derived from their name. It runs on every start and is a no-op once
+// every category has one.
+func CategorySlugs(db *gorm.DB) error {
+	var cats []models.Category
+	if err := db.Where("slug = ''").Find(&cats).Error; err != nil {
+		return err
+	}
+	for _, cat := range cats {
+		slug := services.UniqueCategorySlug(db, cat.Name, cat.ID)
+		if err := db.Model(&cat).Update("slug", slug).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
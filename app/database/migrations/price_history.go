@@ -0,0 +1,27 @@
+package migrations
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// PriceHistory makes book_price_changes append-only with a trigger that
+// rejects updates and deletes. When the table has just been created,
+// initialPrices records each book's current price as its first one.
+func PriceHistory(db *gorm.DB, initialPrices bool) error {
+	if err := appendOnly(db, "book_price_changes", "change"); err != nil {
+		return err
+	}
+	if !initialPrices {
+		return nil
+	}
+	res := db.Exec(`
+		INSERT INTO book_price_changes (book_id, price, currency, created_at)
+		SELECT id, price, currency, now() FROM books`)
+	if res.Error != nil {
+		return res.Error
+	}
+	log.Printf("Recorded the current prices of %d books", res.RowsAffected)
+	return nil
+}
@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// StockMovements makes stock_movements append-only with a trigger that
+// rejects updates and deletes. When the table has just been created,
+// openingBalances records each book's current stock as an opening
+// adjustment, so the ledger adds up from the start.
+func StockMovements(db *gorm.DB, openingBalances bool) error {
+	if err := appendOnly(db, "stock_movements", "movement"); err != nil {
+		return err
+	}
+	if !openingBalances {
+		return nil
+	}
+	res := db.Exec(`
+		INSERT INTO stock_movements (book_id, type, quantity, stock_after, reason, created_at)
+		SELECT id, 'adjustment', stock, stock, 'opening balance', now()
+		FROM books WHERE stock <> 0`)
+	if res.Error != nil {
+		return res.Error
+	}
+	log.Printf("Recorded opening stock balances of %d books", res.RowsAffected)
+	return nil
+}
@@ -0,0 +1,9 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// WalletLedger makes wallet_transactions append-only with a trigger that
+// rejects updates and deletes, so the ledger always explains the balances.
+func WalletLedger(db *gorm.DB) error {
+	return appendOnly(db, "wallet_transactions", "transaction")
+}
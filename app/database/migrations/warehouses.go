@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// Warehouses creates the primary warehouse when the warehouses table has
+// just been created, and moves every book's stock into it. Movements
+// recorded before then have no warehouse and are counted against it.
+func Warehouses(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`
+			INSERT INTO warehouses (code, name, is_primary, priority, created_at, updated_at)
+			VALUES ('MAIN', 'Main Warehouse', true, 0, now(), now())`).Error; err != nil {
+			return err
+		}
+		res := tx.Exec(`
+			INSERT INTO warehouse_stocks (warehouse_id, book_id, quantity, updated_at)
+			SELECT w.id, b.id, b.stock, now()
+			FROM books b CROSS JOIN warehouses w
+			WHERE w.code = 'MAIN' AND b.stock > 0`)
+		if res.Error != nil {
+			return res.Error
+		}
+		log.Printf("Moved the stock of %d books into the main warehouse", res.RowsAffected)
+		return nil
+	})
+}
@@ -1,51 +1,85 @@
 package seeders
 
 import (
-	"encoding/base64"
 	"fmt"
 	"math/rand"
 	"time"
 
 	"bookstore-api/app/models"
+	"bookstore-api/app/services"
+	"bookstore-api/app/utils"
 
 	"github.com/bxcodec/faker/v4"
 	"gorm.io/gorm"
 )
 
-func BookSeeder(db *gorm.DB) {
+func BookSeeder(db *gorm.DB) error {
 	categories := []string{"Fiksi", "Non-Fiksi", "Teknologi", "Sejarah", "Sains", "Bisnis"}
 
 	for _, name := range categories {
 		var count int64
 		db.Model(&models.Category{}).Where("name = ?", name).Count(&count)
 		if count == 0 {
-			db.Create(&models.Category{Name: name})
+			db.Create(&models.Category{Name: name, Slug: utils.Slugify(name)})
 			fmt.Println("Default category created: ", name)
 		}
 	}
 
+	// a few nested categories under Fiksi
+	parent := "Fiksi"
+	for _, name := range []string{"Novel", "Romance"} {
+		var p models.Category
+		db.Where("name = ?", parent).First(&p)
+		var count int64
+		db.Model(&models.Category{}).Where("name = ?", name).Count(&count)
+		if count == 0 {
+			db.Create(&models.Category{Name: name, Slug: utils.Slugify(name), ParentID: &p.ID})
+			fmt.Println("Default category created: ", parent+" > "+name)
+		}
+		parent = name
+	}
+
 	var allCategories []models.Category
 	db.Find(&allCategories)
 
 	for i := 0; i < 20; i++ {
 		category := allCategories[rand.Intn(len(allCategories))]
 
-		image := []byte(fmt.Sprintf("DummyImage-%d", i))
-		imageBase64 := base64.StdEncoding.EncodeToString(image)
+		isbn := fmt.Sprintf("978602%06d", rand.Intn(1000000))
+		isbn += string(utils.ISBN13CheckDigit(isbn))
 
 		book := models.Book{
-			Title:       faker.Sentence(),
-			Author:      faker.Name(),
-			Price:       float64(rand.Intn(500000))/100.0 + 10,
-			Stock:       rand.Intn(100) + 1,
-			Year:        rand.Intn(30) + 1990,
-			CategoryID:  category.ID,
-			ImageBase64: imageBase64,
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
+			Title:      faker.Sentence(),
+			Author:     faker.Name(),
+			ISBN:       &isbn,
+			Publisher:  faker.Word() + " Press",
+			Language:   "id",
+			PageCount:  rand.Intn(500) + 80,
+			Format:     models.FormatPaperback,
+			Price:      float64(rand.Intn(500000))/100.0 + 10,
+			Stock:      rand.Intn(100) + 1,
+			Year:       rand.Intn(30) + 1990,
+			CategoryID: category.ID,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
 		}
 
-		db.Create(&book)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&book).Error; err != nil {
+				return err
+			}
+			if err := services.RecordInitialStock(tx, book, nil); err != nil {
+				return err
+			}
+			if err := services.RecordInitialPrice(tx, book, nil); err != nil {
+				return err
+			}
+			return services.LinkAuthorNames(tx, book.ID, book.Author)
+		})
+		if err != nil {
+			return fmt.Errorf("book %q: %w", book.Title, err)
+		}
 		fmt.Println("Book seeder created: ", book.Title+" in category "+category.Name)
 	}
+	return nil
 }
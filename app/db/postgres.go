@@ -2,6 +2,7 @@ package db
 
 import (
 	"bookstore-api/app/config"
+	"bookstore-api/app/database/migrations"
 	"bookstore-api/app/models"
 	"fmt"
 	"log"
@@ -13,21 +14,100 @@ import (
 func Connect(cfg *config.Config) (*gorm.DB, error) {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
 		cfg.DBHost, cfg.DBUser, cfg.DBPass, cfg.DBName, cfg.DBPort)
+	return Open(dsn)
+}
+
+// Open connects to the database at dsn and migrates it. Tests that need a
+// database use it directly.
+func Open(dsn string) (*gorm.DB, error) {
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
 
 	log.Println("Migrating Database...")
+	if err := migrations.BookCategoryOrphans(db); err != nil {
+		return nil, err
+	}
+	m := db.Migrator()
+	backfillSoldCounts := m.HasTable("books") && !m.HasColumn("books", "sold_count")
+	openingBalances := m.HasTable("books") && !m.HasTable("stock_movements")
+	createWarehouses := !m.HasTable("warehouses")
+	initialPrices := m.HasTable("books") && !m.HasTable("book_price_changes")
 	if err := db.AutoMigrate(
 		&models.User{},
 		&models.Category{},
 		&models.Book{},
+		&models.Author{},
+		&models.BookAuthor{},
 		&models.Order{},
 		&models.OrderItem{},
+		&models.ExchangeRate{},
+		&models.InvoiceSequence{},
+		&models.Review{},
+		&models.WishlistItem{},
+		&models.RestockEvent{},
+		&models.RestockNotification{},
+		&models.StockMovement{},
+		&models.Warehouse{},
+		&models.WarehouseStock{},
+		&models.StockTransfer{},
+		&models.OrderItemAllocation{},
+		&models.Supplier{},
+		&models.PurchaseOrder{},
+		&models.PurchaseOrderItem{},
+		&models.PurchaseReceipt{},
+		&models.Bundle{},
+		&models.BundleItem{},
+		&models.PriceRule{},
+		&models.BookPriceChange{},
+		&models.FlashSale{},
+		&models.FlashSaleReservation{},
+		&models.FlashSaleStock{},
+		&models.EbookDownload{},
+		&models.Wallet{},
+		&models.WalletTransaction{},
+		&models.GiftCard{},
 	); err != nil {
 		log.Fatalf("Failed Migrating Database: %v", err)
 		return nil, err
 	}
+	if err := migrations.CategorySlugs(db); err != nil {
+		return nil, err
+	}
+	if err := migrations.DropBookImageURL(db); err != nil {
+		return nil, err
+	}
+	if backfillSoldCounts {
+		if err := migrations.BookSoldCounts(db); err != nil {
+			return nil, err
+		}
+	}
+	if err := migrations.StockMovements(db, openingBalances); err != nil {
+		return nil, err
+	}
+	if createWarehouses {
+		if err := migrations.Warehouses(db); err != nil {
+			return nil, err
+		}
+	}
+	if err := migrations.PriceHistory(db, initialPrices); err != nil {
+		return nil, err
+	}
+	if err := migrations.WalletLedger(db); err != nil {
+		return nil, err
+	}
+	if err := migrations.BookSearch(db); err != nil {
+		log.Fatalf("Failed Setting Up Book Search: %v", err)
+		return nil, err
+	}
+	if err := migrations.BookSuggest(db); err != nil {
+		log.Fatalf("Failed Setting Up Book Suggestions: %v", err)
+		return nil, err
+	}
+	if err := migrations.BookCoPurchases(db); err != nil {
+		log.Fatalf("Failed Setting Up Recommendations: %v", err)
+		return nil, err
+	}
 	return db, nil
 }
@@ -0,0 +1,15 @@
+package dto
+
+type AuthorRequest struct {
+	Name string `json:"name" binding:"required,max=150" example:"Andrea Hirata"`
+	Bio  string `json:"bio" example:"Indonesian novelist, author of Laskar Pelangi."`
+}
+
+type BookAuthorRequest struct {
+	AuthorID uint   `json:"author_id" binding:"required" example:"1"`
+	Role     string `json:"role" binding:"omitempty,oneof=author translator editor" example:"author"`
+}
+
+type SetBookAuthorsRequest struct {
+	Authors []BookAuthorRequest `json:"authors" binding:"required,min=1,dive"`
+}
@@ -1,21 +1,46 @@
 package dto
 
+import "time"
+
 type CreateBook struct {
-	Title       string  `json:"title" form:"title" binding:"required"`
-	Author      string  `json:"author" form:"author" binding:"required"`
-	Price       float64 `json:"price" form:"price" binding:"required"`
-	Stock       int     `json:"stock" form:"stock" binding:"required"`
-	Year        int     `json:"year" form:"year" binding:"required"`
-	CategoryID  uint    `json:"category_id" form:"category_id" binding:"required"`
-	ImageBase64 string  `json:"image_base64" form:"image_base64" binding:"required"`
+	Title            string     `json:"title" form:"title" binding:"required"`
+	Author           string     `json:"author" form:"author" binding:"required"`
+	Price            float64    `json:"price" form:"price" binding:"required"`
+	Currency         string     `json:"currency" form:"currency" binding:"omitempty,len=3,uppercase"`
+	Stock            int        `json:"stock" form:"stock" binding:"required_unless=Format ebook"`
+	ReorderThreshold int        `json:"reorder_threshold" form:"reorder_threshold" binding:"min=0" example:"5"`
+	ReorderQuantity  int        `json:"reorder_quantity" form:"reorder_quantity" binding:"min=0" example:"20"`
+	ReleaseDate      *time.Time `json:"release_date" form:"release_date" example:"2025-03-01T00:00:00Z"`
+	BackorderCap     int        `json:"backorder_cap" form:"backorder_cap" binding:"min=0" example:"50"`
+	Year             int        `json:"year" form:"year" binding:"required"`
+	CategoryID       uint       `json:"category_id" form:"category_id" binding:"required"`
+	ISBN             string     `json:"isbn" form:"isbn" example:"978-602-8811-94-9"`
+	Publisher        string     `json:"publisher" form:"publisher" binding:"max=150"`
+	Language         string     `json:"language" form:"language" binding:"omitempty,bcp47_language_tag" example:"id"`
+	PageCount        int        `json:"page_count" form:"page_count" binding:"omitempty,min=1"`
+	Edition          string     `json:"edition" form:"edition" binding:"max=50"`
+	Format           string     `json:"format" form:"format" binding:"omitempty,oneof=hardcover paperback ebook"`
+	Description      string     `json:"description" form:"description"`
 }
 
 type UpdateBook struct {
-	Title       *string  `json:"title" form:"title"`
-	Author      *string  `json:"author" form:"author"`
-	Price       *float64 `json:"price" form:"price"`
-	Stock       *int     `json:"stock" form:"stock"`
-	Year        *int     `json:"year" form:"year"`
-	CategoryID  *uint    `json:"category_id" form:"category_id"`
-	ImageBase64 *string  `json:"image_base64" form:"image_base64"`
+	Title            *string    `json:"title" form:"title"`
+	Author           *string    `json:"author" form:"author"`
+	Price            *float64   `json:"price" form:"price"`
+	Currency         *string    `json:"currency" form:"currency" binding:"omitempty,len=3,uppercase"`
+	Stock            *int       `json:"stock" form:"stock" binding:"omitempty,min=0"`
+	StockReason      string     `json:"stock_reason" form:"stock_reason" binding:"max=255"`
+	ReorderThreshold *int       `json:"reorder_threshold" form:"reorder_threshold" binding:"omitempty,min=0"`
+	ReorderQuantity  *int       `json:"reorder_quantity" form:"reorder_quantity" binding:"omitempty,min=0"`
+	ReleaseDate      *time.Time `json:"release_date" form:"release_date"`
+	BackorderCap     *int       `json:"backorder_cap" form:"backorder_cap" binding:"omitempty,min=0"`
+	Year             *int       `json:"year" form:"year"`
+	CategoryID       *uint      `json:"category_id" form:"category_id"`
+	ISBN             *string    `json:"isbn" form:"isbn"`
+	Publisher        *string    `json:"publisher" form:"publisher" binding:"omitempty,max=150"`
+	Language         *string    `json:"language" form:"language" binding:"omitempty,bcp47_language_tag"`
+	PageCount        *int       `json:"page_count" form:"page_count" binding:"omitempty,min=1"`
+	Edition          *string    `json:"edition" form:"edition" binding:"omitempty,max=50"`
+	Format           *string    `json:"format" form:"format" binding:"omitempty,oneof=hardcover paperback ebook"`
+	Description      *string    `json:"description" form:"description"`
 }
@@ -0,0 +1,14 @@
+package dto
+
+type BundleItemRequest struct {
+	BookID   uint `json:"book_id" binding:"required" example:"1"`
+	Quantity int  `json:"quantity" binding:"required,min=1" example:"1"`
+}
+
+type BundleRequest struct {
+	Title       string              `json:"title" binding:"required,max=255" example:"Tetralogi Laskar Pelangi"`
+	Description string              `json:"description" example:"All four Laskar Pelangi novels in one box set"`
+	Price       float64             `json:"price" binding:"required,gt=0" example:"250000"`
+	Currency    string              `json:"currency" binding:"omitempty,len=3,uppercase" example:"IDR"`
+	Items       []BundleItemRequest `json:"items" binding:"required,min=1,dive"`
+}
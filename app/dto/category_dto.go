@@ -1,5 +1,15 @@
 package dto
 
 type CategoryRequest struct {
-	Name string `json:"name" example:"Fiksi"`
+	Name     string `json:"name" binding:"required,max=100" example:"Novel"`
+	Slug     string `json:"slug" binding:"omitempty,max=120" example:"novel"`
+	ParentID *uint  `json:"parent_id" example:"1"`
+}
+
+type CategoryTreeNode struct {
+	ID        uint                `json:"id" example:"1"`
+	Name      string              `json:"name" example:"Fiksi"`
+	Slug      string              `json:"slug" example:"fiksi"`
+	BookCount int64               `json:"book_count" example:"12"`
+	Children  []*CategoryTreeNode `json:"children"`
 }
@@ -0,0 +1,9 @@
+package dto
+
+import "time"
+
+type ExchangeRateRequest struct {
+	Currency      string     `json:"currency" binding:"required,len=3,uppercase" example:"MYR"`
+	Rate          float64    `json:"rate" binding:"required,gt=0" example:"3450.5"`
+	EffectiveFrom *time.Time `json:"effective_from" example:"2025-01-01T00:00:00Z"`
+}
@@ -0,0 +1,20 @@
+package dto
+
+import "time"
+
+// LibraryItem is an ebook the user has bought, with a fresh download link
+// while downloads are left and the ebook is out.
+type LibraryItem struct {
+	OrderID       uint       `json:"order_id" example:"12"`
+	OrderItemID   uint       `json:"order_item_id" example:"31"`
+	BookID        uint       `json:"book_id" example:"1"`
+	Title         string     `json:"title" example:"Laskar Pelangi"`
+	Author        string     `json:"author" example:"Andrea Hirata"`
+	FileType      string     `json:"file_type,omitempty" example:"application/epub+zip"`
+	FileSize      int64      `json:"file_size,omitempty" example:"1048576"`
+	PurchasedAt   *time.Time `json:"purchased_at"`
+	Downloads     int        `json:"downloads" example:"1"`
+	DownloadsLeft int        `json:"downloads_left" example:"4"`
+	DownloadURL   string     `json:"download_url,omitempty" example:"/downloads/31?expires=1735689600&signature=..."`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
@@ -0,0 +1,20 @@
+package dto
+
+import "time"
+
+type FlashSaleRequest struct {
+	BookID       uint      `json:"book_id" binding:"required" example:"1"`
+	Price        float64   `json:"price" binding:"required,gt=0" example:"49000"`
+	Quantity     int       `json:"quantity" binding:"required,min=1" example:"100"`
+	PerUserLimit int       `json:"per_user_limit" binding:"min=0" example:"2"`
+	StartsAt     time.Time `json:"starts_at" binding:"required" example:"2025-12-12T12:00:00+07:00"`
+	EndsAt       time.Time `json:"ends_at" binding:"required" example:"2025-12-12T13:00:00+07:00"`
+}
+
+type ReserveFlashSaleRequest struct {
+	Quantity int `json:"quantity" binding:"required,min=1" example:"1"`
+}
+
+type CheckoutReservationRequest struct {
+	Currency string `json:"currency" binding:"omitempty,len=3,uppercase" example:"IDR"`
+}
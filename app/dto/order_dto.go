@@ -1,10 +1,19 @@
 package dto
 
+// OrderItemRequest orders either a book or a bundle.
 type OrderItemRequest struct {
-	BookID   uint `json:"book_id" example:"1"`
-	Quantity int  `json:"quantity" example:"2"`
+	BookID   uint `json:"book_id" binding:"required_without=BundleID,excluded_with=BundleID" example:"1"`
+	BundleID uint `json:"bundle_id" example:"0"`
+	Quantity int  `json:"quantity" binding:"required,min=1" example:"2"`
 }
 
 type CreateOrderRequest struct {
-	Items []OrderItemRequest `json:"items"`
+	Items       []OrderItemRequest `json:"items" binding:"required,min=1,dive"`
+	Currency    string             `json:"currency" binding:"omitempty,len=3,uppercase" example:"IDR"`
+	StoreCredit float64            `json:"store_credit" binding:"min=0" example:"50000"`
+}
+
+type RefundOrderRequest struct {
+	Method string `json:"method" binding:"required,oneof=store_credit original" example:"store_credit"`
+	Reason string `json:"reason" binding:"max=255" example:"damaged in delivery"`
 }
@@ -0,0 +1,15 @@
+package dto
+
+import "time"
+
+// PriceRuleRequest targets either a book or a category, and sets either a
+// sale price or a percentage off.
+type PriceRuleRequest struct {
+	Name       string     `json:"name" binding:"required,max=100" example:"Harbolnas 12.12"`
+	BookID     *uint      `json:"book_id" example:"1"`
+	CategoryID *uint      `json:"category_id" example:"2"`
+	SalePrice  *float64   `json:"sale_price" binding:"omitempty,gt=0" example:"59000"`
+	PercentOff *float64   `json:"percent_off" binding:"omitempty,gt=0,lte=100" example:"20"`
+	StartsAt   *time.Time `json:"starts_at" example:"2025-12-12T00:00:00+07:00"`
+	EndsAt     *time.Time `json:"ends_at" example:"2025-12-13T00:00:00+07:00"`
+}
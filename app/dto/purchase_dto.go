@@ -0,0 +1,36 @@
+package dto
+
+import "time"
+
+type SupplierRequest struct {
+	Name        string `json:"name" binding:"required,max=150" example:"PT Distribusi Buku Nusantara"`
+	ContactName string `json:"contact_name" binding:"max=100" example:"Rina"`
+	Email       string `json:"email" binding:"omitempty,email,max=150" example:"orders@dbn.co.id"`
+	Phone       string `json:"phone" binding:"max=30" example:"+62 21 555 0101"`
+	Address     string `json:"address" binding:"max=255" example:"Jl. Gatot Subroto 12, Jakarta"`
+}
+
+type PurchaseOrderItemRequest struct {
+	BookID   uint    `json:"book_id" binding:"required" example:"1"`
+	Quantity int     `json:"quantity" binding:"required,min=1" example:"20"`
+	UnitCost float64 `json:"unit_cost" binding:"min=0" example:"45000"`
+}
+
+type PurchaseOrderRequest struct {
+	SupplierID  uint                       `json:"supplier_id" binding:"required" example:"1"`
+	WarehouseID uint                       `json:"warehouse_id" example:"1"`
+	Currency    string                     `json:"currency" binding:"omitempty,len=3,uppercase" example:"IDR"`
+	ExpectedAt  *time.Time                 `json:"expected_at" example:"2025-02-01T00:00:00Z"`
+	Notes       string                     `json:"notes" binding:"max=1000" example:"deliver to loading dock B"`
+	Items       []PurchaseOrderItemRequest `json:"items" binding:"required,min=1,dive"`
+}
+
+type ReceiptLineRequest struct {
+	ItemID   uint     `json:"item_id" binding:"required" example:"1"`
+	Quantity int      `json:"quantity" binding:"required,min=1" example:"10"`
+	UnitCost *float64 `json:"unit_cost" binding:"omitempty,min=0" example:"45000"`
+}
+
+type ReceivePurchaseOrderRequest struct {
+	Items []ReceiptLineRequest `json:"items" binding:"required,min=1,dive"`
+}
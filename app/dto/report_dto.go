@@ -16,3 +16,10 @@ type PriceStatsReportResponse struct {
 	Min float64 `json:"min" example:"25000"`
 	Avg float64 `json:"avg" example:"75000"`
 }
+
+type BundleSalesReportResponse struct {
+	BundleID uint    `json:"bundle_id" example:"1"`
+	Title    string  `json:"title" example:"Tetralogi Laskar Pelangi"`
+	Sold     int64   `json:"sold" example:"4"`
+	Revenue  float64 `json:"revenue" example:"1000000"`
+}
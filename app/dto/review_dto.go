@@ -0,0 +1,11 @@
+package dto
+
+type ReviewRequest struct {
+	Rating int    `json:"rating" binding:"required,min=1,max=5" example:"5"`
+	Title  string `json:"title" binding:"max=150" example:"Moving and funny"`
+	Body   string `json:"body" binding:"max=5000" example:"A story about friendship and school in Belitung."`
+}
+
+type ModerateReviewRequest struct {
+	Hidden *bool `json:"hidden" binding:"required" example:"true"`
+}
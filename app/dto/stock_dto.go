@@ -0,0 +1,16 @@
+package dto
+
+type StockMovementRequest struct {
+	Type        string `json:"type" binding:"required,oneof=restock adjustment return" example:"restock"`
+	Quantity    int    `json:"quantity" binding:"required" example:"10"`
+	WarehouseID uint   `json:"warehouse_id" example:"1"`
+	Reason      string `json:"reason" binding:"max=255" example:"delivery from distributor"`
+}
+
+type StockTransferRequest struct {
+	BookID          uint   `json:"book_id" binding:"required" example:"1"`
+	FromWarehouseID uint   `json:"from_warehouse_id" binding:"required" example:"1"`
+	ToWarehouseID   uint   `json:"to_warehouse_id" binding:"required,nefield=FromWarehouseID" example:"2"`
+	Quantity        int    `json:"quantity" binding:"required,min=1" example:"5"`
+	Reason          string `json:"reason" binding:"max=255" example:"rebalance for Surabaya orders"`
+}
@@ -0,0 +1,14 @@
+package dto
+
+import "time"
+
+type IssueGiftCardsRequest struct {
+	Amount    float64    `json:"amount" binding:"required,gt=0" example:"100000"`
+	Count     int        `json:"count" binding:"omitempty,min=1,max=100" example:"10"`
+	ExpiresAt *time.Time `json:"expires_at" example:"2026-12-31T23:59:59+07:00"`
+	Note      string     `json:"note" binding:"max=255" example:"Book fair giveaway"`
+}
+
+type RedeemGiftCardRequest struct {
+	Code string `json:"code" binding:"required" example:"ABCD-EFGH-JKLM-NPQR"`
+}
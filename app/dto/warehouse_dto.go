@@ -0,0 +1,9 @@
+package dto
+
+type WarehouseRequest struct {
+	Code      string `json:"code" binding:"required,max=20" example:"SBY"`
+	Name      string `json:"name" binding:"required,max=100" example:"Surabaya Warehouse"`
+	Address   string `json:"address" binding:"max=255" example:"Jl. Rungkut Industri 10, Surabaya"`
+	IsPrimary bool   `json:"is_primary" example:"false"`
+	Priority  int    `json:"priority" example:"1"`
+}
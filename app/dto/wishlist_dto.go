@@ -0,0 +1,5 @@
+package dto
+
+type WishlistRequest struct {
+	BookID uint `json:"book_id" binding:"required" example:"1"`
+}
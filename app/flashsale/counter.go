@@ -0,0 +1,47 @@
+package flashsale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"bookstore-api/app/config"
+)
+
+var (
+	ErrSoldOut      = errors.New("flash sale is sold out")
+	ErrLimitReached = errors.New("purchase limit for this flash sale reached")
+	ErrUnknownSale  = errors.New("flash sale is not loaded into the counter")
+)
+
+// Counter hands out the limited quantity of flash sales atomically and
+// caps how much of a sale each user gets, so reserving doesn't touch, let
+// alone lock, the database.
+type Counter interface {
+	// Load sets what is left of a sale and how much each user holds,
+	// unless the counter already knows the sale.
+	Load(ctx context.Context, sale uint, left int, held map[uint]int) error
+	// Reserve takes n of the sale for user. It fails with ErrSoldOut, with
+	// ErrLimitReached if user would hold more than limit (0 means no
+	// limit), or with ErrUnknownSale if the sale hasn't been loaded.
+	Reserve(ctx context.Context, sale, user uint, n, limit int) error
+	// Release gives back n that user held. Unknown sales are ignored.
+	Release(ctx context.Context, sale, user uint, n int) error
+	// Left returns what is left of the sale, or ErrUnknownSale.
+	Left(ctx context.Context, sale uint) (int, error)
+	// Drop forgets the sale.
+	Drop(ctx context.Context, sale uint) error
+}
+
+// New builds the counter selected by FLASH_SALE_DRIVER. The memory counter
+// only sees its own process, so run a single instance with it.
+func New(cfg *config.Config) (Counter, error) {
+	switch cfg.FlashSaleDriver {
+	case "", "memory":
+		return NewMemory(), nil
+	case "redis":
+		return NewRedis(cfg.RedisAddr, cfg.RedisPassword)
+	default:
+		return nil, fmt.Errorf("unknown flash sale driver %q", cfg.FlashSaleDriver)
+	}
+}
@@ -0,0 +1,188 @@
+package flashsale
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+)
+
+// loadTest simulates a flash sale of quantity copies: users customers each
+// trying attempts times at once to reserve one more copy, at most limit
+// each. Every releaseEvery-th reservation a user gets is given back, as
+// when one expires, so releases race with reservations too. It fails t if
+// the counter oversells, lets a user exceed the limit, or loses copies.
+func loadTest(t *testing.T, c Counter, sale uint, quantity, limit, users, attempts, releaseEvery int) {
+	t.Helper()
+	ctx := context.Background()
+	if err := c.Drop(ctx, sale); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Drop(context.Background(), sale) })
+	if err := c.Load(ctx, sale, quantity, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	held := make([]int, users)
+	var soldOut int
+	var mu sync.Mutex
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for u := 0; u < users; u++ {
+		wg.Add(1)
+		go func(u int) {
+			defer wg.Done()
+			user := uint(u + 1)
+			reserved := 0
+			<-start
+			for i := 0; i < attempts; i++ {
+				err := c.Reserve(ctx, sale, user, 1, limit)
+				switch {
+				case err == nil:
+					reserved++
+					held[u]++
+					if releaseEvery > 0 && reserved%releaseEvery == 0 {
+						if err := c.Release(ctx, sale, user, 1); err != nil {
+							t.Error(err)
+							return
+						}
+						held[u]--
+					}
+				case errors.Is(err, ErrSoldOut):
+					mu.Lock()
+					soldOut++
+					mu.Unlock()
+				case errors.Is(err, ErrLimitReached):
+				default:
+					t.Error(err)
+					return
+				}
+			}
+		}(u)
+	}
+	close(start)
+	wg.Wait()
+
+	left, err := c.Left(ctx, sale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for u, n := range held {
+		total += n
+		if limit > 0 && n > limit {
+			t.Errorf("user %d holds %d, over the limit of %d", u+1, n, limit)
+		}
+	}
+	if left < 0 {
+		t.Errorf("%d left, oversold", left)
+	}
+	if total+left != quantity {
+		t.Errorf("%d held and %d left don't add up to %d", total, left, quantity)
+	}
+	if soldOut > 0 && left > 0 && releaseEvery == 0 {
+		t.Errorf("refused as sold out with %d left", left)
+	}
+}
+
+func counterLoadTests(t *testing.T, c Counter) {
+	tests := []struct {
+		name                                           string
+		quantity, limit, users, attempts, releaseEvery int
+	}{
+		{"more customers than copies", 100, 2, 500, 5, 0},
+		{"no limit", 100, 0, 200, 5, 0},
+		{"limit never reached", 1000, 3, 100, 3, 0},
+		{"releases racing reservations", 50, 2, 300, 8, 2},
+		{"single copy", 1, 1, 200, 2, 0},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadTest(t, c, uint(1<<31+i), tt.quantity, tt.limit, tt.users, tt.attempts, tt.releaseEvery)
+		})
+	}
+}
+
+func TestMemoryUnderLoad(t *testing.T) {
+	counterLoadTests(t, NewMemory())
+}
+
+// TestRedisUnderLoad runs against a real Redis, e.g.
+//
+//	REDIS_TEST_ADDR=localhost:6379 go test -race ./app/flashsale/
+func TestRedisUnderLoad(t *testing.T) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+	r, err := NewRedis(addr, os.Getenv("REDIS_TEST_PASSWORD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counterLoadTests(t, r)
+}
+
+func TestCounter(t *testing.T) {
+	counters := map[string]func(t *testing.T) Counter{
+		"memory": func(t *testing.T) Counter { return NewMemory() },
+		"redis": func(t *testing.T) Counter {
+			addr := os.Getenv("REDIS_TEST_ADDR")
+			if addr == "" {
+				t.Skip("REDIS_TEST_ADDR not set")
+			}
+			r, err := NewRedis(addr, os.Getenv("REDIS_TEST_PASSWORD"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return r
+		},
+	}
+	for name, newCounter := range counters {
+		t.Run(name, func(t *testing.T) {
+			c := newCounter(t)
+			ctx := context.Background()
+			const sale = 1<<31 - 1
+			c.Drop(ctx, sale)
+			defer c.Drop(ctx, sale)
+
+			if _, err := c.Left(ctx, sale); !errors.Is(err, ErrUnknownSale) {
+				t.Errorf("Left of an unknown sale = %v, want ErrUnknownSale", err)
+			}
+			if err := c.Reserve(ctx, sale, 1, 1, 0); !errors.Is(err, ErrUnknownSale) {
+				t.Errorf("Reserve of an unknown sale = %v, want ErrUnknownSale", err)
+			}
+			if err := c.Load(ctx, sale, 5, map[uint]int{1: 1}); err != nil {
+				t.Fatal(err)
+			}
+			// loading again keeps what the counter has
+			if err := c.Load(ctx, sale, 100, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Reserve(ctx, sale, 1, 2, 2); !errors.Is(err, ErrLimitReached) {
+				t.Errorf("Reserve over the limit = %v, want ErrLimitReached", err)
+			}
+			if err := c.Reserve(ctx, sale, 1, 1, 2); err != nil {
+				t.Errorf("Reserve up to the limit: %v", err)
+			}
+			if err := c.Reserve(ctx, sale, 2, 5, 0); !errors.Is(err, ErrSoldOut) {
+				t.Errorf("Reserve more than is left = %v, want ErrSoldOut", err)
+			}
+			if err := c.Release(ctx, sale, 1, 2); err != nil {
+				t.Fatal(err)
+			}
+			if left, err := c.Left(ctx, sale); err != nil || left != 6 {
+				t.Errorf("Left = %d, %v, want 6", left, err)
+			}
+			if err := c.Reserve(ctx, sale, 1, 2, 2); err != nil {
+				t.Errorf("Reserve after release: %v", err)
+			}
+			if err := c.Drop(ctx, sale); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.Left(ctx, sale); !errors.Is(err, ErrUnknownSale) {
+				t.Errorf("Left of a dropped sale = %v, want ErrUnknownSale", err)
+			}
+		})
+	}
+}
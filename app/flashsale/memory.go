@@ -0,0 +1,92 @@
+package flashsale
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory keeps flash sale counters in process. Each sale has its own lock,
+// so sales don't contend with each other.
+type Memory struct {
+	mu    sync.RWMutex
+	sales map[uint]*memorySale
+}
+
+type memorySale struct {
+	mu   sync.Mutex
+	left int
+	held map[uint]int
+}
+
+func NewMemory() *Memory {
+	return &Memory{sales: map[uint]*memorySale{}}
+}
+
+func (m *Memory) Load(_ context.Context, sale uint, left int, held map[uint]int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sales[sale]; ok {
+		return nil
+	}
+	s := &memorySale{left: left, held: make(map[uint]int, len(held))}
+	for user, n := range held {
+		s.held[user] = n
+	}
+	m.sales[sale] = s
+	return nil
+}
+
+func (m *Memory) sale(id uint) *memorySale {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sales[id]
+}
+
+func (m *Memory) Reserve(_ context.Context, sale, user uint, n, limit int) error {
+	s := m.sale(sale)
+	if s == nil {
+		return ErrUnknownSale
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if limit > 0 && s.held[user]+n > limit {
+		return ErrLimitReached
+	}
+	if s.left < n {
+		return ErrSoldOut
+	}
+	s.left -= n
+	s.held[user] += n
+	return nil
+}
+
+func (m *Memory) Release(_ context.Context, sale, user uint, n int) error {
+	s := m.sale(sale)
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.left += n
+	if s.held[user] -= n; s.held[user] <= 0 {
+		delete(s.held, user)
+	}
+	return nil
+}
+
+func (m *Memory) Left(_ context.Context, sale uint) (int, error) {
+	s := m.sale(sale)
+	if s == nil {
+		return 0, ErrUnknownSale
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.left, nil
+}
+
+func (m *Memory) Drop(_ context.Context, sale uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sales, sale)
+	return nil
+}
@@ -0,0 +1,100 @@
+package flashsale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The scripts run atomically inside Redis. A sale's left quantity is a
+// string key and what each user holds a hash of user id to quantity.
+var (
+	loadScript = redis.NewScript(`
+if redis.call('SETNX', KEYS[1], ARGV[1]) == 1 then
+	redis.call('DEL', KEYS[2])
+	for i = 2, #ARGV, 2 do
+		redis.call('HSET', KEYS[2], ARGV[i], ARGV[i + 1])
+	end
+end
+return 0`)
+	reserveScript = redis.NewScript(`
+local left = redis.call('GET', KEYS[1])
+if not left then return -3 end
+left = tonumber(left)
+local n = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local held = tonumber(redis.call('HGET', KEYS[2], ARGV[1]) or '0')
+if limit > 0 and held + n > limit then return -2 end
+if left < n then return -1 end
+redis.call('DECRBY', KEYS[1], n)
+redis.call('HINCRBY', KEYS[2], ARGV[1], n)
+return left - n`)
+	releaseScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then return 0 end
+redis.call('INCRBY', KEYS[1], ARGV[2])
+if redis.call('HINCRBY', KEYS[2], ARGV[1], -tonumber(ARGV[2])) <= 0 then
+	redis.call('HDEL', KEYS[2], ARGV[1])
+end
+return 0`)
+)
+
+// Redis keeps flash sale counters in Redis so several instances of the API
+// share them.
+type Redis struct {
+	client *redis.Client
+}
+
+func NewRedis(addr, password string) (*Redis, error) {
+	if addr == "" {
+		return nil, errors.New("REDIS_ADDR must be set")
+	}
+	return &Redis{client: redis.NewClient(&redis.Options{Addr: addr, Password: password})}, nil
+}
+
+// saleKeys are the keys of a sale, hash tagged so that on Redis Cluster
+// they share a slot and the scripts can use both.
+func saleKeys(sale uint) []string {
+	return []string{fmt.Sprintf("flashsale:{%d}:left", sale), fmt.Sprintf("flashsale:{%d}:held", sale)}
+}
+
+func (r *Redis) Load(ctx context.Context, sale uint, left int, held map[uint]int) error {
+	args := []interface{}{left}
+	for user, n := range held {
+		args = append(args, user, n)
+	}
+	return loadScript.Run(ctx, r.client, saleKeys(sale), args...).Err()
+}
+
+func (r *Redis) Reserve(ctx context.Context, sale, user uint, n, limit int) error {
+	reply, err := reserveScript.Run(ctx, r.client, saleKeys(sale), user, n, limit).Int64()
+	if err != nil {
+		return err
+	}
+	switch reply {
+	case -1:
+		return ErrSoldOut
+	case -2:
+		return ErrLimitReached
+	case -3:
+		return ErrUnknownSale
+	}
+	return nil
+}
+
+func (r *Redis) Release(ctx context.Context, sale, user uint, n int) error {
+	return releaseScript.Run(ctx, r.client, saleKeys(sale), user, n).Err()
+}
+
+func (r *Redis) Left(ctx context.Context, sale uint) (int, error) {
+	left, err := r.client.Get(ctx, saleKeys(sale)[0]).Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrUnknownSale
+	}
+	return left, err
+}
+
+func (r *Redis) Drop(ctx context.Context, sale uint) error {
+	return r.client.Del(ctx, saleKeys(sale)...).Err()
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"net/http"
+
+	"bookstore-api/app/dto"
+	"bookstore-api/app/models"
+	"bookstore-api/app/services"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CreateAuthor godoc
+// @Summary Create author
+// @Tags Authors
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.AuthorRequest true "Author info"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /authors [post]
+func CreateAuthor(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req dto.AuthorRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		author := models.Author{Name: req.Name, Bio: req.Bio, Slug: services.UniqueAuthorSlug(db, req.Name, 0)}
+		if author.Slug == "" {
+			utils.JSONError(c, http.StatusBadRequest, "name must contain letters or digits")
+			return
+		}
+		if err := db.Create(&author).Error; err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.JSONCreated(c, "Success created author", author)
+	}
+}
+
+// ListAuthors godoc
+// @Summary List authors
+// @Tags Authors
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Param q query string false "Search by name"
+// @Success 200 {object} map[string]interface{}
+// @Router /authors [get]
+func ListAuthors(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, offset := pagination(c)
+		query := db.Model(&models.Author{})
+		if q := c.Query("q"); q != "" {
+			query = query.Where("name ILIKE ?", "%"+q+"%")
+		}
+		var total int64
+		query.Count(&total)
+		var authors []models.Author
+		query.Order("name asc").Limit(limit).Offset(offset).Find(&authors)
+		utils.JSONOk(c, gin.H{"items": authors, "page": page, "limit": limit, "total": total})
+	}
+}
+
+// GetAuthor godoc
+// @Summary Get author
+// @Tags Authors
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Author ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /authors/{id} [get]
+func GetAuthor(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var author models.Author
+		if err := db.First(&author, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "author not found")
+			return
+		}
+		utils.JSONOk(c, author)
+	}
+}
+
+// UpdateAuthor godoc
+// @Summary Update author
+// @Tags Authors
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Author ID"
+// @Param request body dto.AuthorRequest true "Author info"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /authors/{id} [put]
+func UpdateAuthor(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var author models.Author
+		if err := db.First(&author, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "author not found")
+			return
+		}
+		var req dto.AuthorRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if req.Name != author.Name {
+				author.Slug = services.UniqueAuthorSlug(tx, req.Name, author.ID)
+			}
+			author.Name, author.Bio = req.Name, req.Bio
+			if err := tx.Save(&author).Error; err != nil {
+				return err
+			}
+			// the name is part of every byline crediting this author
+			var bookIDs []uint
+			tx.Model(&models.BookAuthor{}).Where("author_id = ?", author.ID).Distinct().Pluck("book_id", &bookIDs)
+			for _, id := range bookIDs {
+				if err := services.RefreshByline(tx, id); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, author)
+	}
+}
+
+// DeleteAuthor godoc
+// @Summary Delete author
+// @Description Refused while books still credit the author
+// @Tags Authors
+// @Security BearerAuth
+// @Param id path int true "Author ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /authors/{id} [delete]
+func DeleteAuthor(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var author models.Author
+		if err := db.First(&author, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "author not found")
+			return
+		}
+		var count int64
+		db.Model(&models.BookAuthor{}).
+			Joins("JOIN books ON books.id = book_authors.book_id AND books.deleted_at IS NULL").
+			Where("book_authors.author_id = ?", author.ID).Count(&count)
+		if count > 0 {
+			utils.JSONError(c, http.StatusConflict, "author is still credited on books")
+			return
+		}
+		if err := db.Delete(&author).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"message": "deleted"})
+	}
+}
+
+// ListAuthorBooks godoc
+// @Summary List books of an author
+// @Tags Authors
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Author ID"
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Param role query string false "Only books where the author has this role" Enums(author, translator, editor)
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /authors/{id}/books [get]
+func ListAuthorBooks(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var author models.Author
+		if err := db.First(&author, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "author not found")
+			return
+		}
+		page, limit, offset := pagination(c)
+
+		credited := db.Model(&models.BookAuthor{}).Select("book_id").Where("author_id = ?", author.ID)
+		if role := c.Query("role"); role != "" {
+			credited = credited.Where("role = ?", role)
+		}
+		query := db.Model(&models.Book{}).Where("id IN (?)", credited)
+		var total int64
+		query.Count(&total)
+		var books []models.Book
+		preloadAuthors(query.Preload("Category")).Order("year desc, id desc").Limit(limit).Offset(offset).Find(&books)
+		utils.JSONOk(c, gin.H{"author": author, "items": books, "page": page, "limit": limit, "total": total})
+	}
+}
+
+// preloadAuthors loads the book credits in the order they are credited.
+func preloadAuthors(q *gorm.DB) *gorm.DB {
+	return q.Preload("Authors", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).Preload("Authors.Author")
+}
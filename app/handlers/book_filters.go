@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"bookstore-api/app/models"
+	"bookstore-api/app/services"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+	"gorm.io/gorm"
+)
+
+// basePriceSQL is a book's effective price in the base currency, using the
+// exchange rate currently in effect. It is NULL when the book's currency has
+// no rate, which keeps such books out of price filters and facets.
+const basePriceSQL = `(` + services.EffectivePriceSQL + ` * CASE WHEN books.currency = '` + models.BaseCurrency + `' THEN 1 ELSE (
+	SELECT er.rate FROM exchange_rates er
+	WHERE er.currency = books.currency AND er.effective_from <= now()
+	ORDER BY er.effective_from DESC LIMIT 1) END)`
+
+// htmlEscapeSQL wraps a text expression so it is HTML escaped. Highlights
+// are escaped before ts_headline adds its <mark> tags, so they can be
+// rendered as HTML without trusting the book's title or description.
+func htmlEscapeSQL(expr string) string {
+	return `replace(replace(replace(replace(` + expr + `, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;')`
+}
+
+// priceBuckets are the upper bounds, in the base currency, of the price
+// facet ranges. A final open-ended range covers everything above the last.
+var priceBuckets = []float64{50000, 100000, 200000, 500000}
+
+// facets a filter can be left out of, so each facet counts the books the
+// other filters let through.
+const (
+	facetCategory = "category"
+	facetPrice    = "price"
+	facetYear     = "year"
+)
+
+// bookFilter holds the validated ListBooks filters.
+type bookFilter struct {
+	category           string
+	author             string
+	minPrice, maxPrice *float64
+	minYear, maxYear   *int
+	inStock            bool
+	language           string
+	format             string
+	currency           string
+	withFacets         bool
+
+	// resolved from the parameters above
+	isbn        string
+	tsq         string
+	tsqArgs     []interface{}
+	searching   bool
+	categoryIDs []uint
+	cv          *currencyConverter
+}
+
+// parseBookFilter validates the ListBooks filter parameters. Every error it
+// returns names the offending parameter and is meant for a 400 response.
+func parseBookFilter(c *gin.Context) (*bookFilter, error) {
+	f := &bookFilter{
+		category: strings.TrimSpace(c.Query("category")),
+		author:   strings.TrimSpace(c.Query("author")),
+		currency: strings.ToUpper(c.Query("currency")),
+		format:   c.Query("format"),
+		language: c.Query("language"),
+	}
+	var err error
+	if f.minPrice, err = queryPrice(c, "min_price"); err != nil {
+		return nil, err
+	}
+	if f.maxPrice, err = queryPrice(c, "max_price"); err != nil {
+		return nil, err
+	}
+	if f.minPrice != nil && f.maxPrice != nil && *f.minPrice > *f.maxPrice {
+		return nil, fmt.Errorf("min_price cannot be greater than max_price")
+	}
+	if f.minYear, err = queryYear(c, "min_year"); err != nil {
+		return nil, err
+	}
+	if f.maxYear, err = queryYear(c, "max_year"); err != nil {
+		return nil, err
+	}
+	if f.minYear != nil && f.maxYear != nil && *f.minYear > *f.maxYear {
+		return nil, fmt.Errorf("min_year cannot be greater than max_year")
+	}
+	if f.inStock, err = queryBool(c, "in_stock", false); err != nil {
+		return nil, err
+	}
+	if f.withFacets, err = queryBool(c, "facets", true); err != nil {
+		return nil, err
+	}
+	if f.language != "" {
+		if _, err := language.Parse(f.language); err != nil {
+			return nil, fmt.Errorf("language must be a BCP 47 language tag such as id or en-US")
+		}
+	}
+	switch f.format {
+	case "", models.FormatHardcover, models.FormatPaperback, models.FormatEbook:
+	default:
+		return nil, fmt.Errorf("format must be one of %s, %s, %s", models.FormatHardcover, models.FormatPaperback, models.FormatEbook)
+	}
+	if f.currency != "" && (len(f.currency) != 3 || strings.Trim(f.currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "") {
+		return nil, fmt.Errorf("currency must be a three-letter currency code")
+	}
+
+	q := c.Query("q") // full-text search, see services.BookSearchQuery
+	if isbn, err := utils.NormalizeISBN(q); err == nil {
+		f.isbn = isbn
+	} else {
+		f.tsq, f.tsqArgs, f.searching = services.BookSearchQuery(q)
+	}
+	return f, nil
+}
+
+func queryPrice(c *gin.Context, name string) (*float64, error) {
+	s, ok := c.GetQuery(name)
+	if !ok || s == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+		return nil, fmt.Errorf("%s must be a non-negative number", name)
+	}
+	return &v, nil
+}
+
+func queryYear(c *gin.Context, name string) (*int, error) {
+	s, ok := c.GetQuery(name)
+	if !ok || s == "" {
+		return nil, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 || v > 9999 {
+		return nil, fmt.Errorf("%s must be a year between 0 and 9999", name)
+	}
+	return &v, nil
+}
+
+func queryBool(c *gin.Context, name string, def bool) (bool, error) {
+	s, ok := c.GetQuery(name)
+	if !ok || s == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", name)
+	}
+	return v, nil
+}
+
+// resolve looks up the currency and categories the filter refers to. An
+// unknown currency is the caller's mistake, anything else is a server error.
+func (f *bookFilter) resolve(db *gorm.DB) (badRequest bool, err error) {
+	target := f.currency
+	if target == "" {
+		target = models.BaseCurrency
+	}
+	if f.cv, err = newCurrencyConverter(db, target); err != nil {
+		return true, err
+	}
+	if f.category != "" {
+		// allow category id, slug or name, including every subcategory
+		var roots []uint
+		if id, err := strconv.Atoi(f.category); err == nil {
+			roots = []uint{uint(id)}
+		} else if err := db.Model(&models.Category{}).Where("slug = ? OR name ILIKE ?", f.category, "%"+f.category+"%").Pluck("id", &roots).Error; err != nil {
+			return false, err
+		}
+		if f.categoryIDs, err = services.CategoryDescendantIDs(db, roots); err != nil {
+			return false, err
+		}
+		if f.categoryIDs == nil {
+			f.categoryIDs = []uint{}
+		}
+	}
+	return false, nil
+}
+
+// apply adds every filter except the one named by skip to q.
+func (f *bookFilter) apply(q *gorm.DB, skip string) *gorm.DB {
+	if f.isbn != "" {
+		q = q.Where("books.isbn = ?", f.isbn)
+	} else if f.searching {
+		q = q.Where("books.search_vector @@ "+f.tsq, f.tsqArgs...)
+	}
+	if f.categoryIDs != nil && skip != facetCategory {
+		q = q.Where("books.category_id IN ?", f.categoryIDs)
+	}
+	if f.author != "" {
+		if id, err := strconv.Atoi(f.author); err == nil {
+			q = q.Where("books.id IN (SELECT book_id FROM book_authors WHERE author_id = ?)", id)
+		} else {
+			q = q.Where(`books.id IN (
+				SELECT ba.book_id FROM book_authors ba JOIN authors a ON a.id = ba.author_id
+				WHERE a.deleted_at IS NULL AND (a.slug = ? OR a.name ILIKE ?))`, f.author, "%"+f.author+"%")
+		}
+	}
+	if skip != facetPrice {
+		// prices are given in the display currency, compare in the base one
+		rate, _ := f.cv.rate(f.cv.target)
+		if f.minPrice != nil {
+			q = q.Where(basePriceSQL+" >= ?", *f.minPrice*rate)
+		}
+		if f.maxPrice != nil {
+			q = q.Where(basePriceSQL+" <= ?", *f.maxPrice*rate)
+		}
+	}
+	if skip != facetYear {
+		if f.minYear != nil {
+			q = q.Where("books.year >= ?", *f.minYear)
+		}
+		if f.maxYear != nil {
+			q = q.Where("books.year <= ?", *f.maxYear)
+		}
+	}
+	if f.inStock {
+		q = q.Where("(books.stock > 0 OR books.format = ?)", models.FormatEbook)
+	}
+	if f.language != "" {
+		q = q.Where("lower(books.language) = lower(?)", f.language)
+	}
+	if f.format != "" {
+		q = q.Where("books.format = ?", f.format)
+	}
+	return q
+}
+
+type categoryFacet struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Count int64  `json:"count"`
+}
+
+// priceFacet covers prices from Min up to but excluding Max, in the display
+// currency. The last range has no Max.
+type priceFacet struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int64    `json:"count"`
+}
+
+type yearFacet struct {
+	Year  int   `json:"year"`
+	Count int64 `json:"count"`
+}
+
+type bookFacets struct {
+	Categories  []categoryFacet `json:"categories"`
+	PriceRanges []priceFacet    `json:"price_ranges"`
+	Years       []yearFacet     `json:"years"`
+}
+
+// facets counts the filtered books per category, price range and year. Each
+// facet ignores its own filter so the storefront can offer the alternatives.
+func (f *bookFilter) facets(db *gorm.DB) (bookFacets, error) {
+	out := bookFacets{Categories: []categoryFacet{}, Years: []yearFacet{}}
+
+	err := f.apply(db.Model(&models.Book{}), facetCategory).
+		Joins("JOIN categories ON categories.id = books.category_id").
+		Select("categories.id, categories.name, categories.slug, COUNT(*) AS count").
+		Group("categories.id").Order("count DESC, categories.name").
+		Scan(&out.Categories).Error
+	if err != nil {
+		return out, err
+	}
+
+	bucket := "CASE"
+	args := make([]interface{}, 0, len(priceBuckets))
+	for i, upper := range priceBuckets {
+		bucket += fmt.Sprintf(" WHEN %s < ? THEN %d", basePriceSQL, i)
+		args = append(args, upper)
+	}
+	bucket += fmt.Sprintf(" ELSE %d END", len(priceBuckets))
+	var buckets []struct {
+		Bucket int
+		Count  int64
+	}
+	err = f.apply(db.Model(&models.Book{}), facetPrice).
+		Where(basePriceSQL+" IS NOT NULL").
+		Select(bucket+" AS bucket, COUNT(*) AS count", args...).
+		Group("bucket").Scan(&buckets).Error
+	if err != nil {
+		return out, err
+	}
+	rate, _ := f.cv.rate(f.cv.target)
+	display := func(base float64) float64 { return math.Round(base/rate*100) / 100 }
+	out.PriceRanges = make([]priceFacet, len(priceBuckets)+1)
+	for i := range out.PriceRanges {
+		if i > 0 {
+			out.PriceRanges[i].Min = display(priceBuckets[i-1])
+		}
+		if i < len(priceBuckets) {
+			upper := display(priceBuckets[i])
+			out.PriceRanges[i].Max = &upper
+		}
+	}
+	for _, b := range buckets {
+		out.PriceRanges[b.Bucket].Count = b.Count
+	}
+
+	err = f.apply(db.Model(&models.Book{}), facetYear).
+		Select("books.year, COUNT(*) AS count").
+		Group("books.year").Order("books.year DESC").
+		Scan(&out.Years).Error
+	return out, err
+}
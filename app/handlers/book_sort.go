@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bookstore-api/app/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// bookSort is one of the orders ListBooks can sort by. Ties are always
+// broken by book id, in the same direction, so keyset paging is stable.
+type bookSort struct {
+	expr string // SQL expression sorted on
+	cast string // type a cursor key is read back as
+	desc bool   // default direction
+}
+
+const sortRelevance = "relevance"
+
+var bookSorts = map[string]bookSort{
+	"created_at": {expr: "books.created_at", cast: "timestamptz", desc: true},
+	"price":      {expr: "COALESCE(" + basePriceSQL + ", books.price)", cast: "numeric"},
+	"title":      {expr: "books.title", cast: "text"},
+	"year":       {expr: "COALESCE(books.year, 0)", cast: "integer", desc: true},
+	"popularity": {expr: "books.sold_count", cast: "integer", desc: true},
+	"rating":     {expr: "books.rating_avg", cast: "numeric", desc: true},
+	// the expression depends on the search query, see bookPaging.sortExpr
+	sortRelevance: {cast: "real", desc: true},
+}
+
+// bookCursor marks the last book of a page. It carries the sort it was
+// made for so it can't be replayed against a different order.
+type bookCursor struct {
+	Sort string `json:"s"`
+	Desc bool   `json:"d"`
+	Key  string `json:"k"`
+	ID   uint   `json:"id"`
+}
+
+// bookPaging holds the validated ListBooks sorting and paging parameters.
+// Either page or cursor is used, never both.
+type bookPaging struct {
+	sort      string
+	desc      bool
+	page      int
+	limit     int
+	cursor    *bookCursor
+	withTotal bool
+}
+
+// parseBookPaging validates sort, order, page, limit, cursor and count.
+// Without a sort, searches are ordered by relevance and everything else by
+// newest first.
+func parseBookPaging(c *gin.Context, searching bool) (*bookPaging, error) {
+	p := &bookPaging{sort: c.Query("sort")}
+	if p.sort == "" {
+		p.sort = "created_at"
+		if searching {
+			p.sort = sortRelevance
+		}
+	}
+	s, ok := bookSorts[p.sort]
+	if !ok {
+		names := make([]string, 0, len(bookSorts))
+		for name := range bookSorts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("sort must be one of %s", strings.Join(names, ", "))
+	}
+	if p.sort == sortRelevance && !searching {
+		return nil, fmt.Errorf("sort=relevance needs a search query in q")
+	}
+	switch c.Query("order") {
+	case "":
+		p.desc = s.desc
+	case "asc":
+		p.desc = false
+	case "desc":
+		p.desc = true
+	default:
+		return nil, fmt.Errorf("order must be asc or desc")
+	}
+
+	var err error
+	if p.page, p.limit, err = strictPagination(c); err != nil {
+		return nil, err
+	}
+	if raw := c.Query("cursor"); raw != "" {
+		if _, ok := c.GetQuery("page"); ok {
+			return nil, fmt.Errorf("use either page or cursor, not both")
+		}
+		if p.cursor, err = decodeBookCursor(raw); err != nil {
+			return nil, err
+		}
+		if p.cursor.Sort != p.sort || p.cursor.Desc != p.desc {
+			return nil, fmt.Errorf("cursor was made for a different sort order")
+		}
+	}
+	if p.withTotal, err = queryBool(c, "count", true); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// sortExpr is the SQL expression books are sorted on, with its arguments.
+func (p *bookPaging) sortExpr(f *bookFilter) (string, []interface{}) {
+	if p.sort == sortRelevance {
+		return "ts_rank_cd(books.search_vector, " + f.tsq + ")", f.tsqArgs
+	}
+	return bookSorts[p.sort].expr, nil
+}
+
+// sortKeySelect selects the sort value as sort_key, in a text form the
+// cursor can carry and CAST back.
+func (p *bookPaging) sortKeySelect(f *bookFilter) (string, []interface{}) {
+	expr, args := p.sortExpr(f)
+	return "to_json(" + expr + ") #>> '{}' AS sort_key", args
+}
+
+// apply orders q and limits it to the requested page, fetching one extra
+// row so the caller can tell whether another page follows.
+func (p *bookPaging) apply(q *gorm.DB, f *bookFilter) *gorm.DB {
+	expr, args := p.sortExpr(f)
+	dir, cmp := "ASC", ">"
+	if p.desc {
+		dir, cmp = "DESC", "<"
+	}
+	if p.cursor != nil {
+		keyset := fmt.Sprintf("((%s), books.id) %s (CAST(? AS %s), ?)", expr, cmp, bookSorts[p.sort].cast)
+		q = q.Where(keyset, append(append([]interface{}{}, args...), p.cursor.Key, p.cursor.ID)...)
+	} else {
+		q = q.Offset((p.page - 1) * p.limit)
+	}
+	return q.Clauses(clause.OrderBy{Expression: clause.Expr{
+		SQL:                fmt.Sprintf("(%s) %s, books.id %s", expr, dir, dir),
+		Vars:               args,
+		WithoutParentheses: true,
+	}}).Limit(p.limit + 1)
+}
+
+// nextCursor returns the cursor continuing after book, which must have been
+// loaded with its sort key selected.
+func (p *bookPaging) nextCursor(book models.Book) string {
+	cur := bookCursor{Sort: p.sort, Desc: p.desc, ID: book.ID}
+	if book.SortKey != nil {
+		cur.Key = *book.SortKey
+	}
+	b, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeBookCursor(raw string) (*bookCursor, error) {
+	var cur bookCursor
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err == nil {
+		err = json.Unmarshal(b, &cur)
+	}
+	if err != nil || cur.ID == 0 {
+		return nil, fmt.Errorf("cursor is invalid")
+	}
+	s, ok := bookSorts[cur.Sort]
+	if !ok || !validCursorKey(s.cast, cur.Key) {
+		return nil, fmt.Errorf("cursor is invalid")
+	}
+	return &cur, nil
+}
+
+func validCursorKey(cast, key string) bool {
+	var err error
+	switch cast {
+	case "integer":
+		_, err = strconv.ParseInt(key, 10, 32)
+	case "numeric", "real":
+		_, err = strconv.ParseFloat(key, 64)
+	case "timestamptz":
+		_, err = time.Parse(time.RFC3339Nano, key)
+	}
+	return err == nil
+}
@@ -1,106 +1,264 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
+	"bookstore-api/app/config"
 	"bookstore-api/app/dto"
+	"bookstore-api/app/imaging"
 	"bookstore-api/app/models"
+	"bookstore-api/app/notify"
+	"bookstore-api/app/services"
+	"bookstore-api/app/storage"
 	"bookstore-api/app/utils"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // CreateBook godoc
 // @Summary Create book
+// @Description Accepts multipart/form-data with an optional "image" cover file, or plain JSON without a cover
 // @Tags Books
 // @Security BearerAuth
+// @Accept multipart/form-data
 // @Accept json
 // @Produce json
-// @Param request body dto.CreateBook true "Book info"
+// @Param request formData dto.CreateBook true "Book info"
+// @Param image formData file false "Cover image"
 // @Success 201 {object} map[string]interface{}
 // @Router /books [post]
-func CreateBook(db *gorm.DB) gin.HandlerFunc {
+func CreateBook(db *gorm.DB, store storage.Storage) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req dto.CreateBook
-		if err := c.ShouldBindJSON(&req); err != nil {
+		if err := c.ShouldBind(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Currency == "" {
+			req.Currency = models.BaseCurrency
+		}
+		if _, err := newCurrencyConverter(db, req.Currency); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !categoryExists(db, req.CategoryID) {
+			utils.JSONError(c, http.StatusBadRequest, "category not found")
+			return
+		}
+		isbn, err := normalizeISBN(req.ISBN)
+		if err != nil {
 			utils.JSONError(c, http.StatusBadRequest, err.Error())
 			return
 		}
+		if isbn != nil && isbnTaken(db, *isbn, 0) {
+			utils.JSONError(c, http.StatusConflict, "a book with this ISBN already exists")
+			return
+		}
+		if req.Format == "" {
+			req.Format = models.FormatPaperback
+		}
 		book := models.Book{
-			Title: req.Title, Author: req.Author, Price: req.Price,
-			Stock: req.Stock, Year: req.Year, CategoryID: req.CategoryID, ImageBase64: req.ImageBase64,
+			Title: req.Title, Author: req.Author, Price: req.Price, Currency: req.Currency,
+			Stock: req.Stock, ReorderThreshold: req.ReorderThreshold, ReorderQuantity: req.ReorderQuantity,
+			ReleaseDate: req.ReleaseDate, BackorderCap: req.BackorderCap,
+			Year: req.Year, CategoryID: req.CategoryID,
+			ISBN: isbn, Publisher: req.Publisher, Language: req.Language, PageCount: req.PageCount,
+			Edition: req.Edition, Format: req.Format, Description: req.Description,
+		}
+		if file, err := c.FormFile("image"); err == nil {
+			key, ext, err := uploadCover(c, store, file)
+			if err != nil {
+				utils.JSONError(c, uploadErrorStatus(err), err.Error())
+				return
+			}
+			book.ImageKey, book.ImageExt = key, ext
 		}
-		if err := db.Create(&book).Error; err != nil {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&book).Error; err != nil {
+				return err
+			}
+			if err := services.RecordInitialStock(tx, book, &userID); err != nil {
+				return err
+			}
+			if err := services.RecordInitialPrice(tx, book, &userID); err != nil {
+				return err
+			}
+			return services.LinkAuthorNames(tx, book.ID, book.Author)
+		})
+		if err != nil {
+			imaging.DeleteCover(c.Request.Context(), store, book.ImageKey, book.ImageExt)
 			utils.JSONError(c, http.StatusBadRequest, err.Error())
 			return
 		}
+		preloadAuthors(selectEffectivePrice(db.Preload("Category"))).First(&book, book.ID)
 		utils.JSONCreated(c, "Success Created Book Data", book)
 	}
 }
 
 // ListBooks godoc
 // @Summary List books
+// @Description price is the list price and effective_price what is charged now, after any sale; price filters and sorting use the effective price. stock is the total over all warehouses and warehouses the stock held by each. Filters combine with AND. Pages can be addressed by page number or, for deep paging, by the next_cursor of the previous page. Unless facets=false the response carries facet counts per category, price range and year, each computed without its own filter
 // @Tags Books
 // @Security BearerAuth
 // @Produce json
-// @Param page query int false "Page number"
-// @Param limit query int false "Items per page"
-// @Param q query string false "Search keyword (title or author)"
-// @Param category query string false "Filter by category id or name"
+// @Param page query int false "Page number, not combined with cursor"
+// @Param limit query int false "Items per page, at most 100"
+// @Param cursor query string false "next_cursor of the previous page, for stable deep paging"
+// @Param sort query string false "relevance, created_at, price, title, year, popularity or rating. Defaults to relevance when searching, otherwise created_at"
+// @Param order query string false "asc or desc, the default depends on sort"
+// @Param count query bool false "Include the total count (default true), skip it for speed"
+// @Param q query string false "Full-text search over title, author, description, category and ISBN. Use \"quotes\" for phrases and a trailing * for prefixes"
+// @Param category query string false "Filter by category id, slug or name, including its subcategories"
+// @Param author query string false "Filter by author id, slug or name"
+// @Param min_price query number false "Minimum price, in the requested currency"
+// @Param max_price query number false "Maximum price, in the requested currency"
+// @Param min_year query int false "Earliest publication year"
+// @Param max_year query int false "Latest publication year"
+// @Param in_stock query bool false "Only books with stock left, and ebooks"
+// @Param language query string false "BCP 47 language tag, e.g. id"
+// @Param format query string false "hardcover, paperback or ebook"
+// @Param currency query string false "Convert prices into this currency code"
+// @Param facets query bool false "Include facet counts (default true)"
 // @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
 // @Router /books [get]
 func ListBooks(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// pagination & search & filter
-		pageStr := c.DefaultQuery("page", "1")
-		limitStr := c.DefaultQuery("limit", "10")
-		q := c.Query("q") // title or author
-		cat := c.Query("category")
-
-		page, _ := strconv.Atoi(pageStr)
-		limit, _ := strconv.Atoi(limitStr)
-		if page < 1 {
-			page = 1
+		f, err := parseBookFilter(c)
+		if err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		p, err := parseBookPaging(c, f.searching)
+		if err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if badRequest, err := f.resolve(db); err != nil {
+			code := http.StatusInternalServerError
+			if badRequest {
+				code = http.StatusBadRequest
+			}
+			utils.JSONError(c, code, err.Error())
+			return
 		}
-		offset := (page - 1) * limit
 
 		var books []models.Book
-		query := db.Preload("Category").Model(&models.Book{})
-		if q != "" {
-			like := "%" + q + "%"
-			query = query.Where("title ILIKE ? OR author ILIKE ?", like, like)
-		}
-		if cat != "" {
-			// allow category id or name
-			if id, err := strconv.Atoi(cat); err == nil {
-				query = query.Where("category_id = ?", id)
-			} else {
-				// join category by name
-				query = query.Joins("JOIN categories ON categories.id = books.category_id").Where("categories.name ILIKE ?", "%"+cat+"%")
-			}
-		}
-		var total int64
-		query.Count(&total)
-		query = query.Limit(limit).Offset(offset).Order("id desc").Find(&books)
-		utils.JSONOk(c, gin.H{"items": books, "page": page, "limit": limit, "total": total})
+		query := f.apply(preloadWarehouseStock(preloadAuthors(db.Preload("Category"))).Model(&models.Book{}), "")
+		res := gin.H{"limit": p.limit}
+		if p.cursor == nil {
+			res["page"] = p.page
+		}
+		if p.withTotal {
+			var total int64
+			if err := query.Count(&total).Error; err != nil {
+				utils.JSONError(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			res["total"] = total
+		}
+
+		sel, args := p.sortKeySelect(f)
+		sel = "books.*, " + services.EffectivePriceSQL + " AS effective_price, " + sel
+		if f.searching {
+			// rank and highlight against the same tsquery used to filter
+			sel += `,
+				ts_rank_cd(books.search_vector, ` + f.tsq + `) AS search_rank,
+				ts_headline('bookstore_id', ` + htmlEscapeSQL("books.title") + `, ` + f.tsq + `, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true') AS title_highlight,
+				ts_headline('bookstore_id', ` + htmlEscapeSQL("coalesce(books.description, '')") + `, ` + f.tsq + `, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=25, MinWords=8') AS description_snippet`
+			for i := 0; i < 3; i++ {
+				args = append(args, f.tsqArgs...)
+			}
+		}
+		if err := p.apply(query.Select(sel, args...), f).Find(&books).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(books) > p.limit {
+			books = books[:p.limit]
+			res["next_cursor"] = p.nextCursor(books[len(books)-1])
+		}
+		if f.currency != "" {
+			for i := range books {
+				if err := f.cv.convertBook(&books[i]); err != nil {
+					utils.JSONError(c, http.StatusInternalServerError, err.Error())
+					return
+				}
+			}
+		}
+		res["items"] = books
+		if f.withFacets {
+			facets, err := f.facets(db)
+			if err != nil {
+				utils.JSONError(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			res["facets"] = facets
+		}
+		utils.JSONOk(c, res)
 	}
 }
 
 // GetBook godoc
 // @Summary Get book
+// @Description price is the list price and effective_price what is charged now, after any sale. stock is the total over all warehouses and warehouses the stock held by each
 // @Tags Books
 // @Security BearerAuth
 // @Produce json
 // @Param id path int true "Book ID"
+// @Param currency query string false "Convert price into this currency code"
 // @Success 200 {object} map[string]interface{}
 // @Router /books/{id} [get]
 func GetBook(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
 		var book models.Book
-		if err := db.Preload("Category").First(&book, id).Error; err != nil {
+		if err := preloadWarehouseStock(preloadAuthors(selectEffectivePrice(db.Preload("Category")))).First(&book, id).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "book not found")
+			return
+		}
+		if currency := c.Query("currency"); currency != "" {
+			cv, err := newCurrencyConverter(db, currency)
+			if err != nil {
+				utils.JSONError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			if err := cv.convertBook(&book); err != nil {
+				utils.JSONError(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		utils.JSONOk(c, book)
+	}
+}
+
+// GetBookByISBN godoc
+// @Summary Get book by ISBN
+// @Description Accepts ISBN-10 or ISBN-13, with or without hyphens
+// @Tags Books
+// @Security BearerAuth
+// @Produce json
+// @Param isbn path string true "ISBN"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /books/isbn/{isbn} [get]
+func GetBookByISBN(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		isbn, err := utils.NormalizeISBN(c.Param("isbn"))
+		if err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		var book models.Book
+		if err := preloadWarehouseStock(preloadAuthors(selectEffectivePrice(db.Preload("Category")))).Where("isbn = ?", isbn).First(&book).Error; err != nil {
 			utils.JSONError(c, http.StatusNotFound, "book not found")
 			return
 		}
@@ -110,15 +268,19 @@ func GetBook(db *gorm.DB) gin.HandlerFunc {
 
 // UpdateBook godoc
 // @Summary Update book
+// @Description Accepts multipart/form-data with an optional "image" file replacing the cover, or plain JSON.
+// @Description Setting stock changes it in the primary warehouse by the difference. Raising stock from zero notifies the users who have the book on their wishlist, and stock falling below reorder_threshold alerts the admins. A future release_date puts the book on pre-order; backorder_cap lets that many copies be ordered beyond stock
 // @Tags Books
 // @Security BearerAuth
+// @Accept multipart/form-data
 // @Accept json
 // @Produce json
 // @Param id path int true "Book ID"
-// @Param request body dto.UpdateBook true "Book info"
+// @Param request formData dto.UpdateBook true "Book info"
+// @Param image formData file false "Cover image"
 // @Success 200 {object} map[string]interface{}
 // @Router /books/{id} [put]
-func UpdateBook(db *gorm.DB) gin.HandlerFunc {
+func UpdateBook(db *gorm.DB, cfg *config.Config, store storage.Storage, notifier notify.Notifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id, _ := strconv.Atoi(c.Param("id"))
 		var book models.Book
@@ -128,7 +290,7 @@ func UpdateBook(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		var req dto.UpdateBook
-		if err := c.ShouldBindJSON(&req); err != nil {
+		if err := c.ShouldBind(&req); err != nil {
 			utils.JSONError(c, http.StatusBadRequest, err.Error())
 			return
 		}
@@ -140,34 +302,210 @@ func UpdateBook(db *gorm.DB) gin.HandlerFunc {
 		if req.Author != nil {
 			updates["author"] = *req.Author
 		}
-		if req.Price != nil {
-			updates["price"] = *req.Price
+		if req.Currency != nil {
+			if _, err := newCurrencyConverter(db, *req.Currency); err != nil {
+				utils.JSONError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		setPrice := req.Price != nil || req.Currency != nil
+		if req.ReorderThreshold != nil {
+			updates["reorder_threshold"] = *req.ReorderThreshold
+		}
+		if req.ReorderQuantity != nil {
+			updates["reorder_quantity"] = *req.ReorderQuantity
 		}
-		if req.Stock != nil {
-			updates["stock"] = *req.Stock
+		if req.ReleaseDate != nil {
+			updates["release_date"] = *req.ReleaseDate
+		}
+		if req.BackorderCap != nil {
+			updates["backorder_cap"] = *req.BackorderCap
 		}
 		if req.Year != nil {
 			updates["year"] = *req.Year
 		}
 		if req.CategoryID != nil {
+			if !categoryExists(db, *req.CategoryID) {
+				utils.JSONError(c, http.StatusBadRequest, "category not found")
+				return
+			}
 			updates["category_id"] = *req.CategoryID
 		}
-		if req.ImageBase64 != nil {
-			updates["image_base64"] = *req.ImageBase64
+		if req.ISBN != nil {
+			isbn, err := normalizeISBN(*req.ISBN)
+			if err != nil {
+				utils.JSONError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			if isbn != nil && isbnTaken(db, *isbn, book.ID) {
+				utils.JSONError(c, http.StatusConflict, "a book with this ISBN already exists")
+				return
+			}
+			updates["isbn"] = isbn
+		}
+		if req.Publisher != nil {
+			updates["publisher"] = *req.Publisher
+		}
+		if req.Language != nil {
+			updates["language"] = *req.Language
+		}
+		if req.PageCount != nil {
+			updates["page_count"] = *req.PageCount
+		}
+		if req.Edition != nil {
+			updates["edition"] = *req.Edition
+		}
+		if req.Format != nil {
+			updates["format"] = *req.Format
+		}
+		if req.Description != nil {
+			updates["description"] = *req.Description
+		}
+		oldImageKey, oldImageExt := book.ImageKey, book.ImageExt
+		if file, err := c.FormFile("image"); err == nil {
+			key, ext, err := uploadCover(c, store, file)
+			if err != nil {
+				utils.JSONError(c, uploadErrorStatus(err), err.Error())
+				return
+			}
+			updates["image_key"] = key
+			updates["image_ext"] = ext
 		}
 
-		if len(updates) > 0 {
-			if err := db.Model(&book).Updates(updates).Error; err != nil {
+		var restock *models.RestockEvent
+		var ready []uint
+		if len(updates) > 0 || req.Stock != nil || setPrice {
+			userIDv, _ := c.Get("user_id")
+			userID := userIDv.(uint)
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if len(updates) > 0 {
+					if err := tx.Model(&book).Updates(updates).Error; err != nil {
+						return err
+					}
+				}
+				if setPrice {
+					// list price changes go into the book's price history
+					if err := services.SetBookPrice(tx, book.ID, req.Price, req.Currency, &userID); err != nil {
+						return err
+					}
+				}
+				if req.Stock != nil {
+					// setting the stock is recorded as an adjustment by the difference
+					var locked models.Book
+					if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "stock").First(&locked, book.ID).Error; err != nil {
+						return err
+					}
+					if delta := *req.Stock - locked.Stock; delta != 0 {
+						reason := req.StockReason
+						if reason == "" {
+							reason = "stock set on book update"
+						}
+						var err error
+						// the difference is made up in the primary warehouse
+						_, restock, err = services.ApplyStockChange(tx, services.StockChange{
+							BookID: book.ID, Delta: delta, Type: models.MovementAdjustment, UserID: &userID, Reason: reason,
+						})
+						if err != nil {
+							return err
+						}
+					}
+				}
+				if req.ReorderThreshold != nil {
+					// a lower threshold the stock already meets ends the alert
+					if err := services.ClearLowStockAlert(tx, book.ID); err != nil {
+						return err
+					}
+				}
+				if req.Stock != nil || req.ReleaseDate != nil {
+					// new stock or an earlier release may let backorders ship
+					var err error
+					if ready, err = services.FulfilBackorders(tx, book.ID, cfg.TaxRate); err != nil {
+						return err
+					}
+				}
+				if req.Author != nil {
+					return services.LinkAuthorNames(tx, book.ID, *req.Author)
+				}
+				return nil
+			})
+			if err != nil {
+				if key, ok := updates["image_key"].(string); ok {
+					imaging.DeleteCover(c.Request.Context(), store, key, updates["image_ext"].(string))
+				}
+				if errors.Is(err, services.ErrInsufficientStock) {
+					utils.JSONError(c, http.StatusBadRequest, "the primary warehouse holds too few copies to lower the stock that far, record an adjustment against the other warehouses")
+					return
+				}
 				utils.JSONError(c, http.StatusInternalServerError, "failed to update book: "+err.Error())
 				return
 			}
 		}
+		if _, replaced := updates["image_key"]; replaced {
+			imaging.DeleteCover(c.Request.Context(), store, oldImageKey, oldImageExt)
+		}
+		services.NotifyRestockAsync(db, notifier, restock)
+		services.NotifyOrdersReadyAsync(db, notifier, ready)
+		if req.Stock != nil || req.ReorderThreshold != nil {
+			services.CheckLowStockAsync(db, notifier, book.ID)
+		}
 
+		preloadAuthors(selectEffectivePrice(db.Preload("Category"))).First(&book, book.ID)
 		utils.JSONOk(c, book)
 	}
 
 }
 
+// SetBookAuthors godoc
+// @Summary Set book authors
+// @Description Replaces the book's contributors, credited in the given order, and rebuilds its author byline
+// @Tags Books
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param request body dto.SetBookAuthorsRequest true "Contributors"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /books/{id}/authors [put]
+func SetBookAuthors(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var book models.Book
+		if err := db.First(&book, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "book not found")
+			return
+		}
+		var req dto.SetBookAuthorsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		credits := make([]services.AuthorCredit, len(req.Authors))
+		for i, a := range req.Authors {
+			var count int64
+			db.Model(&models.Author{}).Where("id = ?", a.AuthorID).Count(&count)
+			if count == 0 {
+				utils.JSONError(c, http.StatusBadRequest, fmt.Sprintf("author %d not found", a.AuthorID))
+				return
+			}
+			if a.Role == "" {
+				a.Role = models.RoleAuthor
+			}
+			credits[i] = services.AuthorCredit{AuthorID: a.AuthorID, Role: a.Role}
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return services.SetBookAuthors(tx, book.ID, credits)
+		}); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		preloadAuthors(selectEffectivePrice(db.Preload("Category"))).First(&book, book.ID)
+		utils.JSONOk(c, book)
+	}
+}
+
 // DeleteBook godoc
 // @Summary Delete book
 // @Tags Books
@@ -191,3 +529,34 @@ func DeleteBook(db *gorm.DB) gin.HandlerFunc {
 		utils.JSONOk(c, gin.H{"message": "deleted"})
 	}
 }
+
+// normalizeISBN validates an optional ISBN, an empty one means the book has
+// no ISBN.
+func normalizeISBN(s string) (*string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	isbn, err := utils.NormalizeISBN(s)
+	if err != nil {
+		return nil, err
+	}
+	return &isbn, nil
+}
+
+func categoryExists(db *gorm.DB, id uint) bool {
+	var count int64
+	db.Model(&models.Category{}).Where("id = ?", id).Count(&count)
+	return count > 0
+}
+
+func isbnTaken(db *gorm.DB, isbn string, exceptID uint) bool {
+	var count int64
+	db.Model(&models.Book{}).Where("isbn = ? AND id <> ?", isbn, exceptID).Count(&count)
+	return count > 0
+}
+
+// selectEffectivePrice selects books with their price after sales, see
+// services.EffectivePriceSQL.
+func selectEffectivePrice(q *gorm.DB) *gorm.DB {
+	return q.Select("books.*, " + services.EffectivePriceSQL + " AS effective_price")
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"bookstore-api/app/dto"
+	"bookstore-api/app/models"
+	"bookstore-api/app/services"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ListBundles godoc
+// @Summary List bundles
+// @Description available is how many complete bundles the stock of their books makes up
+// @Tags Bundles
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Param q query string false "Search by title"
+// @Param book_id query int false "Only bundles containing this book"
+// @Param in_stock query bool false "Only bundles with at least one available"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /bundles [get]
+func ListBundles(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, offset := pagination(c)
+		inStock, err := queryBool(c, "in_stock", false)
+		if err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		query := db.Model(&models.Bundle{})
+		if q := c.Query("q"); q != "" {
+			query = query.Where("bundles.title ILIKE ?", "%"+q+"%")
+		}
+		if b := c.Query("book_id"); b != "" {
+			query = query.Where("bundles.id IN (SELECT bundle_id FROM bundle_items WHERE book_id = ?)", b)
+		}
+		if inStock {
+			query = query.Where("COALESCE(" + services.BundleAvailableSQL + ", 1) > 0")
+		}
+		var total int64
+		query.Count(&total)
+		var bundles []models.Bundle
+		err = preloadBundle(query).Order("bundles.title asc").Limit(limit).Offset(offset).Find(&bundles).Error
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"items": bundles, "page": page, "limit": limit, "total": total})
+	}
+}
+
+// GetBundle godoc
+// @Summary Get bundle
+// @Tags Bundles
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Bundle ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /bundles/{id} [get]
+func GetBundle(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var b models.Bundle
+		if err := preloadBundle(db.Model(&models.Bundle{})).First(&b, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "bundle not found")
+			return
+		}
+		utils.JSONOk(c, b)
+	}
+}
+
+// CreateBundle godoc
+// @Summary Create bundle
+// @Description Admin puts books together, such as a series box set, at a bundle price
+// @Tags Bundles
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.BundleRequest true "Bundle"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /bundles [post]
+func CreateBundle(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req dto.BundleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		var b models.Bundle
+		if msg := applyBundleRequest(db, &b, req); msg != "" {
+			utils.JSONError(c, http.StatusBadRequest, msg)
+			return
+		}
+		if err := db.Create(&b).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		preloadBundle(db.Model(&models.Bundle{})).First(&b, b.ID)
+		utils.JSONCreated(c, "Success created bundle", b)
+	}
+}
+
+// UpdateBundle godoc
+// @Summary Update bundle
+// @Description The items replace the current ones. Orders already placed keep what they were sold
+// @Tags Bundles
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Bundle ID"
+// @Param request body dto.BundleRequest true "Bundle"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /bundles/{id} [put]
+func UpdateBundle(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var b models.Bundle
+		if err := db.First(&b, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "bundle not found")
+			return
+		}
+		var req dto.BundleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if msg := applyBundleRequest(db, &b, req); msg != "" {
+			utils.JSONError(c, http.StatusBadRequest, msg)
+			return
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("bundle_id = ?", b.ID).Delete(&models.BundleItem{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Omit("Items").Save(&b).Error; err != nil {
+				return err
+			}
+			return tx.Create(&b.Items).Error
+		})
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		preloadBundle(db.Model(&models.Bundle{})).First(&b, b.ID)
+		utils.JSONOk(c, b)
+	}
+}
+
+// DeleteBundle godoc
+// @Summary Delete bundle
+// @Description The bundle can no longer be ordered; past orders keep referring to it
+// @Tags Bundles
+// @Security BearerAuth
+// @Param id path int true "Bundle ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /bundles/{id} [delete]
+func DeleteBundle(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var b models.Bundle
+		if err := db.First(&b, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "bundle not found")
+			return
+		}
+		if err := db.Delete(&b).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"message": "deleted"})
+	}
+}
+
+// applyBundleRequest validates req and copies it into b, returning a
+// message for a 400 response if it is invalid.
+func applyBundleRequest(db *gorm.DB, b *models.Bundle, req dto.BundleRequest) string {
+	if req.Currency == "" {
+		req.Currency = models.BaseCurrency
+	}
+	if _, err := newCurrencyConverter(db, req.Currency); err != nil {
+		return err.Error()
+	}
+
+	b.Title, b.Description, b.Price, b.Currency = req.Title, req.Description, req.Price, req.Currency
+	b.Items = make([]models.BundleItem, 0, len(req.Items))
+	seen := map[uint]bool{}
+	for _, it := range req.Items {
+		if seen[it.BookID] {
+			return fmt.Sprintf("book %d is listed more than once", it.BookID)
+		}
+		seen[it.BookID] = true
+		var count int64
+		db.Model(&models.Book{}).Where("id = ?", it.BookID).Count(&count)
+		if count == 0 {
+			return fmt.Sprintf("book %d not found", it.BookID)
+		}
+		b.Items = append(b.Items, models.BundleItem{BundleID: b.ID, BookID: it.BookID, Quantity: it.Quantity})
+	}
+	return ""
+}
+
+// preloadBundle loads bundles with their books and availability.
+func preloadBundle(q *gorm.DB) *gorm.DB {
+	return q.Select("bundles.*, " + services.BundleAvailableSQL + " AS available").Preload("Items.Book")
+}
@@ -3,8 +3,12 @@ package handlers
 import (
 	"bookstore-api/app/dto"
 	"bookstore-api/app/models"
+	"bookstore-api/app/services"
 	"bookstore-api/app/utils"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -28,7 +32,18 @@ func CreateCategory(db *gorm.DB) gin.HandlerFunc {
 			utils.JSONError(c, http.StatusBadRequest, err.Error())
 			return
 		}
-		cat := models.Category{Name: req.Name}
+		if req.ParentID != nil {
+			var parent models.Category
+			if err := db.First(&parent, *req.ParentID).Error; err != nil {
+				utils.JSONError(c, http.StatusBadRequest, "parent category not found")
+				return
+			}
+		}
+		slugSource := req.Slug
+		if slugSource == "" {
+			slugSource = req.Name
+		}
+		cat := models.Category{Name: req.Name, ParentID: req.ParentID, Slug: services.UniqueCategorySlug(db, slugSource, 0)}
 		if err := db.Create(&cat).Error; err != nil {
 			utils.JSONError(c, http.StatusBadRequest, err.Error())
 			return
@@ -47,13 +62,87 @@ func CreateCategory(db *gorm.DB) gin.HandlerFunc {
 func ListCategories(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var cats []models.Category
-		db.Find(&cats)
+		if err := db.Find(&cats).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
 		utils.JSONOk(c, cats)
 	}
 }
 
+// CategoryTree godoc
+// @Summary Category tree
+// @Description Every category nested under its parent, with the number of books filed directly under it
+// @Tags Categories
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} dto.CategoryTreeNode
+// @Router /categories/tree [get]
+func CategoryTree(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var cats []models.Category
+		if err := db.Order("name asc").Find(&cats).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		counts, err := services.CategoryBookCounts(db)
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		nodes := make(map[uint]*dto.CategoryTreeNode, len(cats))
+		for _, cat := range cats {
+			nodes[cat.ID] = &dto.CategoryTreeNode{ID: cat.ID, Name: cat.Name, Slug: cat.Slug, BookCount: counts[cat.ID], Children: []*dto.CategoryTreeNode{}}
+		}
+		roots := []*dto.CategoryTreeNode{}
+		for _, cat := range cats {
+			node := nodes[cat.ID]
+			if parent := parentNode(nodes, cat.ParentID); parent != nil {
+				parent.Children = append(parent.Children, node)
+			} else {
+				roots = append(roots, node)
+			}
+		}
+		utils.JSONOk(c, roots)
+	}
+}
+
+// GetCategory godoc
+// @Summary Get category
+// @Description Category with its parent, direct children and book counts; total_book_count includes books in every descendant
+// @Tags Categories
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Category ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /categories/{id} [get]
+func GetCategory(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var cat models.Category
+		if err := db.Preload("Parent").Preload("Children", func(db *gorm.DB) *gorm.DB {
+			return db.Order("name asc")
+		}).First(&cat, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "category not found")
+			return
+		}
+
+		ids, err := services.CategoryDescendantIDs(db, []uint{cat.ID})
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		var direct, total int64
+		db.Model(&models.Book{}).Where("category_id = ?", cat.ID).Count(&direct)
+		db.Model(&models.Book{}).Where("category_id IN ?", ids).Count(&total)
+		utils.JSONOk(c, gin.H{"category": cat, "book_count": direct, "total_book_count": total})
+	}
+}
+
 // UpdateCategory godoc
 // @Summary Update books category
+// @Description Moving a category under itself or one of its descendants is rejected
 // @Tags Categories
 // @Security BearerAuth
 // @Accept json
@@ -77,18 +166,51 @@ func UpdateCategory(db *gorm.DB) gin.HandlerFunc {
 			utils.JSONError(c, http.StatusBadRequest, err.Error())
 			return
 		}
+		if req.ParentID != nil {
+			if *req.ParentID == cat.ID {
+				utils.JSONError(c, http.StatusBadRequest, "category cannot be its own parent")
+				return
+			}
+			var parent models.Category
+			if err := db.First(&parent, *req.ParentID).Error; err != nil {
+				utils.JSONError(c, http.StatusBadRequest, "parent category not found")
+				return
+			}
+			cycle, err := services.CategoryCreatesCycle(db, cat.ID, parent.ID)
+			if err != nil {
+				utils.JSONError(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if cycle {
+				utils.JSONError(c, http.StatusBadRequest, "category cannot be moved under its own descendant")
+				return
+			}
+		}
+
 		cat.Name = req.Name
-		db.Save(&cat)
+		cat.ParentID = req.ParentID
+		if req.Slug != "" {
+			cat.Slug = services.UniqueCategorySlug(db, req.Slug, cat.ID)
+		}
+		if err := db.Save(&cat).Error; err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
 		utils.JSONOk(c, cat)
 	}
 }
 
 // DeleteCategory godoc
 // @Summary Delete category
+// @Description Refused with 409 while books or subcategories still use the category, unless reassign_to names a category to move them to first
 // @Tags Categories
 // @Security BearerAuth
 // @Param id path int true "Category ID"
+// @Param reassign_to query int false "Category ID that receives the books and subcategories"
 // @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
 // @Router /categories/{id} [delete]
 func DeleteCategory(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -98,7 +220,80 @@ func DeleteCategory(db *gorm.DB) gin.HandlerFunc {
 			utils.JSONError(c, http.StatusNotFound, "category not found")
 			return
 		}
-		db.Delete(&cat)
-		utils.JSONOk(c, gin.H{"message": "deleted"})
+
+		var target *models.Category
+		if s := c.Query("reassign_to"); s != "" {
+			targetID, err := strconv.Atoi(s)
+			if err != nil {
+				utils.JSONError(c, http.StatusBadRequest, "reassign_to must be a category id")
+				return
+			}
+			target = &models.Category{}
+			if err := db.First(target, targetID).Error; err != nil {
+				utils.JSONError(c, http.StatusBadRequest, "reassign_to category not found")
+				return
+			}
+			// the target can't be the category itself or live below it
+			inSubtree, err := services.CategoryCreatesCycle(db, cat.ID, target.ID)
+			if err != nil {
+				utils.JSONError(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if inSubtree {
+				utils.JSONError(c, http.StatusBadRequest, "reassign_to must not be the category or one of its subcategories")
+				return
+			}
+		}
+
+		var books, children int64
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// soft deleted books still reference the category
+			if err := tx.Unscoped().Model(&models.Book{}).Where("category_id = ?", cat.ID).Count(&books).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Category{}).Where("parent_id = ?", cat.ID).Count(&children).Error; err != nil {
+				return err
+			}
+			if books+children > 0 {
+				if target == nil {
+					return errCategoryInUse
+				}
+				if err := tx.Unscoped().Model(&models.Book{}).Where("category_id = ?", cat.ID).
+					Update("category_id", target.ID).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&models.Category{}).Where("parent_id = ?", cat.ID).
+					Update("parent_id", target.ID).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Delete(&cat).Error
+		})
+		if errors.Is(err, errCategoryInUse) {
+			utils.JSONError(c, http.StatusConflict,
+				fmt.Sprintf("category still has %d books and %d subcategories, pass reassign_to to move them", books, children))
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		resp := gin.H{"message": "deleted"}
+		if target != nil {
+			resp["reassigned_to"] = target.ID
+			resp["books_moved"] = books
+			resp["subcategories_moved"] = children
+		}
+		utils.JSONOk(c, resp)
+	}
+}
+
+var errCategoryInUse = errors.New("category in use")
+
+func parentNode(nodes map[uint]*dto.CategoryTreeNode, parentID *uint) *dto.CategoryTreeNode {
+	if parentID == nil {
+		return nil
 	}
+	return nodes[*parentID]
 }
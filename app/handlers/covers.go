@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"bookstore-api/app/imaging"
+	"bookstore-api/app/storage"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServeCover godoc
+// @Summary Get book cover image
+// @Description Streams a cover variant. Keys are unique per upload, so responses are cacheable forever.
+// @Tags Books
+// @Produce image/jpeg
+// @Produce image/png
+// @Param path path string true "Cover path from the book's image_urls"
+// @Success 200 {file} file
+// @Failure 404 {object} map[string]interface{}
+// @Router /covers/{path} [get]
+func ServeCover(store storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "covers" + c.Param("path")
+		if path.Clean(key) != key {
+			utils.JSONError(c, http.StatusNotFound, "image not found")
+			return
+		}
+
+		etag := `"` + etagFor(key) + `"`
+		cacheControl := "public, max-age=31536000, immutable"
+		if match := c.GetHeader("If-None-Match"); match != "" && strings.Contains(match, etag) {
+			c.Header("ETag", etag)
+			c.Header("Cache-Control", cacheControl)
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		rc, err := store.Get(c.Request.Context(), key)
+		if errors.Is(err, storage.ErrNotFound) {
+			utils.JSONError(c, http.StatusNotFound, "image not found")
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer rc.Close()
+
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", cacheControl)
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.DataFromReader(http.StatusOK, -1, mime.TypeByExtension(path.Ext(key)), rc, nil)
+	}
+}
+
+// uploadCover validates an uploaded image and stores every variant of it,
+// returning the base key and extension to save on the book.
+func uploadCover(c *gin.Context, store storage.Storage, file *multipart.FileHeader) (string, string, error) {
+	if file.Size > imaging.MaxFileSize {
+		return "", "", imaging.ErrTooLarge
+	}
+	f, err := file.Open()
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, imaging.MaxFileSize+1))
+	if err != nil {
+		return "", "", err
+	}
+	return imaging.SaveCover(c.Request.Context(), store, data)
+}
+
+func uploadErrorStatus(err error) int {
+	if imaging.IsInvalid(err) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func etagFor(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"bookstore-api/app/config"
+	"bookstore-api/app/dto"
+	"bookstore-api/app/models"
+	"bookstore-api/app/services"
+	"bookstore-api/app/storage"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxEbookSize = 200 << 20
+
+var ebookTypes = map[string]string{
+	".pdf":  "application/pdf",
+	".epub": "application/epub+zip",
+}
+
+// UploadEbookFile godoc
+// @Summary Upload ebook file
+// @Description Stores the PDF or EPUB file of an ebook, up to 200 MB, replacing any earlier one. Ebooks can only be ordered once they have a file
+// @Tags Ebooks
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param file formData file true "PDF or EPUB file"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /books/{id}/file [put]
+func UploadEbookFile(db *gorm.DB, store storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var book models.Book
+		if err := db.First(&book, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "book not found")
+			return
+		}
+		if book.Format != models.FormatEbook {
+			utils.JSONError(c, http.StatusBadRequest, "only ebooks have a file")
+			return
+		}
+		file, err := c.FormFile("file")
+		if err != nil {
+			utils.JSONError(c, http.StatusBadRequest, "file is required")
+			return
+		}
+		ext := strings.ToLower(path.Ext(file.Filename))
+		contentType, ok := ebookTypes[ext]
+		if !ok {
+			utils.JSONError(c, http.StatusBadRequest, "file must be a PDF or EPUB")
+			return
+		}
+		if file.Size > maxEbookSize {
+			utils.JSONError(c, http.StatusBadRequest, "file must be at most 200 MB")
+			return
+		}
+		f, err := file.Open()
+		if err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		defer f.Close()
+
+		key := utils.NewFileKey("ebooks", ext)
+		if err := store.Put(c.Request.Context(), key, f, file.Size, contentType); err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		oldKey := book.FileKey
+		err = db.Model(&book).Updates(map[string]interface{}{
+			"file_key": key, "file_name": path.Base(file.Filename), "file_type": contentType, "file_size": file.Size,
+		}).Error
+		if err != nil {
+			store.Delete(c.Request.Context(), key)
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		// earlier buyers download the new file from now on
+		if oldKey != "" {
+			store.Delete(c.Request.Context(), oldKey)
+		}
+		preloadAuthors(selectEffectivePrice(db.Preload("Category"))).First(&book, book.ID)
+		utils.JSONOk(c, book)
+	}
+}
+
+// MyLibrary godoc
+// @Summary List own ebooks
+// @Description Every ebook bought with a paid order, newest first, with a download link valid for DOWNLOAD_LINK_TTL (default 15 minutes) while the order has downloads left. Each order allows DOWNLOAD_LIMIT (default 5) downloads of each ebook in it
+// @Tags Ebooks
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Success 200 {object} map[string]interface{}
+// @Router /me/library [get]
+func MyLibrary(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+		page, limit, offset := pagination(c)
+
+		query := db.Table("order_items").Joins("JOIN orders ON orders.id = order_items.order_id").
+			Where("orders.user_id = ? AND orders.status = ? AND order_items.digital", userID, models.OrderPaid)
+		var total int64
+		query.Count(&total)
+		var rows []struct {
+			ID      uint
+			OrderID uint
+			BookID  uint
+			PaidAt  *time.Time
+		}
+		err := query.Select("order_items.id, order_items.order_id, order_items.book_id, orders.paid_at").
+			Order("orders.paid_at desc, order_items.id").Limit(limit).Offset(offset).Scan(&rows).Error
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		itemIDs := make([]uint, len(rows))
+		bookIDs := make([]uint, len(rows))
+		for i, r := range rows {
+			itemIDs[i], bookIDs[i] = r.ID, r.BookID
+		}
+		counts, err := services.DownloadCounts(db, itemIDs)
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		// books taken off sale stay in the library
+		var books []models.Book
+		if len(bookIDs) > 0 {
+			if err := db.Unscoped().Where("id IN ?", bookIDs).Find(&books).Error; err != nil {
+				utils.JSONError(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		booksByID := make(map[uint]models.Book, len(books))
+		for _, b := range books {
+			booksByID[b.ID] = b
+		}
+
+		now := time.Now()
+		items := make([]dto.LibraryItem, len(rows))
+		for i, r := range rows {
+			book := booksByID[r.BookID]
+			it := dto.LibraryItem{
+				OrderID: r.OrderID, OrderItemID: r.ID, BookID: r.BookID, Title: book.Title, Author: book.Author,
+				FileType: book.FileType, FileSize: book.FileSize, PurchasedAt: r.PaidAt,
+				Downloads: counts[r.ID], DownloadsLeft: cfg.DownloadLimit - counts[r.ID],
+			}
+			if it.DownloadsLeft < 0 {
+				it.DownloadsLeft = 0
+			}
+			if it.DownloadsLeft > 0 && book.FileKey != "" && !services.OnPreorder(book, now) {
+				url, expires := services.DownloadURL(cfg.DownloadSecret, r.ID, cfg.DownloadLinkTTL)
+				it.DownloadURL, it.ExpiresAt = url, &expires
+			}
+			items[i] = it
+		}
+		utils.JSONOk(c, gin.H{"items": items, "page": page, "limit": limit, "total": total})
+	}
+}
+
+// DownloadEbook godoc
+// @Summary Download an ebook
+// @Description Streams the ebook file for a download link from GET /me/library. Links need no token but are signed and expire, and every download counts against the order's limit
+// @Tags Ebooks
+// @Produce application/pdf
+// @Produce application/epub+zip
+// @Param id path int true "Order item ID"
+// @Param expires query int true "Expiry from the link"
+// @Param signature query string true "Signature from the link"
+// @Success 200 {file} file
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 410 {object} map[string]interface{}
+// @Router /downloads/{id} [get]
+func DownloadEbook(db *gorm.DB, cfg *config.Config, store storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			utils.JSONError(c, http.StatusNotFound, "download not found")
+			return
+		}
+		if err := services.VerifyDownloadURL(cfg.DownloadSecret, uint(id), c.Query("expires"), c.Query("signature")); err != nil {
+			utils.JSONError(c, http.StatusForbidden, err.Error())
+			return
+		}
+		d, err := services.FindDownload(db, uint(id))
+		if errors.Is(err, services.ErrEbookNotAvailable) {
+			utils.JSONError(c, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// open the file before counting the download, so a missing file
+		// doesn't use one up
+		rc, err := store.Get(c.Request.Context(), d.Book.FileKey)
+		if errors.Is(err, storage.ErrNotFound) {
+			utils.JSONError(c, http.StatusNotFound, "ebook file not found")
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer rc.Close()
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return services.RecordDownload(tx, d, cfg.DownloadLimit, c.ClientIP(), c.Request.UserAgent())
+		})
+		if errors.Is(err, services.ErrDownloadLimit) {
+			utils.JSONError(c, http.StatusGone, err.Error())
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		name := d.Book.FileName
+		if name == "" {
+			name = "ebook" + path.Ext(d.Book.FileKey)
+		}
+		c.DataFromReader(http.StatusOK, d.Book.FileSize, d.Book.FileType, rc, map[string]string{
+			"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": name}),
+			"Cache-Control":          "private, no-store",
+			"X-Content-Type-Options": "nosniff",
+		})
+	}
+}
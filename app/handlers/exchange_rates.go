@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"bookstore-api/app/dto"
+	"bookstore-api/app/models"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CreateExchangeRate godoc
+// @Summary Create exchange rate
+// @Description Admin records the base currency (IDR) value of one unit of a currency, effective from the given time
+// @Tags Exchange Rates
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.ExchangeRateRequest true "Exchange rate info"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /exchange-rates [post]
+func CreateExchangeRate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req dto.ExchangeRateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Currency == models.BaseCurrency {
+			utils.JSONError(c, http.StatusBadRequest, "rate of the base currency is always 1")
+			return
+		}
+		rate := models.ExchangeRate{Currency: req.Currency, Rate: req.Rate, EffectiveFrom: time.Now()}
+		if req.EffectiveFrom != nil {
+			rate.EffectiveFrom = *req.EffectiveFrom
+		}
+		if err := db.Create(&rate).Error; err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.JSONCreated(c, "Success created exchange rate", rate)
+	}
+}
+
+// ListExchangeRates godoc
+// @Summary List exchange rates
+// @Tags Exchange Rates
+// @Security BearerAuth
+// @Produce json
+// @Param currency query string false "Filter by currency code"
+// @Success 200 {array} map[string]interface{}
+// @Router /exchange-rates [get]
+func ListExchangeRates(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rates []models.ExchangeRate
+		q := db.Order("currency asc, effective_from desc")
+		if cur := c.Query("currency"); cur != "" {
+			q = q.Where("currency = ?", strings.ToUpper(cur))
+		}
+		q.Find(&rates)
+		utils.JSONOk(c, rates)
+	}
+}
+
+// DeleteExchangeRate godoc
+// @Summary Delete exchange rate
+// @Tags Exchange Rates
+// @Security BearerAuth
+// @Param id path int true "Exchange rate ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /exchange-rates/{id} [delete]
+func DeleteExchangeRate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rate models.ExchangeRate
+		id := c.Param("id")
+		if err := db.First(&rate, id).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "exchange rate not found")
+			return
+		}
+		if err := db.Delete(&rate).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"message": "deleted"})
+	}
+}
+
+// currencyConverter converts amounts into a target currency using the rates
+// in effect at a fixed point in time, caching every rate it looks up.
+type currencyConverter struct {
+	db     *gorm.DB
+	target string
+	at     time.Time
+	rates  map[string]float64
+}
+
+func newCurrencyConverter(db *gorm.DB, target string) (*currencyConverter, error) {
+	cv := &currencyConverter{
+		db:     db,
+		target: strings.ToUpper(target),
+		at:     time.Now(),
+		rates:  map[string]float64{models.BaseCurrency: 1},
+	}
+	if _, err := cv.rate(cv.target); err != nil {
+		return nil, err
+	}
+	return cv, nil
+}
+
+// rate returns the base currency value of one unit of currency.
+func (cv *currencyConverter) rate(currency string) (float64, error) {
+	if r, ok := cv.rates[currency]; ok {
+		return r, nil
+	}
+	var er models.ExchangeRate
+	if err := cv.db.Where("currency = ? AND effective_from <= ?", currency, cv.at).
+		Order("effective_from desc").First(&er).Error; err != nil {
+		return 0, fmt.Errorf("no exchange rate for currency %s", currency)
+	}
+	cv.rates[currency] = er.Rate
+	return er.Rate, nil
+}
+
+func (cv *currencyConverter) convert(amount float64, from string) (float64, error) {
+	if from == cv.target {
+		return amount, nil
+	}
+	fromRate, err := cv.rate(from)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := cv.rate(cv.target)
+	if err != nil {
+		return 0, err
+	}
+	return math.Round(amount*fromRate/toRate*100) / 100, nil
+}
+
+// convertBook rewrites the book's prices in the converter's target currency.
+// The book is only used for display and must not be saved afterwards.
+func (cv *currencyConverter) convertBook(book *models.Book) error {
+	price, err := cv.convert(book.Price, book.Currency)
+	if err != nil {
+		return err
+	}
+	if book.EffectivePrice != nil {
+		effective, err := cv.convert(*book.EffectivePrice, book.Currency)
+		if err != nil {
+			return err
+		}
+		book.EffectivePrice = &effective
+	}
+	book.Price = price
+	book.Currency = cv.target
+	return nil
+}
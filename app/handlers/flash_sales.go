@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"bookstore-api/app/config"
+	"bookstore-api/app/dto"
+	"bookstore-api/app/flashsale"
+	"bookstore-api/app/models"
+	"bookstore-api/app/notify"
+	"bookstore-api/app/services"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ListFlashSales godoc
+// @Summary List flash sales
+// @Description left is how many copies can still be reserved, 0 once a sale has ended
+// @Tags Flash Sales
+// @Security BearerAuth
+// @Produce json
+// @Param status query string false "running, upcoming or ended. Defaults to running and upcoming"
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /flash-sales [get]
+func ListFlashSales(db *gorm.DB, counter flashsale.Counter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, offset := pagination(c)
+		query := db.Model(&models.FlashSale{})
+		now := time.Now()
+		switch c.Query("status") {
+		case "":
+			query = query.Where("ends_at > ?", now)
+		case "running":
+			query = query.Where("starts_at <= ? AND ends_at > ?", now, now)
+		case "upcoming":
+			query = query.Where("starts_at > ?", now)
+		case "ended":
+			query = query.Where("ends_at <= ?", now)
+		default:
+			utils.JSONError(c, http.StatusBadRequest, "status must be running, upcoming or ended")
+			return
+		}
+		var total int64
+		query.Count(&total)
+		var sales []models.FlashSale
+		if err := query.Preload("Book").Order("starts_at asc, id asc").Limit(limit).Offset(offset).Find(&sales).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for i := range sales {
+			if err := fillFlashSaleLeft(c, db, counter, &sales[i]); err != nil {
+				utils.JSONError(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		utils.JSONOk(c, gin.H{"items": sales, "page": page, "limit": limit, "total": total})
+	}
+}
+
+// GetFlashSale godoc
+// @Summary Get flash sale
+// @Tags Flash Sales
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Flash sale ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /flash-sales/{id} [get]
+func GetFlashSale(db *gorm.DB, counter flashsale.Counter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var sale models.FlashSale
+		if err := db.Preload("Book").First(&sale, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "flash sale not found")
+			return
+		}
+		if err := fillFlashSaleLeft(c, db, counter, &sale); err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, sale)
+	}
+}
+
+// CreateFlashSale godoc
+// @Summary Create flash sale
+// @Description Admin puts a limited quantity of a book on sale at price (in the book's currency) between starts_at and ends_at, with an optional per_user_limit. Unless the book is an ebook the copies are taken out of stock and held for the sale, and those left unsold go back to stock after ends_at
+// @Tags Flash Sales
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.FlashSaleRequest true "Flash sale"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /flash-sales [post]
+func CreateFlashSale(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		var req dto.FlashSaleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !req.EndsAt.After(req.StartsAt) || !req.EndsAt.After(time.Now()) {
+			utils.JSONError(c, http.StatusBadRequest, "ends_at must be after starts_at and in the future")
+			return
+		}
+		var book models.Book
+		if err := db.First(&book, req.BookID).Error; err != nil {
+			utils.JSONError(c, http.StatusBadRequest, "book not found")
+			return
+		}
+
+		sale := models.FlashSale{
+			BookID: book.ID, Price: req.Price, Quantity: req.Quantity, PerUserLimit: req.PerUserLimit,
+			StartsAt: req.StartsAt, EndsAt: req.EndsAt, UserID: userID,
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&sale).Error; err != nil {
+				return err
+			}
+			return services.HoldFlashSaleStock(tx, sale)
+		})
+		if errors.Is(err, services.ErrInsufficientStock) {
+			utils.JSONError(c, http.StatusBadRequest, "quantity exceeds stock for book "+book.Title)
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		sale.Book = book
+		utils.JSONCreated(c, "Success created flash sale", sale)
+	}
+}
+
+// ReserveFlashSale godoc
+// @Summary Reserve flash sale copies
+// @Description Holds copies of a running flash sale for FLASH_SALE_HOLD (default 5 minutes), within the per-user limit. Check the reservation out to order them at the sale price
+// @Tags Flash Sales
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Flash sale ID"
+// @Param request body dto.ReserveFlashSaleRequest true "How many"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /flash-sales/{id}/reserve [post]
+func ReserveFlashSale(db *gorm.DB, cfg *config.Config, counter flashsale.Counter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		var req dto.ReserveFlashSaleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		var sale models.FlashSale
+		if err := db.First(&sale, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "flash sale not found")
+			return
+		}
+		r, err := services.ReserveFlashSale(c.Request.Context(), db, counter, sale, userID, req.Quantity, cfg.FlashSaleHold)
+		switch {
+		case errors.Is(err, services.ErrFlashSaleNotRunning):
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+		case errors.Is(err, flashsale.ErrSoldOut), errors.Is(err, flashsale.ErrLimitReached):
+			utils.JSONError(c, http.StatusConflict, err.Error())
+		case err != nil:
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+		default:
+			utils.JSONCreated(c, "Success reserved flash sale", r)
+		}
+	}
+}
+
+// ListMyReservations godoc
+// @Summary List own flash sale reservations
+// @Tags Flash Sales
+// @Security BearerAuth
+// @Produce json
+// @Param status query string false "held, converted, released or expired"
+// @Success 200 {object} map[string]interface{}
+// @Router /me/reservations [get]
+func ListMyReservations(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		query := db.Where("user_id = ?", userID)
+		if s := c.Query("status"); s != "" {
+			query = query.Where("status = ?", s)
+		}
+		var reservations []models.FlashSaleReservation
+		if err := query.Preload("FlashSale.Book").Order("id desc").Limit(100).Find(&reservations).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, reservations)
+	}
+}
+
+// CheckoutReservation godoc
+// @Summary Order a flash sale reservation
+// @Description Turns a held reservation into an order for its copies at the flash sale price. If the order can't be placed the reservation is released
+// @Tags Flash Sales
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Reservation ID"
+// @Param request body dto.CheckoutReservationRequest false "Order currency"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /flash-sales/reservations/{id}/checkout [post]
+func CheckoutReservation(db *gorm.DB, cfg *config.Config, notifier notify.Notifier, counter flashsale.Counter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r, ok := findOwnReservation(c, db)
+		if !ok {
+			return
+		}
+		var req dto.CheckoutReservationRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				utils.JSONError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if req.Currency == "" {
+			req.Currency = models.BaseCurrency
+		}
+		cv, err := newCurrencyConverter(db, req.Currency)
+		if err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		rate, _ := cv.rate(cv.target)
+
+		var placed *services.PlaceOrderResult
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			placed, err = services.ConvertReservation(tx, r.ID, services.NewOrder{
+				UserID: r.UserID, Currency: cv.target, ExchangeRate: rate, Strategy: cfg.AllocationStrategy, Convert: cv.convert,
+			})
+			return err
+		})
+		var orderErr *services.OrderError
+		if errors.As(err, &orderErr) {
+			// the copies can't be had after all, let someone else try
+			if err := services.ReleaseReservation(c.Request.Context(), db, counter, r, models.ReservationReleased); err != nil {
+				log.Printf("flash sale %d: releasing reservation %d of user %d: %v", r.FlashSaleID, r.ID, r.UserID, err)
+			}
+			utils.JSONError(c, http.StatusBadRequest, orderErr.Message)
+			return
+		}
+		if errors.Is(err, services.ErrReservationNotHeld) {
+			utils.JSONError(c, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		services.CheckLowStockAsync(db, notifier, placed.BookIDs...)
+
+		order := placed.Order
+		if err := preloadOrder(db).First(&order, order.ID).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, "could not fetch order")
+			return
+		}
+		utils.JSONCreated(c, "Success Order Book", order)
+	}
+}
+
+// ReleaseFlashSaleReservation godoc
+// @Summary Release a flash sale reservation
+// @Description Gives the held copies back for someone else to reserve
+// @Tags Flash Sales
+// @Security BearerAuth
+// @Param id path int true "Reservation ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /flash-sales/reservations/{id} [delete]
+func ReleaseFlashSaleReservation(db *gorm.DB, counter flashsale.Counter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r, ok := findOwnReservation(c, db)
+		if !ok {
+			return
+		}
+		err := services.ReleaseReservation(c.Request.Context(), db, counter, r, models.ReservationReleased)
+		if errors.Is(err, services.ErrReservationNotHeld) {
+			utils.JSONError(c, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"message": "released"})
+	}
+}
+
+// findOwnReservation loads the reservation in the path and writes the
+// error response itself when it doesn't exist or isn't the caller's.
+func findOwnReservation(c *gin.Context, db *gorm.DB) (models.FlashSaleReservation, bool) {
+	userIDv, _ := c.Get("user_id")
+	userID := userIDv.(uint)
+
+	var r models.FlashSaleReservation
+	if err := db.First(&r, c.Param("id")).Error; err != nil {
+		utils.JSONError(c, http.StatusNotFound, "reservation not found")
+		return r, false
+	}
+	if r.UserID != userID {
+		utils.JSONError(c, http.StatusForbidden, "not authorized")
+		return r, false
+	}
+	return r, true
+}
+
+func fillFlashSaleLeft(c *gin.Context, db *gorm.DB, counter flashsale.Counter, sale *models.FlashSale) error {
+	left, err := services.FlashSaleLeft(c.Request.Context(), db, counter, *sale)
+	if err != nil {
+		return err
+	}
+	sale.Left = &left
+	return nil
+}
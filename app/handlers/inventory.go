@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"bookstore-api/app/models"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type lowStockBook struct {
+	ID                uint       `json:"id"`
+	Title             string     `json:"title"`
+	Author            string     `json:"author"`
+	Stock             int        `json:"stock"`
+	ReorderThreshold  int        `json:"reorder_threshold"`
+	ReorderQuantity   int        `json:"reorder_quantity"`
+	Incoming          int        `json:"incoming"`
+	LowStockAlertedAt *time.Time `json:"low_stock_alerted_at"`
+}
+
+// LowStockReport godoc
+// @Summary Low-stock report
+// @Description Books whose stock is below their reorder threshold, furthest below first. incoming is what sent purchase orders still have to deliver
+// @Tags Inventory
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Success 200 {object} map[string]interface{}
+// @Router /inventory/low-stock [get]
+func LowStockReport(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, offset := pagination(c)
+		query := db.Model(&models.Book{}).Where("books.stock < books.reorder_threshold")
+		var total int64
+		query.Count(&total)
+		var books []lowStockBook
+		err := query.Select(`books.id, books.title, books.author, books.stock, books.reorder_threshold,
+			books.reorder_quantity, books.low_stock_alerted_at,
+			COALESCE((SELECT SUM(i.quantity_ordered - i.quantity_received)
+				FROM purchase_order_items i JOIN purchase_orders po ON po.id = i.purchase_order_id
+				WHERE i.book_id = books.id AND po.status IN ?), 0) AS incoming`,
+			[]string{models.PurchaseSent, models.PurchasePartiallyReceived}).
+			Order("books.stock - books.reorder_threshold, books.id").
+			Limit(limit).Offset(offset).Scan(&books).Error
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"items": books, "page": page, "limit": limit, "total": total})
+	}
+}
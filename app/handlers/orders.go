@@ -1,13 +1,23 @@
 package handlers
 
 import (
+	"bookstore-api/app/config"
 	"bookstore-api/app/dto"
+	"bookstore-api/app/flashsale"
+	"bookstore-api/app/invoice"
 	"bookstore-api/app/models"
+	"bookstore-api/app/notify"
+	"bookstore-api/app/services"
 	"bookstore-api/app/utils"
+	"bytes"
+	"errors"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // CreateOrder godoc
@@ -16,10 +26,11 @@ import (
 // @Security BearerAuth
 // @Accept json
 // @Produce json
+// @Description Each item is a book, charged its effective price with any sale as the item's discount, or a bundle, which is ordered as the books in it at the bundle's price. Items are allocated to warehouses with the configured ALLOCATION_STRATEGY. Copies of pre-order books, or beyond the stock of backorderable books, are backordered: the order is then BACKORDERED, with an expected ship date, until stock arrives. Books left below their reorder threshold alert the admins. Up to store_credit of the total, in the order's currency, is paid from the wallet; an order paid in full that way is PAID at once, otherwise amount_due is paid with POST /orders/{id}/pay
 // @Param request body dto.CreateOrderRequest true "Order items"
 // @Success 201 {object} map[string]interface{}
 // @Router /orders [post]
-func CreateOrder(db *gorm.DB) gin.HandlerFunc {
+func CreateOrder(db *gorm.DB, cfg *config.Config, notifier notify.Notifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req dto.CreateOrderRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -30,60 +41,61 @@ func CreateOrder(db *gorm.DB) gin.HandlerFunc {
 		userIDv, _ := c.Get("user_id")
 		userID := userIDv.(uint)
 
+		if req.Currency == "" {
+			req.Currency = models.BaseCurrency
+		}
+		cv, err := newCurrencyConverter(db, req.Currency)
+		if err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		rate, _ := cv.rate(cv.target)
+
+		lines := make([]services.OrderLine, len(req.Items))
+		for i, it := range req.Items {
+			lines[i] = services.OrderLine{BookID: it.BookID, BundleID: it.BundleID, Quantity: it.Quantity}
+		}
 		tx := db.Begin()
 		if tx.Error != nil {
 			utils.JSONError(c, http.StatusInternalServerError, "could not start tx")
 			return
 		}
-
-		order := models.Order{UserID: userID, Status: "PENDING"}
-		if err := tx.Create(&order).Error; err != nil {
+		placed, err := services.PlaceOrder(tx, services.NewOrder{
+			UserID: userID, Currency: cv.target, ExchangeRate: rate, Lines: lines, Strategy: cfg.AllocationStrategy,
+			Convert: cv.convert,
+		})
+		var orderErr *services.OrderError
+		if errors.As(err, &orderErr) {
 			tx.Rollback()
-			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			utils.JSONError(c, http.StatusBadRequest, orderErr.Message)
 			return
 		}
-
-		total := 0.0
-		for _, it := range req.Items {
-			var book models.Book
-			if err := tx.Set("gorm:query_option", "FOR UPDATE").First(&book, it.BookID).Error; err != nil {
-				tx.Rollback()
-				utils.JSONError(c, http.StatusBadRequest, "book not found")
-				return
-			}
-			if it.Quantity > book.Stock {
-				tx.Rollback()
-				utils.JSONError(c, http.StatusBadRequest, "quantity exceeds stock for book "+book.Title)
-				return
-			}
-
-			book.Stock -= it.Quantity
-			if err := tx.Save(&book).Error; err != nil {
-				tx.Rollback()
-				utils.JSONError(c, http.StatusInternalServerError, err.Error())
-				return
-			}
-
-			price := book.Price * float64(it.Quantity)
-			oi := models.OrderItem{
-				OrderID: order.ID, BookID: book.ID, Quantity: it.Quantity, Price: book.Price,
+		if err == nil && req.StoreCredit > 0 {
+			order := &placed.Order
+			err = services.PayWithStoreCredit(tx, order, req.StoreCredit)
+			if err == nil && order.StoreCredit == order.TotalPrice && order.Status == models.OrderPending {
+				// paid in full with store credit
+				err = services.MarkOrderPaid(tx, order, cfg.TaxRate)
 			}
-			if err := tx.Create(&oi).Error; err != nil {
-				tx.Rollback()
-				utils.JSONError(c, http.StatusInternalServerError, err.Error())
-				return
-			}
-			total += price
 		}
-		order.TotalPrice = total
-		if err := tx.Save(&order).Error; err != nil {
+		if errors.Is(err, services.ErrInsufficientCredit) {
 			tx.Rollback()
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			tx.Rollback()
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err := tx.Commit().Error; err != nil {
 			utils.JSONError(c, http.StatusInternalServerError, err.Error())
 			return
 		}
-		tx.Commit()
+		services.CheckLowStockAsync(db, notifier, placed.BookIDs...)
 
-		if err := db.Preload("User").Preload("Items.Book.Category").Preload("Items.Book").First(&order, order.ID).Error; err != nil {
+		order := placed.Order
+		if err := preloadOrder(db).First(&order, order.ID).Error; err != nil {
 			utils.JSONError(c, http.StatusInternalServerError, "could not fetch order")
 			return
 		}
@@ -96,32 +108,236 @@ func CreateOrder(db *gorm.DB) gin.HandlerFunc {
 // @Summary Pay order
 // @Tags Orders
 // @Security BearerAuth
+// @Description Marks a pending order as PAID, its amount_due paid outside the store, and assigns its invoice number
 // @Param id path int true "Order ID"
 // @Success 200 {object} map[string]interface{}
 // @Router /orders/{id}/pay [post]
-func PayOrder(db *gorm.DB) gin.HandlerFunc {
+func PayOrder(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		order, ok := findAuthorizedOrder(c, db, c.Param("id"))
+		if !ok {
+			return
+		}
+		if order.Status == models.OrderBackordered {
+			utils.JSONError(c, http.StatusBadRequest, "order is still waiting for backordered books")
+			return
+		}
+		if order.Status != models.OrderPending {
+			utils.JSONError(c, http.StatusBadRequest, "order has been paid or cancelled")
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// re-check under lock so two concurrent payments can't both pass
+			var locked models.Order
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, order.ID).Error; err != nil {
+				return err
+			}
+			if locked.Status != models.OrderPending {
+				return errOrderNotPending
+			}
+			return services.MarkOrderPaid(tx, &locked, cfg.TaxRate)
+		})
+		if errors.Is(err, errOrderNotPending) {
+			utils.JSONError(c, http.StatusBadRequest, "order has been paid or cancelled")
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if err := preloadOrder(db).First(&order, order.ID).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, "could not fetch order")
+			return
+		}
+		utils.JSONOk(c, order)
+	}
+}
+
+// CancelOrder godoc
+// @Summary Cancel order
+// @Tags Orders
+// @Security BearerAuth
+// @Description Cancels a pending or backordered order and puts its items back in stock, where they go to backorders first. Store credit spent on it goes back to the wallet
+// @Param id path int true "Order ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /orders/{id}/cancel [post]
+func CancelOrder(db *gorm.DB, cfg *config.Config, notifier notify.Notifier, counter flashsale.Counter) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
+		order, ok := findAuthorizedOrder(c, db, c.Param("id"))
+		if !ok {
+			return
+		}
 		userIDv, _ := c.Get("user_id")
 		userID := userIDv.(uint)
-		var order models.Order
-		if err := db.Preload("User").Preload("Items.Book.Category").Preload("Items.Book").First(&order, id).Error; err != nil {
-			utils.JSONError(c, http.StatusNotFound, "order not found")
+
+		var restocks []*models.RestockEvent
+		var ready []uint
+		var reservation *models.FlashSaleReservation
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var locked models.Order
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, order.ID).Error; err != nil {
+				return err
+			}
+			if locked.Status != models.OrderPending && locked.Status != models.OrderBackordered {
+				return errOrderNotPending
+			}
+			// backorders may have been allocated since the order was read
+			var items []models.OrderItem
+			if err := tx.Preload("Allocations").Where("order_id = ?", locked.ID).Find(&items).Error; err != nil {
+				return err
+			}
+			// an order from a flash sale reservation gives its copies back to the sale
+			var rehold bool
+			var err error
+			reservation, rehold, err = services.ReleaseOrderReservation(tx, locked.ID, items)
+			if err != nil {
+				return err
+			}
+			var bookIDs []uint
+			for _, it := range items {
+				bookIDs = append(bookIDs, it.BookID)
+				if rehold {
+					continue
+				}
+				// items ordered before warehouses existed go back to the primary one
+				returns := it.Allocations
+				if len(returns) == 0 && it.Quantity > it.BackorderedQuantity && !it.Digital {
+					returns = []models.OrderItemAllocation{{Quantity: it.Quantity - it.BackorderedQuantity}}
+				}
+				for _, a := range returns {
+					_, ev, err := services.ApplyStockChange(tx, services.StockChange{
+						BookID: it.BookID, WarehouseID: a.WarehouseID, Delta: a.Quantity, Type: models.MovementCancellation,
+						OrderID: &order.ID, UserID: &userID, Reason: "order cancelled",
+					})
+					if err != nil {
+						return err
+					}
+					if ev != nil {
+						restocks = append(restocks, ev)
+					}
+				}
+			}
+			if err := tx.Model(&locked).Update("status", models.OrderCancelled).Error; err != nil {
+				return err
+			}
+			if _, err := services.RefundStoreCredit(tx, locked, &userID, "order cancelled"); err != nil {
+				return err
+			}
+			// the copies put back go to whoever waits for them, and those
+			// still waiting move up the queue
+			for _, bookID := range bookIDs {
+				ids, err := services.FulfilBackorders(tx, bookID, cfg.TaxRate)
+				if err != nil {
+					return err
+				}
+				ready = append(ready, ids...)
+			}
+			return nil
+		})
+		if errors.Is(err, errOrderNotPending) {
+			utils.JSONError(c, http.StatusBadRequest, "order has been paid or cancelled")
 			return
 		}
-		rolev, _ := c.Get("role")
-		role := rolev.(string)
-		if role != "admin" && order.UserID != userID {
-			utils.JSONError(c, http.StatusForbidden, "not authorized")
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
 			return
 		}
-		if order.Status != "PENDING" {
-			utils.JSONError(c, http.StatusBadRequest, "order has been paid or cancelled")
+		if reservation != nil {
+			if err := counter.Release(c.Request.Context(), reservation.FlashSaleID, reservation.UserID, reservation.Quantity); err != nil {
+				log.Printf("flash sale %d: giving back %d for user %d: %v", reservation.FlashSaleID, reservation.Quantity, reservation.UserID, err)
+			}
+		}
+		for _, ev := range restocks {
+			services.NotifyRestockAsync(db, notifier, ev)
+		}
+		services.NotifyOrdersReadyAsync(db, notifier, ready)
+
+		if err := preloadOrder(db).First(&order, order.ID).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, "could not fetch order")
+			return
+		}
+		utils.JSONOk(c, order)
+	}
+}
+
+// RefundOrder godoc
+// @Summary Refund order
+// @Tags Orders
+// @Security BearerAuth
+// @Description Admin refunds a paid order in full and marks it REFUNDED. With method store_credit the whole total goes to the customer's wallet; with original only the store credit spent on it does, the rest being refunded outside the store. Stock is not put back, record returned copies as a stock movement
+// @Accept json
+// @Produce json
+// @Param id path int true "Order ID"
+// @Param request body dto.RefundOrderRequest true "Refund"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /orders/{id}/refund [post]
+func RefundOrder(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req dto.RefundOrderRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		order, ok := findAuthorizedOrder(c, db, c.Param("id"))
+		if !ok {
 			return
 		}
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+		reason := req.Reason
+		if reason == "" {
+			reason = "order refunded"
+		}
 
-		order.Status = "PAID"
-		db.Save(&order)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var locked models.Order
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, order.ID).Error; err != nil {
+				return err
+			}
+			if locked.Status != models.OrderPaid {
+				return errOrderNotPaid
+			}
+			var err error
+			if req.Method == models.RefundStoreCredit {
+				_, err = services.RefundToStoreCredit(tx, locked, &userID, reason)
+			} else {
+				_, err = services.RefundStoreCredit(tx, locked, &userID, reason)
+			}
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&locked).Updates(map[string]interface{}{
+				"status":        models.OrderRefunded,
+				"refunded_at":   time.Now(),
+				"refund_method": req.Method,
+			}).Error; err != nil {
+				return err
+			}
+			// refunded copies no longer count as sold
+			return tx.Exec(`UPDATE books SET sold_count = GREATEST(sold_count - oi.quantity, 0)
+				FROM (SELECT book_id, SUM(quantity) AS quantity FROM order_items WHERE order_id = ? GROUP BY book_id) oi
+				WHERE books.id = oi.book_id`, locked.ID).Error
+		})
+		if errors.Is(err, errOrderNotPaid) {
+			utils.JSONError(c, http.StatusBadRequest, "only paid orders can be refunded")
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if err := preloadOrder(db).First(&order, order.ID).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, "could not fetch order")
+			return
+		}
 		utils.JSONOk(c, order)
 	}
 }
@@ -141,11 +357,11 @@ func ListOrders(db *gorm.DB) gin.HandlerFunc {
 		userID := userIDv.(uint)
 
 		var orders []models.Order
-		q := db.Preload("Items.Book").Order("id desc")
+		q := preloadOrder(db).Order("id desc")
 		if role != "admin" {
 			q = q.Where("user_id = ?", userID)
 		}
-		q.Preload("User").Preload("Items.Book.Category").Preload("Items.Book").Find(&orders)
+		q.Find(&orders)
 		utils.JSONOk(c, orders)
 	}
 }
@@ -160,21 +376,92 @@ func ListOrders(db *gorm.DB) gin.HandlerFunc {
 // @Router /orders/{id} [get]
 func GetOrder(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
-		rolev, _ := c.Get("role")
-		role := rolev.(string)
-		userIDv, _ := c.Get("user_id")
-		userID := userIDv.(uint)
+		order, ok := findAuthorizedOrder(c, db, c.Param("id"))
+		if !ok {
+			return
+		}
+		utils.JSONOk(c, order)
+	}
+}
 
-		var order models.Order
-		if err := db.Preload("User").Preload("Items.Book.Category").Preload("Items.Book").First(&order, id).Error; err != nil {
-			utils.JSONError(c, http.StatusNotFound, "order not found")
+// GetOrderInvoice godoc
+// @Summary Get order invoice
+// @Description Render the invoice of a paid order as HTML (default) or PDF
+// @Tags Orders
+// @Security BearerAuth
+// @Produce html
+// @Produce application/pdf
+// @Param id path int true "Order ID"
+// @Param format query string false "Invoice format" Enums(html, pdf)
+// @Success 200 {file} file
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /orders/{id}/invoice [get]
+func GetOrderInvoice(db *gorm.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		order, ok := findAuthorizedOrder(c, db, c.Param("id"))
+		if !ok {
 			return
 		}
-		if role != "admin" && order.UserID != userID {
-			utils.JSONError(c, http.StatusForbidden, "not authorized")
+		if order.InvoiceNumber == nil {
+			utils.JSONError(c, http.StatusBadRequest, "order has not been paid")
 			return
 		}
-		utils.JSONOk(c, order)
+
+		inv := invoice.New(&order, cfg)
+		var buf bytes.Buffer
+		switch c.DefaultQuery("format", "html") {
+		case "html":
+			if err := inv.RenderHTML(&buf); err != nil {
+				utils.JSONError(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+		case "pdf":
+			if err := inv.RenderPDF(&buf); err != nil {
+				utils.JSONError(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			c.Header("Content-Disposition", `inline; filename="`+inv.Filename("pdf")+`"`)
+			c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+		default:
+			utils.JSONError(c, http.StatusBadRequest, "format must be html or pdf")
+		}
+	}
+}
+
+var (
+	errOrderNotPending = errors.New("order is not pending")
+	errOrderNotPaid    = errors.New("order is not paid")
+)
+
+// preloadOrder loads an order's customer and items, with each item's book,
+// the bundle it was sold in, even one deleted since, and the warehouses it
+// ships from.
+func preloadOrder(db *gorm.DB) *gorm.DB {
+	return db.Preload("User").Preload("Items.Book.Category").
+		Preload("Items.Bundle", func(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }).
+		Preload("Items.Allocations.Warehouse")
+}
+
+// findAuthorizedOrder loads an order with its items and writes the error
+// response itself when the order doesn't exist or the caller is neither
+// its owner nor an admin.
+func findAuthorizedOrder(c *gin.Context, db *gorm.DB, id string) (models.Order, bool) {
+	rolev, _ := c.Get("role")
+	role := rolev.(string)
+	userIDv, _ := c.Get("user_id")
+	userID := userIDv.(uint)
+
+	var order models.Order
+	if err := preloadOrder(db).First(&order, id).Error; err != nil {
+		utils.JSONError(c, http.StatusNotFound, "order not found")
+		return order, false
+	}
+	if role != "admin" && order.UserID != userID {
+		utils.JSONError(c, http.StatusForbidden, "not authorized")
+		return order, false
 	}
+	return order, true
 }
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxPageLimit = 100
+
+// pagination reads the page and limit query parameters, falling back to
+// sane values when they are missing or out of range.
+func pagination(c *gin.Context) (page, limit, offset int) {
+	page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ = strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return page, limit, (page - 1) * limit
+}
+
+// strictPagination is like pagination but rejects malformed or non-positive
+// values instead of replacing them. Limits above maxPageLimit are capped.
+func strictPagination(c *gin.Context) (page, limit int, err error) {
+	if page, err = strconv.Atoi(c.DefaultQuery("page", "1")); err != nil || page < 1 {
+		return 0, 0, fmt.Errorf("page must be a positive integer")
+	}
+	if limit, err = strconv.Atoi(c.DefaultQuery("limit", "10")); err != nil || limit < 1 {
+		return 0, 0, fmt.Errorf("limit must be a positive integer")
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return page, limit, nil
+}
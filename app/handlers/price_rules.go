@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"bookstore-api/app/dto"
+	"bookstore-api/app/models"
+	"bookstore-api/app/services"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ListPriceRules godoc
+// @Summary List price rules
+// @Tags Pricing
+// @Security BearerAuth
+// @Produce json
+// @Param status query string false "active, scheduled or ended"
+// @Param book_id query int false "Only rules for this book, directly or through its categories"
+// @Param category_id query int false "Only rules for this category"
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /price-rules [get]
+func ListPriceRules(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, offset := pagination(c)
+		query := db.Model(&models.PriceRule{})
+		now := time.Now()
+		switch c.Query("status") {
+		case "":
+		case "active":
+			query = query.Where("starts_at <= ? AND (ends_at IS NULL OR ends_at > ?)", now, now)
+		case "scheduled":
+			query = query.Where("starts_at > ?", now)
+		case "ended":
+			query = query.Where("ends_at <= ?", now)
+		default:
+			utils.JSONError(c, http.StatusBadRequest, "status must be active, scheduled or ended")
+			return
+		}
+		if id := c.Query("book_id"); id != "" {
+			var book models.Book
+			if err := db.First(&book, id).Error; err != nil {
+				utils.JSONError(c, http.StatusNotFound, "book not found")
+				return
+			}
+			categories, err := services.CategoryAncestorIDs(db, book.CategoryID)
+			if err != nil {
+				utils.JSONError(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			query = query.Where("book_id = ? OR category_id IN ?", book.ID, categories)
+		}
+		if id := c.Query("category_id"); id != "" {
+			query = query.Where("category_id = ?", id)
+		}
+		var total int64
+		query.Count(&total)
+		var rules []models.PriceRule
+		err := query.Preload("Book").Preload("Category").
+			Order("starts_at desc, id desc").Limit(limit).Offset(offset).Find(&rules).Error
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"items": rules, "page": page, "limit": limit, "total": total})
+	}
+}
+
+// CreatePriceRule godoc
+// @Summary Schedule a sale
+// @Description Puts a book, or a category with its subcategories, on sale between starts_at (default now) and ends_at (open-ended if left out). Books take a sale_price or a percent_off, categories a percent_off. Where sales overlap the lowest price wins
+// @Tags Pricing
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.PriceRuleRequest true "Price rule"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /price-rules [post]
+func CreatePriceRule(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		var req dto.PriceRuleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if (req.BookID == nil) == (req.CategoryID == nil) {
+			utils.JSONError(c, http.StatusBadRequest, "give either book_id or category_id")
+			return
+		}
+		if (req.SalePrice == nil) == (req.PercentOff == nil) {
+			utils.JSONError(c, http.StatusBadRequest, "give either sale_price or percent_off")
+			return
+		}
+		if req.CategoryID != nil && req.SalePrice != nil {
+			utils.JSONError(c, http.StatusBadRequest, "a category can only be put on sale with percent_off")
+			return
+		}
+		if req.BookID != nil {
+			var count int64
+			db.Model(&models.Book{}).Where("id = ?", *req.BookID).Count(&count)
+			if count == 0 {
+				utils.JSONError(c, http.StatusBadRequest, "book not found")
+				return
+			}
+		}
+		if req.CategoryID != nil && !categoryExists(db, *req.CategoryID) {
+			utils.JSONError(c, http.StatusBadRequest, "category not found")
+			return
+		}
+		now := time.Now()
+		if req.StartsAt == nil {
+			req.StartsAt = &now
+		}
+		if req.EndsAt != nil && (!req.EndsAt.After(*req.StartsAt) || !req.EndsAt.After(now)) {
+			utils.JSONError(c, http.StatusBadRequest, "ends_at must be after starts_at and in the future")
+			return
+		}
+
+		rule := models.PriceRule{
+			Name: req.Name, BookID: req.BookID, CategoryID: req.CategoryID,
+			SalePrice: req.SalePrice, PercentOff: req.PercentOff,
+			StartsAt: *req.StartsAt, EndsAt: req.EndsAt, UserID: userID,
+		}
+		if err := db.Create(&rule).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		db.Preload("Book").Preload("Category").First(&rule, rule.ID)
+		utils.JSONCreated(c, "Success created price rule", rule)
+	}
+}
+
+// DeletePriceRule godoc
+// @Summary End or cancel a sale
+// @Description Rules can't be edited. Deleting one ends a running sale, or cancels a scheduled one, and it stays in the book's price history
+// @Tags Pricing
+// @Security BearerAuth
+// @Param id path int true "Price rule ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /price-rules/{id} [delete]
+func DeletePriceRule(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rule models.PriceRule
+		if err := db.First(&rule, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "price rule not found")
+			return
+		}
+		if err := db.Delete(&rule).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"message": "deleted"})
+	}
+}
+
+// BookPriceHistory godoc
+// @Summary Book price history
+// @Description Every change of the book's list price, newest first, and every sale that has covered the book through it or its current categories, including deleted ones
+// @Tags Pricing
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Book ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /books/{id}/price-history [get]
+func BookPriceHistory(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var book models.Book
+		if err := selectEffectivePrice(db).First(&book, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "book not found")
+			return
+		}
+		var changes []models.BookPriceChange
+		if err := db.Where("book_id = ?", book.ID).Order("created_at desc, id desc").Find(&changes).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		categories, err := services.CategoryAncestorIDs(db, book.CategoryID)
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		var rules []models.PriceRule
+		err = db.Unscoped().Preload("Category").
+			Where("book_id = ? OR category_id IN ?", book.ID, categories).
+			Order("starts_at desc, id desc").Find(&rules).Error
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{
+			"book_id": book.ID, "price": book.Price, "currency": book.Currency, "effective_price": book.EffectivePrice,
+			"changes": changes, "rules": rules,
+		})
+	}
+}
@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"bookstore-api/app/config"
+	"bookstore-api/app/dto"
+	"bookstore-api/app/models"
+	"bookstore-api/app/notify"
+	"bookstore-api/app/services"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// openPurchaseStatuses are the purchase order statuses still expecting goods.
+var openPurchaseStatuses = []string{models.PurchaseDraft, models.PurchaseSent, models.PurchasePartiallyReceived}
+
+var (
+	errPurchaseOrderNotDraft = errors.New("purchase order is not a draft")
+	errPurchaseOrderStatus   = errors.New("purchase order can't make that transition")
+)
+
+// ListPurchaseOrders godoc
+// @Summary List purchase orders
+// @Tags Purchasing
+// @Security BearerAuth
+// @Produce json
+// @Param status query string false "draft, sent, partially_received, received, cancelled, or open for the first three"
+// @Param supplier_id query int false "Only orders from this supplier"
+// @Param book_id query int false "Only orders containing this book"
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Success 200 {object} map[string]interface{}
+// @Router /purchase-orders [get]
+func ListPurchaseOrders(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, offset := pagination(c)
+		query := db.Model(&models.PurchaseOrder{})
+		switch s := c.Query("status"); s {
+		case "":
+		case "open":
+			query = query.Where("status IN ?", openPurchaseStatuses)
+		default:
+			query = query.Where("status = ?", s)
+		}
+		if s := c.Query("supplier_id"); s != "" {
+			query = query.Where("supplier_id = ?", s)
+		}
+		if b := c.Query("book_id"); b != "" {
+			query = query.Where("id IN (SELECT purchase_order_id FROM purchase_order_items WHERE book_id = ?)", b)
+		}
+		var total int64
+		query.Count(&total)
+		var orders []models.PurchaseOrder
+		err := query.Preload("Supplier").Preload("Warehouse").Preload("Items.Book").
+			Order("id desc").Limit(limit).Offset(offset).Find(&orders).Error
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"items": orders, "page": page, "limit": limit, "total": total})
+	}
+}
+
+// GetPurchaseOrder godoc
+// @Summary Get purchase order
+// @Description Includes every receipt booked against each line
+// @Tags Purchasing
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Purchase order ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /purchase-orders/{id} [get]
+func GetPurchaseOrder(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var po models.PurchaseOrder
+		if err := preloadPurchaseOrder(db).First(&po, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "purchase order not found")
+			return
+		}
+		utils.JSONOk(c, po)
+	}
+}
+
+// CreatePurchaseOrder godoc
+// @Summary Create purchase order
+// @Description Admin drafts a purchase order. Goods are received into the given warehouse, or the primary one
+// @Tags Purchasing
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.PurchaseOrderRequest true "Purchase order"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /purchase-orders [post]
+func CreatePurchaseOrder(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		var req dto.PurchaseOrderRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		po := models.PurchaseOrder{Status: models.PurchaseDraft, UserID: userID}
+		if msg := applyPurchaseOrderRequest(db, &po, req); msg != "" {
+			utils.JSONError(c, http.StatusBadRequest, msg)
+			return
+		}
+		if err := db.Create(&po).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		preloadPurchaseOrder(db).First(&po, po.ID)
+		utils.JSONCreated(c, "Success created purchase order", po)
+	}
+}
+
+// UpdatePurchaseOrder godoc
+// @Summary Update purchase order
+// @Description Only drafts can be edited. The items replace the current ones
+// @Tags Purchasing
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Purchase order ID"
+// @Param request body dto.PurchaseOrderRequest true "Purchase order"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /purchase-orders/{id} [put]
+func UpdatePurchaseOrder(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var po models.PurchaseOrder
+		if err := db.First(&po, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "purchase order not found")
+			return
+		}
+		var req dto.PurchaseOrderRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if msg := applyPurchaseOrderRequest(db, &po, req); msg != "" {
+			utils.JSONError(c, http.StatusBadRequest, msg)
+			return
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var locked models.PurchaseOrder
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, po.ID).Error; err != nil {
+				return err
+			}
+			if locked.Status != models.PurchaseDraft {
+				return errPurchaseOrderNotDraft
+			}
+			if err := tx.Where("purchase_order_id = ?", po.ID).Delete(&models.PurchaseOrderItem{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Omit("Items").Save(&po).Error; err != nil {
+				return err
+			}
+			return tx.Create(&po.Items).Error
+		})
+		if errors.Is(err, errPurchaseOrderNotDraft) {
+			utils.JSONError(c, http.StatusConflict, "only draft purchase orders can be edited")
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		preloadPurchaseOrder(db).First(&po, po.ID)
+		utils.JSONOk(c, po)
+	}
+}
+
+// SendPurchaseOrder godoc
+// @Summary Mark purchase order as sent
+// @Description The draft has been sent to the supplier and goods can be received against it
+// @Tags Purchasing
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Purchase order ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /purchase-orders/{id}/send [post]
+func SendPurchaseOrder(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		transitionPurchaseOrder(c, db, models.PurchaseSent, "sent_at", models.PurchaseDraft)
+	}
+}
+
+// CancelPurchaseOrder godoc
+// @Summary Cancel purchase order
+// @Description Drafts and sent orders can be cancelled as long as nothing was received
+// @Tags Purchasing
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Purchase order ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /purchase-orders/{id}/cancel [post]
+func CancelPurchaseOrder(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		transitionPurchaseOrder(c, db, models.PurchaseCancelled, "", models.PurchaseDraft, models.PurchaseSent)
+	}
+}
+
+// ReceivePurchaseOrder godoc
+// @Summary Receive goods against a purchase order
+// @Description Adds the received quantities to stock in the order's warehouse and records them with their unit cost, which defaults to the ordered cost. Backordered customer orders are served first
+// @Tags Purchasing
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Purchase order ID"
+// @Param request body dto.ReceivePurchaseOrderRequest true "Received goods"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /purchase-orders/{id}/receive [post]
+func ReceivePurchaseOrder(db *gorm.DB, cfg *config.Config, notifier notify.Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		var po models.PurchaseOrder
+		if err := db.First(&po, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "purchase order not found")
+			return
+		}
+		var req dto.ReceivePurchaseOrderRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		lines := make([]services.ReceiptLine, len(req.Items))
+		for i, it := range req.Items {
+			lines[i] = services.ReceiptLine{ItemID: it.ItemID, Quantity: it.Quantity, UnitCost: it.UnitCost}
+		}
+
+		var restocks []*models.RestockEvent
+		var ready []uint
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			restocks, ready, err = services.ReceivePurchaseOrder(tx, po.ID, userID, lines, cfg.TaxRate)
+			return err
+		})
+		if errors.Is(err, services.ErrPurchaseOrderNotOpen) {
+			utils.JSONError(c, http.StatusConflict, "only sent purchase orders can receive goods")
+			return
+		}
+		if errors.Is(err, services.ErrInvalidReceipt) {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, ev := range restocks {
+			services.NotifyRestockAsync(db, notifier, ev)
+		}
+		services.NotifyOrdersReadyAsync(db, notifier, ready)
+		preloadPurchaseOrder(db).First(&po, po.ID)
+		utils.JSONOk(c, po)
+	}
+}
+
+// BookPurchaseOrders godoc
+// @Summary Open purchase orders for a book
+// @Description Purchase orders still expecting the book, soonest expected first. Each lists only the book's line
+// @Tags Purchasing
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Book ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /books/{id}/purchase-orders [get]
+func BookPurchaseOrders(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var book models.Book
+		if err := db.First(&book, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "book not found")
+			return
+		}
+		var orders []models.PurchaseOrder
+		err := db.Preload("Supplier").Preload("Warehouse").Preload("Items", "book_id = ?", book.ID).
+			Where("status IN ?", openPurchaseStatuses).
+			Where("id IN (SELECT purchase_order_id FROM purchase_order_items WHERE book_id = ?)", book.ID).
+			Order("expected_at ASC NULLS LAST, id").Find(&orders).Error
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		// drafts haven't been ordered yet, so they don't count as incoming
+		incoming := 0
+		for _, po := range orders {
+			if po.Status == models.PurchaseDraft {
+				continue
+			}
+			for _, it := range po.Items {
+				incoming += it.QuantityOrdered - it.QuantityReceived
+			}
+		}
+		utils.JSONOk(c, gin.H{"items": orders, "incoming": incoming, "stock": book.Stock})
+	}
+}
+
+// applyPurchaseOrderRequest copies req onto po after checking what it
+// refers to, and returns a message for a 400 response if something is off.
+func applyPurchaseOrderRequest(db *gorm.DB, po *models.PurchaseOrder, req dto.PurchaseOrderRequest) string {
+	var supplier models.Supplier
+	if err := db.First(&supplier, req.SupplierID).Error; err != nil {
+		return "supplier not found"
+	}
+	if req.WarehouseID == 0 {
+		id, err := services.PrimaryWarehouseID(db)
+		if err != nil {
+			return "no warehouse to receive into"
+		}
+		req.WarehouseID = id
+	} else if !warehouseExists(db, req.WarehouseID) {
+		return "warehouse not found"
+	}
+	if req.Currency == "" {
+		req.Currency = models.BaseCurrency
+	}
+
+	po.SupplierID, po.WarehouseID, po.Currency = supplier.ID, req.WarehouseID, req.Currency
+	po.ExpectedAt, po.Notes = req.ExpectedAt, req.Notes
+	po.Items = make([]models.PurchaseOrderItem, 0, len(req.Items))
+	seen := map[uint]bool{}
+	for _, it := range req.Items {
+		if seen[it.BookID] {
+			return fmt.Sprintf("book %d is listed more than once", it.BookID)
+		}
+		seen[it.BookID] = true
+		var count int64
+		db.Model(&models.Book{}).Where("id = ?", it.BookID).Count(&count)
+		if count == 0 {
+			return fmt.Sprintf("book %d not found", it.BookID)
+		}
+		po.Items = append(po.Items, models.PurchaseOrderItem{
+			PurchaseOrderID: po.ID, BookID: it.BookID, QuantityOrdered: it.Quantity, UnitCost: it.UnitCost,
+		})
+	}
+	return ""
+}
+
+// transitionPurchaseOrder moves a purchase order from one of the statuses
+// in from to status, stamping the column named by at if any, and writes
+// the response. What the order is expected to deliver changes with it, so
+// the expected ship dates of backorders for its books are refreshed.
+func transitionPurchaseOrder(c *gin.Context, db *gorm.DB, status, at string, from ...string) {
+	var po models.PurchaseOrder
+	if err := db.Preload("Items").First(&po, c.Param("id")).Error; err != nil {
+		utils.JSONError(c, http.StatusNotFound, "purchase order not found")
+		return
+	}
+	updates := map[string]interface{}{"status": status}
+	if at != "" {
+		updates[at] = time.Now()
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.PurchaseOrder{}).Where("id = ? AND status IN ?", po.ID, from).Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errPurchaseOrderStatus
+		}
+		for _, it := range po.Items {
+			if err := services.RefreshExpectedShipDates(tx, it.BookID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errPurchaseOrderStatus) {
+		utils.JSONError(c, http.StatusConflict, "purchase order is "+po.Status)
+		return
+	}
+	if err != nil {
+		utils.JSONError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	preloadPurchaseOrder(db).First(&po, po.ID)
+	utils.JSONOk(c, po)
+}
+
+func preloadPurchaseOrder(db *gorm.DB) *gorm.DB {
+	return db.Preload("Supplier").Preload("Warehouse").Preload("Items.Book").Preload("Items.Receipts")
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"bookstore-api/app/models"
+	"bookstore-api/app/services"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxRecommendations = 50
+
+type recommendedBook struct {
+	Reason string      `json:"reason"`
+	Book   models.Book `json:"book"`
+}
+
+// RelatedBooks godoc
+// @Summary Related books
+// @Description Books frequently bought together with this one, topped up with books by the same authors and from the same category. Co-purchases are refreshed periodically
+// @Tags Recommendations
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param limit query int false "Number of books, at most 50 (default 10)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /books/{id}/related [get]
+func RelatedBooks(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var book models.Book
+		if err := db.First(&book, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "book not found")
+			return
+		}
+		limit, ok := recommendationLimit(c)
+		if !ok {
+			return
+		}
+		recs, err := services.RelatedBooks(db, book, limit)
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondRecommendations(c, db, recs)
+	}
+}
+
+// MyRecommendations godoc
+// @Summary My recommendations
+// @Description Books often bought together with what the user has bought, then bestsellers from their categories and overall
+// @Tags Recommendations
+// @Security BearerAuth
+// @Produce json
+// @Param limit query int false "Number of books, at most 50 (default 10)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /me/recommendations [get]
+func MyRecommendations(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		limit, ok := recommendationLimit(c)
+		if !ok {
+			return
+		}
+		recs, err := services.RecommendForUser(db, userID, limit)
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondRecommendations(c, db, recs)
+	}
+}
+
+func recommendationLimit(c *gin.Context) (int, bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 {
+		utils.JSONError(c, http.StatusBadRequest, "limit must be a positive integer")
+		return 0, false
+	}
+	if limit > maxRecommendations {
+		limit = maxRecommendations
+	}
+	return limit, true
+}
+
+// respondRecommendations loads the recommended books and answers with them
+// in the order they were recommended.
+func respondRecommendations(c *gin.Context, db *gorm.DB, recs []services.Recommendation) {
+	ids := make([]uint, len(recs))
+	for i, r := range recs {
+		ids[i] = r.BookID
+	}
+	var books []models.Book
+	if len(ids) > 0 {
+		if err := preloadAuthors(selectEffectivePrice(db.Preload("Category"))).Where("id IN ?", ids).Find(&books).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	byID := make(map[uint]models.Book, len(books))
+	for _, b := range books {
+		byID[b.ID] = b
+	}
+	items := make([]recommendedBook, 0, len(recs))
+	for _, r := range recs {
+		if b, ok := byID[r.BookID]; ok {
+			items = append(items, recommendedBook{Reason: r.Reason, Book: b})
+		}
+	}
+	utils.JSONOk(c, gin.H{"items": items})
+}
@@ -11,7 +11,7 @@ import (
 
 // SalesReport godoc
 // @Summary Sales report
-// @Description Show total revenue and total books sold
+// @Description Show total revenue (in the base currency) and total books sold
 // @Tags Reports
 // @Security BearerAuth
 // @Produce json
@@ -19,10 +19,10 @@ import (
 // @Router /reports/sales [get]
 func SalesReport(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// total omzet (sum total_price where status PAID), total books sold (sum quantity)
+		// total omzet (sum total_price converted with the rate captured at checkout, where status PAID), total books sold (sum quantity)
 		var totalRevenue float64
 		var totalBooksSold int64
-		db.Model(&models.Order{}).Where("status = ?", "PAID").Select("COALESCE(SUM(total_price),0)").Scan(&totalRevenue)
+		db.Model(&models.Order{}).Where("status = ?", "PAID").Select("COALESCE(SUM(total_price * exchange_rate),0)").Scan(&totalRevenue)
 		db.Model(&models.OrderItem{}).Joins("JOIN orders on orders.id = order_items.order_id").Where("orders.status = ?", "PAID").Select("COALESCE(SUM(order_items.quantity),0)").Scan(&totalBooksSold)
 		utils.JSONOk(c, gin.H{"revenue": totalRevenue, "books_sold": totalBooksSold})
 	}
@@ -72,3 +72,34 @@ func PriceStatsReport(db *gorm.DB) gin.HandlerFunc {
 		utils.JSONOk(c, gin.H{"max": max, "min": min, "avg": avg})
 	}
 }
+
+// BundleSalesReport godoc
+// @Summary Bundle sales report
+// @Description Show how many of each bundle were sold and their revenue (in the base currency), best selling first
+// @Tags Reports
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} dto.BundleSalesReportResponse
+// @Router /reports/bundles [get]
+func BundleSalesReport(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// the books of a bundle are separate items sharing its bundle_quantity
+		type Row dto.BundleSalesReportResponse
+		rows := []Row{}
+		db.Raw(`
+            SELECT b.id as bundle_id, b.title, SUM(s.quantity) as sold, SUM(s.revenue) as revenue
+            FROM (
+                SELECT oi.order_id, oi.bundle_id, MAX(oi.bundle_quantity) as quantity,
+                    SUM(oi.price * oi.quantity * o.exchange_rate) as revenue
+                FROM order_items oi
+                JOIN orders o ON o.id = oi.order_id
+                WHERE o.status = ? AND oi.bundle_id IS NOT NULL
+                GROUP BY oi.order_id, oi.bundle_id
+            ) s
+            JOIN bundles b ON b.id = s.bundle_id
+            GROUP BY b.id, b.title
+            ORDER BY sold DESC, revenue DESC
+        `, "PAID").Scan(&rows)
+		utils.JSONOk(c, rows)
+	}
+}
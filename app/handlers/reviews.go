@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"net/http"
+
+	"bookstore-api/app/dto"
+	"bookstore-api/app/models"
+	"bookstore-api/app/services"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var reviewSorts = map[string]string{
+	"created_at": "reviews.created_at",
+	"rating":     "reviews.rating",
+}
+
+// CreateReview godoc
+// @Summary Review a book
+// @Description Only customers with a paid order containing the book can review it, once
+// @Tags Reviews
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param request body dto.ReviewRequest true "Review"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /books/{id}/reviews [post]
+func CreateReview(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		var book models.Book
+		if err := db.First(&book, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "book not found")
+			return
+		}
+		var req dto.ReviewRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		bought, err := services.HasPurchasedBook(db, userID, book.ID)
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !bought {
+			utils.JSONError(c, http.StatusForbidden, "only customers who bought this book can review it")
+			return
+		}
+		var count int64
+		db.Model(&models.Review{}).Where("book_id = ? AND user_id = ?", book.ID, userID).Count(&count)
+		if count > 0 {
+			utils.JSONError(c, http.StatusConflict, "you have already reviewed this book")
+			return
+		}
+
+		review := models.Review{BookID: book.ID, UserID: userID, Rating: req.Rating, Title: req.Title, Body: req.Body}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&review).Error; err != nil {
+				return err
+			}
+			return services.RefreshBookRating(tx, book.ID)
+		})
+		if utils.IsUniqueViolation(err) {
+			utils.JSONError(c, http.StatusConflict, "you have already reviewed this book")
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONCreated(c, "Success created review", review)
+	}
+}
+
+// ListBookReviews godoc
+// @Summary List book reviews
+// @Description Hidden reviews are only listed for admins
+// @Tags Reviews
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page, at most 100"
+// @Param sort query string false "created_at (default) or rating"
+// @Param order query string false "asc or desc (default)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /books/{id}/reviews [get]
+func ListBookReviews(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rolev, _ := c.Get("role")
+		role := rolev.(string)
+
+		var book models.Book
+		if err := db.First(&book, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "book not found")
+			return
+		}
+		page, limit, err := strictPagination(c)
+		if err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		column, ok := reviewSorts[c.DefaultQuery("sort", "created_at")]
+		if !ok {
+			utils.JSONError(c, http.StatusBadRequest, "sort must be created_at or rating")
+			return
+		}
+		dir := c.DefaultQuery("order", "desc")
+		if dir != "asc" && dir != "desc" {
+			utils.JSONError(c, http.StatusBadRequest, "order must be asc or desc")
+			return
+		}
+
+		query := db.Model(&models.Review{}).Where("reviews.book_id = ?", book.ID)
+		if role != "admin" {
+			query = query.Where("NOT reviews.hidden")
+		}
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		var reviews []models.Review
+		err = query.Select("reviews.*, users.name AS reviewer_name").
+			Joins("JOIN users ON users.id = reviews.user_id").
+			Order(column + " " + dir + ", reviews.id " + dir).
+			Limit(limit).Offset((page - 1) * limit).
+			Find(&reviews).Error
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{
+			"items": reviews, "page": page, "limit": limit, "total": total,
+			"rating_average": book.RatingAvg, "rating_count": book.RatingCount,
+		})
+	}
+}
+
+// UpdateReview godoc
+// @Summary Update own review
+// @Tags Reviews
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Review ID"
+// @Param request body dto.ReviewRequest true "Review"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /reviews/{id} [put]
+func UpdateReview(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		var review models.Review
+		if err := db.First(&review, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "review not found")
+			return
+		}
+		if review.UserID != userID {
+			utils.JSONError(c, http.StatusForbidden, "not authorized")
+			return
+		}
+		var req dto.ReviewRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			review.Rating, review.Title, review.Body = req.Rating, req.Title, req.Body
+			if err := tx.Save(&review).Error; err != nil {
+				return err
+			}
+			return services.RefreshBookRating(tx, review.BookID)
+		})
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, review)
+	}
+}
+
+// ModerateReview godoc
+// @Summary Hide or show a review
+// @Description Admin moderation. Hidden reviews no longer count towards the book's rating
+// @Tags Reviews
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Review ID"
+// @Param request body dto.ModerateReviewRequest true "Visibility"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /reviews/{id}/moderation [put]
+func ModerateReview(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var review models.Review
+		if err := db.First(&review, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "review not found")
+			return
+		}
+		var req dto.ModerateReviewRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&review).Update("hidden", *req.Hidden).Error; err != nil {
+				return err
+			}
+			return services.RefreshBookRating(tx, review.BookID)
+		})
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, review)
+	}
+}
+
+// DeleteReview godoc
+// @Summary Delete review
+// @Description The reviewer can delete their own review, admins can remove any
+// @Tags Reviews
+// @Security BearerAuth
+// @Param id path int true "Review ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /reviews/{id} [delete]
+func DeleteReview(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rolev, _ := c.Get("role")
+		role := rolev.(string)
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		var review models.Review
+		if err := db.First(&review, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "review not found")
+			return
+		}
+		if role != "admin" && review.UserID != userID {
+			utils.JSONError(c, http.StatusForbidden, "not authorized")
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(&review).Error; err != nil {
+				return err
+			}
+			return services.RefreshBookRating(tx, review.BookID)
+		})
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"message": "deleted"})
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"bookstore-api/app/config"
+	"bookstore-api/app/dto"
+	"bookstore-api/app/models"
+	"bookstore-api/app/notify"
+	"bookstore-api/app/services"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RecordStockMovement godoc
+// @Summary Record a stock movement
+// @Description Admin records a restock, a customer return (both positive) or an adjustment (either sign, e.g. damaged copies) against a book, in the given warehouse or else the primary one. Added copies go to backorders first
+// @Tags Inventory
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param request body dto.StockMovementRequest true "Movement"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /books/{id}/stock-movements [post]
+func RecordStockMovement(db *gorm.DB, cfg *config.Config, notifier notify.Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		var book models.Book
+		if err := db.First(&book, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "book not found")
+			return
+		}
+		var req dto.StockMovementRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Type != models.MovementAdjustment && req.Quantity < 0 {
+			utils.JSONError(c, http.StatusBadRequest, req.Type+" quantity must be positive")
+			return
+		}
+		if req.WarehouseID != 0 && !warehouseExists(db, req.WarehouseID) {
+			utils.JSONError(c, http.StatusBadRequest, "warehouse not found")
+			return
+		}
+
+		var mv *models.StockMovement
+		var restock *models.RestockEvent
+		var ready []uint
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			mv, restock, err = services.ApplyStockChange(tx, services.StockChange{
+				BookID: book.ID, WarehouseID: req.WarehouseID, Delta: req.Quantity, Type: req.Type, UserID: &userID, Reason: req.Reason,
+			})
+			if err != nil || req.Quantity < 0 {
+				return err
+			}
+			ready, err = services.FulfilBackorders(tx, book.ID, cfg.TaxRate)
+			return err
+		})
+		if errors.Is(err, services.ErrInsufficientStock) {
+			utils.JSONError(c, http.StatusBadRequest, "adjustment would make stock negative")
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		services.NotifyRestockAsync(db, notifier, restock)
+		services.NotifyOrdersReadyAsync(db, notifier, ready)
+		services.CheckLowStockAsync(db, notifier, book.ID)
+		utils.JSONCreated(c, "Success recorded stock movement", mv)
+	}
+}
+
+// StockHistory godoc
+// @Summary Book stock history
+// @Description Every stock movement of the book, newest first
+// @Tags Inventory
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param type query string false "Only movements of this type: sale, restock, adjustment, return, cancellation or transfer"
+// @Param warehouse_id query int false "Only movements in this warehouse"
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /books/{id}/stock-history [get]
+func StockHistory(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var book models.Book
+		if err := db.First(&book, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "book not found")
+			return
+		}
+		page, limit, offset := pagination(c)
+		query := db.Model(&models.StockMovement{}).Where("book_id = ?", book.ID)
+		if t := c.Query("type"); t != "" {
+			query = query.Where("type = ?", t)
+		}
+		if w := c.Query("warehouse_id"); w != "" {
+			query = query.Where("warehouse_id = ?", w)
+		}
+		var total int64
+		query.Count(&total)
+		var movements []models.StockMovement
+		if err := query.Order("created_at desc, id desc").Limit(limit).Offset(offset).Find(&movements).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"items": movements, "page": page, "limit": limit, "total": total, "stock": book.Stock})
+	}
+}
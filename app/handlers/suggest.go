@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	suggestTTL         = 30 * time.Second
+	suggestCacheSize   = 1000
+	maxSuggestLimit    = 10
+	maxSuggestQueryLen = 100
+)
+
+type titleSuggestion struct {
+	ID     uint   `json:"id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+}
+
+type nameSuggestion struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type bookSuggestions struct {
+	Titles     []titleSuggestion `json:"titles"`
+	Authors    []nameSuggestion  `json:"authors"`
+	Categories []nameSuggestion  `json:"categories"`
+}
+
+// SuggestBooks godoc
+// @Summary Suggest titles, authors and categories
+// @Description Typeahead for the search box. Matches prefixes and, through trigram similarity, words with typos. Results are cached for 30 seconds
+// @Tags Books
+// @Security BearerAuth
+// @Produce json
+// @Param q query string true "What has been typed so far"
+// @Param limit query int false "Suggestions per kind, at most 10 (default 5)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /books/suggest [get]
+func SuggestBooks(db *gorm.DB) gin.HandlerFunc {
+	cache := &suggestCache{entries: map[string]suggestEntry{}}
+	return func(c *gin.Context) {
+		q := strings.Join(strings.Fields(c.Query("q")), " ")
+		if q == "" {
+			utils.JSONError(c, http.StatusBadRequest, "q is required")
+			return
+		}
+		if utf8.RuneCountInString(q) > maxSuggestQueryLen {
+			utils.JSONError(c, http.StatusBadRequest, "q must be at most 100 characters")
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "5"))
+		if err != nil || limit < 1 {
+			utils.JSONError(c, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		if limit > maxSuggestLimit {
+			limit = maxSuggestLimit
+		}
+
+		c.Header("Cache-Control", "private, max-age=30")
+		key := strings.ToLower(q) + "\x00" + strconv.Itoa(limit)
+		if res, ok := cache.get(key); ok {
+			utils.JSONOk(c, res)
+			return
+		}
+		res, err := suggest(db, q, limit)
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		cache.put(key, res)
+		utils.JSONOk(c, res)
+	}
+}
+
+// suggest runs one small query per kind. The folded input is passed as a
+// literal, not computed inline, so the planner can use the trigram indexes
+// created by migrations.BookSuggest. Prefix matches rank first, then the
+// closest fuzzy matches.
+func suggest(db *gorm.DB, q string, limit int) (bookSuggestions, error) {
+	res := bookSuggestions{Titles: []titleSuggestion{}, Authors: []nameSuggestion{}, Categories: []nameSuggestion{}}
+	var folded string
+	if err := db.Raw("SELECT bookstore_fold(?)", q).Scan(&folded).Error; err != nil {
+		return res, err
+	}
+	prefix := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(folded) + "%"
+
+	err := db.Raw(`
+		SELECT id, title, author FROM books
+		WHERE deleted_at IS NULL AND (bookstore_fold(title) LIKE ? OR ? <% bookstore_fold(title))
+		ORDER BY bookstore_fold(title) LIKE ? DESC, word_similarity(?, bookstore_fold(title)) DESC, sold_count DESC, id
+		LIMIT ?`, prefix, folded, prefix, folded, limit).Scan(&res.Titles).Error
+	if err != nil {
+		return res, err
+	}
+	err = db.Raw(`
+		SELECT id, name, slug FROM authors
+		WHERE deleted_at IS NULL AND (bookstore_fold(name) LIKE ? OR ? <% bookstore_fold(name))
+		ORDER BY bookstore_fold(name) LIKE ? DESC, word_similarity(?, bookstore_fold(name)) DESC, name
+		LIMIT ?`, prefix, folded, prefix, folded, limit).Scan(&res.Authors).Error
+	if err != nil {
+		return res, err
+	}
+	err = db.Raw(`
+		SELECT id, name, slug FROM categories
+		WHERE bookstore_fold(name) LIKE ? OR ? <% bookstore_fold(name)
+		ORDER BY bookstore_fold(name) LIKE ? DESC, word_similarity(?, bookstore_fold(name)) DESC, name
+		LIMIT ?`, prefix, folded, prefix, folded, limit).Scan(&res.Categories).Error
+	return res, err
+}
+
+// suggestCache keeps suggestions for suggestTTL. It is bounded by dropping
+// expired entries, or everything, once it holds suggestCacheSize keys.
+type suggestCache struct {
+	mu      sync.Mutex
+	entries map[string]suggestEntry
+}
+
+type suggestEntry struct {
+	res     bookSuggestions
+	expires time.Time
+}
+
+func (sc *suggestCache) get(key string) (bookSuggestions, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	e, ok := sc.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return bookSuggestions{}, false
+	}
+	return e.res, true
+}
+
+func (sc *suggestCache) put(key string, res bookSuggestions) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	now := time.Now()
+	if len(sc.entries) >= suggestCacheSize {
+		for k, e := range sc.entries {
+			if now.After(e.expires) {
+				delete(sc.entries, k)
+			}
+		}
+		if len(sc.entries) >= suggestCacheSize {
+			sc.entries = map[string]suggestEntry{}
+		}
+	}
+	sc.entries[key] = suggestEntry{res: res, expires: now.Add(suggestTTL)}
+}
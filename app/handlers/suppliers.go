@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"net/http"
+
+	"bookstore-api/app/dto"
+	"bookstore-api/app/models"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ListSuppliers godoc
+// @Summary List suppliers
+// @Tags Purchasing
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Param q query string false "Search by name"
+// @Success 200 {object} map[string]interface{}
+// @Router /suppliers [get]
+func ListSuppliers(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, offset := pagination(c)
+		query := db.Model(&models.Supplier{})
+		if q := c.Query("q"); q != "" {
+			query = query.Where("name ILIKE ?", "%"+q+"%")
+		}
+		var total int64
+		query.Count(&total)
+		var suppliers []models.Supplier
+		query.Order("name asc").Limit(limit).Offset(offset).Find(&suppliers)
+		utils.JSONOk(c, gin.H{"items": suppliers, "page": page, "limit": limit, "total": total})
+	}
+}
+
+// GetSupplier godoc
+// @Summary Get supplier
+// @Tags Purchasing
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Supplier ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /suppliers/{id} [get]
+func GetSupplier(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var s models.Supplier
+		if err := db.First(&s, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "supplier not found")
+			return
+		}
+		utils.JSONOk(c, s)
+	}
+}
+
+// CreateSupplier godoc
+// @Summary Create supplier
+// @Tags Purchasing
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.SupplierRequest true "Supplier"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /suppliers [post]
+func CreateSupplier(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req dto.SupplierRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		s := models.Supplier{Name: req.Name, ContactName: req.ContactName, Email: req.Email, Phone: req.Phone, Address: req.Address}
+		if err := db.Create(&s).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONCreated(c, "Success created supplier", s)
+	}
+}
+
+// UpdateSupplier godoc
+// @Summary Update supplier
+// @Tags Purchasing
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Supplier ID"
+// @Param request body dto.SupplierRequest true "Supplier"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /suppliers/{id} [put]
+func UpdateSupplier(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var s models.Supplier
+		if err := db.First(&s, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "supplier not found")
+			return
+		}
+		var req dto.SupplierRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		err := db.Model(&s).Updates(map[string]interface{}{
+			"name": req.Name, "contact_name": req.ContactName, "email": req.Email, "phone": req.Phone, "address": req.Address,
+		}).Error
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, s)
+	}
+}
+
+// DeleteSupplier godoc
+// @Summary Delete supplier
+// @Description Suppliers with purchase orders still awaiting goods can't be deleted
+// @Tags Purchasing
+// @Security BearerAuth
+// @Param id path int true "Supplier ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /suppliers/{id} [delete]
+func DeleteSupplier(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var s models.Supplier
+		if err := db.First(&s, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "supplier not found")
+			return
+		}
+		var open int64
+		db.Model(&models.PurchaseOrder{}).
+			Where("supplier_id = ? AND status IN ?", s.ID, openPurchaseStatuses).
+			Count(&open)
+		if open > 0 {
+			utils.JSONError(c, http.StatusConflict, "supplier has open purchase orders")
+			return
+		}
+		if err := db.Delete(&s).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"message": "deleted"})
+	}
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"bookstore-api/app/dto"
+	"bookstore-api/app/models"
+	"bookstore-api/app/services"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// IssueGiftCards godoc
+// @Summary Issue gift cards
+// @Description Admin issues count (default 1) gift cards, each worth amount of store credit in the base currency, optionally expiring at expires_at
+// @Tags Wallet
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.IssueGiftCardsRequest true "Gift cards"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /gift-cards [post]
+func IssueGiftCards(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		var req dto.IssueGiftCardsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			utils.JSONError(c, http.StatusBadRequest, "expires_at must be in the future")
+			return
+		}
+		if req.Count == 0 {
+			req.Count = 1
+		}
+		cards := make([]models.GiftCard, req.Count)
+		for i := range cards {
+			code, err := services.NewGiftCardCode()
+			if err != nil {
+				utils.JSONError(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			cards[i] = models.GiftCard{Code: code, Amount: req.Amount, Note: req.Note, ExpiresAt: req.ExpiresAt, UserID: userID}
+		}
+		if err := db.Create(&cards).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONCreated(c, "Success issued gift cards", cards)
+	}
+}
+
+// ListGiftCards godoc
+// @Summary List gift cards
+// @Tags Wallet
+// @Security BearerAuth
+// @Produce json
+// @Param status query string false "unredeemed, redeemed or expired"
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /gift-cards [get]
+func ListGiftCards(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, offset := pagination(c)
+		query := db.Model(&models.GiftCard{})
+		now := time.Now()
+		switch c.Query("status") {
+		case "":
+		case "unredeemed":
+			query = query.Where("redeemed_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", now)
+		case "redeemed":
+			query = query.Where("redeemed_at IS NOT NULL")
+		case "expired":
+			query = query.Where("redeemed_at IS NULL AND expires_at <= ?", now)
+		default:
+			utils.JSONError(c, http.StatusBadRequest, "status must be unredeemed, redeemed or expired")
+			return
+		}
+		var total int64
+		query.Count(&total)
+		var cards []models.GiftCard
+		if err := query.Order("id desc").Limit(limit).Offset(offset).Find(&cards).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"items": cards, "page": page, "limit": limit, "total": total})
+	}
+}
+
+// MyWallet godoc
+// @Summary Get own wallet
+// @Description Store credit balance, in the base currency, and the wallet's transactions, newest first
+// @Tags Wallet
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Success 200 {object} map[string]interface{}
+// @Router /me/wallet [get]
+func MyWallet(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+		page, limit, offset := pagination(c)
+
+		balance, err := services.WalletBalance(db, userID)
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		query := db.Model(&models.WalletTransaction{}).Where("user_id = ?", userID)
+		var total int64
+		query.Count(&total)
+		var transactions []models.WalletTransaction
+		if err := query.Order("id desc").Limit(limit).Offset(offset).Find(&transactions).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{
+			"balance": balance, "currency": models.BaseCurrency,
+			"items": transactions, "page": page, "limit": limit, "total": total,
+		})
+	}
+}
+
+// RedeemGiftCard godoc
+// @Summary Redeem a gift card
+// @Description Adds the gift card's amount to the caller's store credit. Each card can be redeemed once
+// @Tags Wallet
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.RedeemGiftCardRequest true "Gift card code"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /me/wallet/redeem [post]
+func RedeemGiftCard(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		var req dto.RedeemGiftCardRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		var t *models.WalletTransaction
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			t, err = services.RedeemGiftCard(tx, req.Code, userID)
+			return err
+		})
+		switch {
+		case errors.Is(err, services.ErrGiftCardNotFound):
+			utils.JSONError(c, http.StatusNotFound, err.Error())
+		case errors.Is(err, services.ErrGiftCardRedeemed):
+			utils.JSONError(c, http.StatusConflict, err.Error())
+		case errors.Is(err, services.ErrGiftCardExpired):
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+		case err != nil:
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+		default:
+			utils.JSONOk(c, t)
+		}
+	}
+}
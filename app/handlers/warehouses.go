@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"bookstore-api/app/dto"
+	"bookstore-api/app/models"
+	"bookstore-api/app/services"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errWarehouseInUse = errors.New("warehouse is in use")
+
+// ListWarehouses godoc
+// @Summary List warehouses
+// @Description In the order orders are allocated from: the primary warehouse first, then by priority
+// @Tags Warehouses
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} models.Warehouse
+// @Router /warehouses [get]
+func ListWarehouses(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var warehouses []models.Warehouse
+		if err := db.Order("is_primary DESC, priority, id").Find(&warehouses).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, warehouses)
+	}
+}
+
+// CreateWarehouse godoc
+// @Summary Create warehouse
+// @Description Admin adds a warehouse. Making it primary takes that role from the current primary warehouse
+// @Tags Warehouses
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.WarehouseRequest true "Warehouse"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /warehouses [post]
+func CreateWarehouse(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req dto.WarehouseRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		w := models.Warehouse{
+			Code: strings.ToUpper(req.Code), Name: req.Name, Address: req.Address,
+			IsPrimary: req.IsPrimary, Priority: req.Priority,
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if w.IsPrimary {
+				if err := clearPrimaryWarehouse(tx); err != nil {
+					return err
+				}
+			}
+			return tx.Create(&w).Error
+		})
+		if utils.IsUniqueViolation(err) {
+			utils.JSONError(c, http.StatusConflict, "warehouse code already exists")
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONCreated(c, "Success created warehouse", w)
+	}
+}
+
+// UpdateWarehouse godoc
+// @Summary Update warehouse
+// @Description Admin updates a warehouse. There is always one primary warehouse, so it can't be unset, only moved to another
+// @Tags Warehouses
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Warehouse ID"
+// @Param request body dto.WarehouseRequest true "Warehouse"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /warehouses/{id} [put]
+func UpdateWarehouse(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var w models.Warehouse
+		if err := db.First(&w, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "warehouse not found")
+			return
+		}
+		var req dto.WarehouseRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if w.IsPrimary && !req.IsPrimary {
+			utils.JSONError(c, http.StatusBadRequest, "make another warehouse primary instead")
+			return
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if req.IsPrimary && !w.IsPrimary {
+				if err := clearPrimaryWarehouse(tx); err != nil {
+					return err
+				}
+			}
+			return tx.Model(&w).Updates(map[string]interface{}{
+				"code": strings.ToUpper(req.Code), "name": req.Name, "address": req.Address,
+				"is_primary": req.IsPrimary, "priority": req.Priority,
+			}).Error
+		})
+		if utils.IsUniqueViolation(err) {
+			utils.JSONError(c, http.StatusConflict, "warehouse code already exists")
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		db.First(&w, w.ID)
+		utils.JSONOk(c, w)
+	}
+}
+
+// DeleteWarehouse godoc
+// @Summary Delete warehouse
+// @Description Only warehouses that are not primary, hold no stock and never shipped or transferred stock can be deleted
+// @Tags Warehouses
+// @Security BearerAuth
+// @Param id path int true "Warehouse ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /warehouses/{id} [delete]
+func DeleteWarehouse(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var w models.Warehouse
+		if err := db.First(&w, c.Param("id")).Error; err != nil {
+			utils.JSONError(c, http.StatusNotFound, "warehouse not found")
+			return
+		}
+		if w.IsPrimary {
+			utils.JSONError(c, http.StatusConflict, "the primary warehouse can't be deleted")
+			return
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var stock, history int64
+			if err := tx.Model(&models.WarehouseStock{}).Where("warehouse_id = ? AND quantity > 0", w.ID).Count(&stock).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.StockMovement{}).Where("warehouse_id = ?", w.ID).Count(&history).Error; err != nil {
+				return err
+			}
+			if stock+history > 0 {
+				return errWarehouseInUse
+			}
+			if err := tx.Where("warehouse_id = ?", w.ID).Delete(&models.WarehouseStock{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&w).Error
+		})
+		if errors.Is(err, errWarehouseInUse) {
+			utils.JSONError(c, http.StatusConflict, "warehouse holds stock or has stock history")
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"message": "deleted"})
+	}
+}
+
+// TransferStock godoc
+// @Summary Transfer stock between warehouses
+// @Description Admin moves copies of a book from one warehouse to another. The transfer is recorded as two transfer movements and leaves the book's total stock unchanged
+// @Tags Warehouses
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.StockTransferRequest true "Transfer"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /warehouses/transfers [post]
+func TransferStock(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		var req dto.StockTransferRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		var book models.Book
+		if err := db.First(&book, req.BookID).Error; err != nil {
+			utils.JSONError(c, http.StatusBadRequest, "book not found")
+			return
+		}
+		if !warehouseExists(db, req.FromWarehouseID) || !warehouseExists(db, req.ToWarehouseID) {
+			utils.JSONError(c, http.StatusBadRequest, "warehouse not found")
+			return
+		}
+
+		t := models.StockTransfer{
+			BookID: book.ID, FromWarehouseID: req.FromWarehouseID, ToWarehouseID: req.ToWarehouseID,
+			Quantity: req.Quantity, UserID: userID, Reason: req.Reason,
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return services.TransferStock(tx, &t)
+		})
+		if errors.Is(err, services.ErrInsufficientStock) {
+			utils.JSONError(c, http.StatusBadRequest, "the source warehouse holds too few copies")
+			return
+		}
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		db.Preload("Book").Preload("FromWarehouse").Preload("ToWarehouse").First(&t, t.ID)
+		utils.JSONCreated(c, "Success transferred stock", t)
+	}
+}
+
+// ListStockTransfers godoc
+// @Summary List stock transfers
+// @Tags Warehouses
+// @Security BearerAuth
+// @Produce json
+// @Param book_id query int false "Only transfers of this book"
+// @Param warehouse_id query int false "Only transfers from or to this warehouse"
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Success 200 {object} map[string]interface{}
+// @Router /warehouses/transfers [get]
+func ListStockTransfers(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, offset := pagination(c)
+		query := db.Model(&models.StockTransfer{})
+		if b := c.Query("book_id"); b != "" {
+			query = query.Where("book_id = ?", b)
+		}
+		if w := c.Query("warehouse_id"); w != "" {
+			query = query.Where("from_warehouse_id = ? OR to_warehouse_id = ?", w, w)
+		}
+		var total int64
+		query.Count(&total)
+		var transfers []models.StockTransfer
+		err := query.Preload("Book").Preload("FromWarehouse").Preload("ToWarehouse").
+			Order("created_at desc, id desc").Limit(limit).Offset(offset).Find(&transfers).Error
+		if err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"items": transfers, "page": page, "limit": limit, "total": total})
+	}
+}
+
+// clearPrimaryWarehouse unsets the current primary warehouse, before another
+// one takes its place in the same transaction.
+func clearPrimaryWarehouse(tx *gorm.DB) error {
+	return tx.Model(&models.Warehouse{}).Where("is_primary").Update("is_primary", false).Error
+}
+
+// preloadWarehouseStock loads each book's stock per warehouse, in
+// allocation order.
+func preloadWarehouseStock(q *gorm.DB) *gorm.DB {
+	return q.Preload("Warehouses", func(db *gorm.DB) *gorm.DB {
+		return db.Joins("JOIN warehouses ON warehouses.id = warehouse_stocks.warehouse_id").
+			Order("warehouses.is_primary DESC, warehouses.priority, warehouses.id")
+	}).Preload("Warehouses.Warehouse")
+}
+
+func warehouseExists(db *gorm.DB, id uint) bool {
+	var count int64
+	db.Model(&models.Warehouse{}).Where("id = ?", id).Count(&count)
+	return count > 0
+}
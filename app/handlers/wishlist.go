@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"net/http"
+
+	"bookstore-api/app/dto"
+	"bookstore-api/app/models"
+	"bookstore-api/app/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ListWishlist godoc
+// @Summary List my wishlist
+// @Tags Wishlist
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number"
+// @Param limit query int false "Items per page"
+// @Success 200 {object} map[string]interface{}
+// @Router /me/wishlist [get]
+func ListWishlist(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		page, limit, offset := pagination(c)
+		query := db.Model(&models.WishlistItem{}).Where("user_id = ?", userID)
+		var total int64
+		query.Count(&total)
+		var items []models.WishlistItem
+		if err := query.Preload("Book.Category").Order("created_at desc").Limit(limit).Offset(offset).Find(&items).Error; err != nil {
+			utils.JSONError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.JSONOk(c, gin.H{"items": items, "page": page, "limit": limit, "total": total})
+	}
+}
+
+// AddToWishlist godoc
+// @Summary Add a book to my wishlist
+// @Description Users are notified once each time a wishlisted book comes back in stock
+// @Tags Wishlist
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.WishlistRequest true "Book"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /me/wishlist [post]
+func AddToWishlist(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		var req dto.WishlistRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.JSONError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		var book models.Book
+		if err := db.First(&book, req.BookID).Error; err != nil {
+			utils.JSONError(c, http.StatusBadRequest, "book not found")
+			return
+		}
+
+		item := models.WishlistItem{UserID: userID, BookID: book.ID}
+		res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&item)
+		if res.Error != nil {
+			utils.JSONError(c, http.StatusInternalServerError, res.Error.Error())
+			return
+		}
+		if res.RowsAffected == 0 {
+			utils.JSONError(c, http.StatusConflict, "book is already on your wishlist")
+			return
+		}
+		item.Book = book
+		utils.JSONCreated(c, "Success added to wishlist", item)
+	}
+}
+
+// RemoveFromWishlist godoc
+// @Summary Remove a book from my wishlist
+// @Tags Wishlist
+// @Security BearerAuth
+// @Param book_id path int true "Book ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /me/wishlist/{book_id} [delete]
+func RemoveFromWishlist(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDv, _ := c.Get("user_id")
+		userID := userIDv.(uint)
+
+		res := db.Where("user_id = ? AND book_id = ?", userID, c.Param("book_id")).Delete(&models.WishlistItem{})
+		if res.Error != nil {
+			utils.JSONError(c, http.StatusBadRequest, res.Error.Error())
+			return
+		}
+		if res.RowsAffected == 0 {
+			utils.JSONError(c, http.StatusNotFound, "book is not on your wishlist")
+			return
+		}
+		utils.JSONOk(c, gin.H{"message": "deleted"})
+	}
+}
@@ -0,0 +1,45 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+
+	"bookstore-api/app/models"
+	"bookstore-api/app/storage"
+	"bookstore-api/app/utils"
+)
+
+// SaveCover processes an uploaded cover and stores every variant, returning
+// the base key and extension to save on the book.
+func SaveCover(ctx context.Context, store storage.Storage, data []byte) (string, string, error) {
+	processed, err := Process(data)
+	if err != nil {
+		return "", "", err
+	}
+
+	base := utils.NewFileKey("covers", "")
+	for name, variant := range processed.Variants {
+		key := models.CoverKey(base, name, processed.Ext)
+		if err := store.Put(ctx, key, bytes.NewReader(variant), int64(len(variant)), processed.ContentType); err != nil {
+			DeleteCover(ctx, store, base, processed.Ext)
+			return "", "", err
+		}
+	}
+	return base, processed.Ext, nil
+}
+
+// DeleteCover removes every variant of a cover. Failures only leave
+// unreferenced files behind, so they are ignored.
+func DeleteCover(ctx context.Context, store storage.Storage, base, ext string) {
+	if base == "" {
+		return
+	}
+	if ext == "" {
+		// a cover stored before variants existed is a single file
+		store.Delete(ctx, base)
+		return
+	}
+	for _, v := range Variants {
+		store.Delete(ctx, models.CoverKey(base, v.Name, ext))
+	}
+}
@@ -0,0 +1,171 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+const (
+	MaxFileSize  = 5 << 20
+	MinDimension = 100
+	MaxDimension = 5000
+	jpegQuality  = 85
+)
+
+var (
+	ErrTooLarge          = fmt.Errorf("image must not be larger than %dMB", MaxFileSize>>20)
+	ErrUnsupportedFormat = errors.New("image must be a JPEG, PNG or WebP file")
+	ErrDimensions        = fmt.Errorf("image must be between %dx%d and %dx%d pixels", MinDimension, MinDimension, MaxDimension, MaxDimension)
+)
+
+// IsInvalid reports whether err means the upload itself was rejected, as
+// opposed to a failure while processing or storing it.
+func IsInvalid(err error) bool {
+	return errors.Is(err, ErrTooLarge) || errors.Is(err, ErrUnsupportedFormat) || errors.Is(err, ErrDimensions)
+}
+
+// Variant is a rendition of an uploaded cover that fits in MaxWidth x
+// MaxHeight. Zero bounds keep the original size.
+type Variant struct {
+	Name      string
+	MaxWidth  int
+	MaxHeight int
+}
+
+var Variants = []Variant{
+	{Name: "thumbnail", MaxWidth: 150, MaxHeight: 225},
+	{Name: "medium", MaxWidth: 600, MaxHeight: 900},
+	{Name: "original"},
+}
+
+// Processed holds the re-encoded variants of an upload. Re-encoding drops
+// EXIF and any other metadata the original file carried.
+type Processed struct {
+	// Ext is the file extension of every variant, "jpg" or "png".
+	Ext         string
+	ContentType string
+	Variants    map[string][]byte
+}
+
+// Sniff detects the image format from its magic bytes rather than trusting
+// the file name or the client supplied content type.
+func Sniff(data []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg", nil
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png", nil
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "webp", nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Process validates an uploaded image and renders every variant.
+func Process(data []byte) (*Processed, error) {
+	if len(data) > MaxFileSize {
+		return nil, ErrTooLarge
+	}
+	format, err := Sniff(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// check the header before decoding so huge images are never allocated
+	cfg, err := decodeConfig(format, data)
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	if cfg.Width < MinDimension || cfg.Height < MinDimension || cfg.Width > MaxDimension || cfg.Height > MaxDimension {
+		return nil, ErrDimensions
+	}
+	src, err := decode(format, data)
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	// keep transparency as PNG, everything else becomes JPEG
+	out := &Processed{Ext: "jpg", ContentType: "image/jpeg", Variants: map[string][]byte{}}
+	if !isOpaque(src) {
+		out.Ext, out.ContentType = "png", "image/png"
+	}
+	for _, v := range Variants {
+		var buf bytes.Buffer
+		img := resize(src, v.MaxWidth, v.MaxHeight)
+		if out.Ext == "png" {
+			err = png.Encode(&buf, img)
+		} else {
+			err = jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: jpegQuality})
+		}
+		if err != nil {
+			return nil, err
+		}
+		out.Variants[v.Name] = buf.Bytes()
+	}
+	return out, nil
+}
+
+func decodeConfig(format string, data []byte) (image.Config, error) {
+	r := bytes.NewReader(data)
+	switch format {
+	case "jpeg":
+		return jpeg.DecodeConfig(r)
+	case "png":
+		return png.DecodeConfig(r)
+	default:
+		return webp.DecodeConfig(r)
+	}
+}
+
+func decode(format string, data []byte) (image.Image, error) {
+	r := bytes.NewReader(data)
+	switch format {
+	case "jpeg":
+		return jpeg.Decode(r)
+	case "png":
+		return png.Decode(r)
+	default:
+		return webp.Decode(r)
+	}
+}
+
+// resize scales img down to fit within maxW x maxH, keeping its aspect
+// ratio. Images that already fit are returned as is.
+func resize(img image.Image, maxW, maxH int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if maxW == 0 || maxH == 0 || (w <= maxW && h <= maxH) {
+		return img
+	}
+	scale := min(float64(maxW)/float64(w), float64(maxH)/float64(h))
+	nw, nh := max(1, int(float64(w)*scale)), max(1, int(float64(h)*scale))
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, b, xdraw.Over, nil)
+	return dst
+}
+
+// flatten draws img on a white background, JPEG has no alpha channel.
+func flatten(img image.Image) image.Image {
+	if isOpaque(img) {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return true
+}
@@ -0,0 +1,224 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func newImage(w, h int, alpha uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: alpha})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, w, h int, alpha uint8) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, newImage(w, h, alpha)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, newImage(w, h, 255), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withEXIF puts an APP1 EXIF segment holding text right after the JPEG's
+// start of image marker.
+func withEXIF(data []byte, text string) []byte {
+	payload := append([]byte("Exif\x00\x00"), text...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	out = append(out, payload...)
+	return append(out, data[2:]...)
+}
+
+// withText puts a tEXt chunk holding text right after the PNG's IHDR.
+func withText(data []byte, text string) []byte {
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	body := append([]byte("tEXt"), "Comment\x00"+text...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(body)-4))
+	chunk = append(chunk, body...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(body))
+	out := append([]byte{}, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 0x10}, "jpeg"},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00"), "png"},
+		{"webp", []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), "webp"},
+		{"gif", []byte("GIF89a"), ""},
+		{"pdf", []byte("%PDF-1.4\n"), ""},
+		{"text", []byte("<svg xmlns=\"http://www.w3.org/2000/svg\"/>"), ""},
+		{"riff that isn't webp", []byte("RIFF\x24\x00\x00\x00WAVEfmt "), ""},
+		{"short riff", []byte("RIFF"), ""},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Sniff(tt.data)
+			if tt.want == "" {
+				if !errors.Is(err, ErrUnsupportedFormat) {
+					t.Errorf("Sniff = %q, %v, want ErrUnsupportedFormat", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Sniff = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	valid := encodePNG(t, 200, 300, 255)
+	oversized := append(encodeJPEG(t, 200, 300), make([]byte, MaxFileSize)...)
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"larger than MaxFileSize", oversized, ErrTooLarge},
+		{"not an image", []byte("just some text, not a cover"), ErrUnsupportedFormat},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"), ErrUnsupportedFormat},
+		{"truncated png", valid[:len(valid)/2], ErrUnsupportedFormat},
+		{"png header only", valid[:8], ErrUnsupportedFormat},
+		{"truncated jpeg", encodeJPEG(t, 200, 300)[:100], ErrUnsupportedFormat},
+		{"too narrow", encodePNG(t, MinDimension-1, 300, 255), ErrDimensions},
+		{"too short", encodeJPEG(t, 300, MinDimension-1), ErrDimensions},
+		{"too wide", encodePNG(t, MaxDimension+1, MinDimension, 255), ErrDimensions},
+		{"too tall", encodePNG(t, MinDimension, MaxDimension+1, 255), ErrDimensions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Process(tt.data)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Process = %v, %v, want %v", got, err, tt.want)
+			}
+			if !IsInvalid(err) {
+				t.Errorf("IsInvalid(%v) = false", err)
+			}
+		})
+	}
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		ext         string
+		w, h        int
+		thumb, med  image.Point
+		stripMarker string
+	}{
+		{
+			name: "opaque png becomes jpeg", data: encodePNG(t, 1200, 1800, 255), ext: "jpg", w: 1200, h: 1800,
+			thumb: image.Pt(150, 225), med: image.Pt(600, 900),
+		},
+		{
+			name: "transparent png stays png", data: encodePNG(t, 400, 400, 128), ext: "png", w: 400, h: 400,
+			thumb: image.Pt(150, 150), med: image.Pt(400, 400),
+		},
+		{
+			name: "wide jpeg fits the width", data: encodeJPEG(t, 1000, 500), ext: "jpg", w: 1000, h: 500,
+			thumb: image.Pt(150, 75), med: image.Pt(600, 300),
+		},
+		{
+			name: "small image is not enlarged", data: encodeJPEG(t, MinDimension, MinDimension), ext: "jpg", w: MinDimension, h: MinDimension,
+			thumb: image.Pt(MinDimension, MinDimension), med: image.Pt(MinDimension, MinDimension),
+		},
+		{
+			name: "jpeg exif is dropped", data: withEXIF(encodeJPEG(t, 300, 450), "GPS -6.9147,107.6098"), ext: "jpg", w: 300, h: 450,
+			thumb: image.Pt(150, 225), med: image.Pt(300, 450), stripMarker: "GPS -6.9147",
+		},
+		{
+			name: "png text is dropped", data: withText(encodePNG(t, 300, 450, 255), "Author: someone private"), ext: "jpg", w: 300, h: 450,
+			thumb: image.Pt(150, 225), med: image.Pt(300, 450), stripMarker: "someone private",
+		},
+		{
+			name: "transparent png text is dropped", data: withText(encodePNG(t, 300, 450, 0), "Author: someone private"), ext: "png", w: 300, h: 450,
+			thumb: image.Pt(150, 225), med: image.Pt(300, 450), stripMarker: "someone private",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.stripMarker != "" && !bytes.Contains(tt.data, []byte(tt.stripMarker)) {
+				t.Fatal("the upload doesn't carry the metadata to be dropped")
+			}
+			got, err := Process(tt.data)
+			if err != nil {
+				t.Fatalf("Process: %v", err)
+			}
+			wantType := map[string]string{"jpg": "image/jpeg", "png": "image/png"}[tt.ext]
+			if got.Ext != tt.ext || got.ContentType != wantType {
+				t.Errorf("Ext, ContentType = %q, %q, want %q, %q", got.Ext, got.ContentType, tt.ext, wantType)
+			}
+			sizes := map[string]image.Point{"thumbnail": tt.thumb, "medium": tt.med, "original": image.Pt(tt.w, tt.h)}
+			if len(got.Variants) != len(Variants) {
+				t.Errorf("%d variants, want %d", len(got.Variants), len(Variants))
+			}
+			for name, want := range sizes {
+				data, ok := got.Variants[name]
+				if !ok {
+					t.Errorf("no %s variant", name)
+					continue
+				}
+				format, err := Sniff(data)
+				if err != nil || format != map[string]string{"jpg": "jpeg", "png": "png"}[tt.ext] {
+					t.Errorf("%s variant is %q, %v", name, format, err)
+				}
+				cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+				if err != nil {
+					t.Errorf("%s variant doesn't decode: %v", name, err)
+					continue
+				}
+				if size := image.Pt(cfg.Width, cfg.Height); size != want {
+					t.Errorf("%s variant is %v, want %v", name, size, want)
+				}
+				if tt.stripMarker != "" && bytes.Contains(data, []byte(tt.stripMarker)) {
+					t.Errorf("%s variant still carries %q", name, tt.stripMarker)
+				}
+			}
+		})
+	}
+}
+
+func TestIsInvalid(t *testing.T) {
+	for _, err := range []error{ErrTooLarge, ErrUnsupportedFormat, ErrDimensions, fmt.Errorf("cover: %w", ErrDimensions)} {
+		if !IsInvalid(err) {
+			t.Errorf("IsInvalid(%v) = false", err)
+		}
+	}
+	for _, err := range []error{nil, errors.New("storage is down")} {
+		if IsInvalid(err) {
+			t.Errorf("IsInvalid(%v) = true", err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package invoice
+
+import (
+	"html/template"
+	"io"
+)
+
+var htmlTemplate = template.Must(template.New("invoice").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Invoice {{.Number}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; font-size: 14px; color: #222; margin: 40px; }
+table { width: 100%; border-collapse: collapse; margin-top: 24px; }
+th, td { padding: 6px 8px; border-bottom: 1px solid #ddd; text-align: left; }
+td.num, th.num { text-align: right; }
+.totals td { border: none; }
+</style>
+</head>
+<body>
+<h1>Invoice {{.Number}}</h1>
+<p>
+<strong>{{.Store.Name}}</strong><br>
+{{with .Store.Address}}{{.}}<br>{{end}}
+{{with .Store.Email}}{{.}}<br>{{end}}
+{{with .Store.Phone}}{{.}}<br>{{end}}
+{{with .Store.TaxID}}Tax ID: {{.}}{{end}}
+</p>
+<p>
+Issued: {{.IssuedAt.Format "02 Jan 2006 15:04"}}<br>
+Order: #{{.OrderID}}<br>
+Billed to: {{.CustomerName}} &lt;{{.CustomerEmail}}&gt;
+</p>
+<table>
+<tr><th>Item</th><th class="num">Qty</th><th class="num">Unit price</th><th class="num">Discount</th><th class="num">Amount</th></tr>
+{{range .Lines}}<tr><td>{{.Title}}</td><td class="num">{{.Quantity}}</td><td class="num">{{$.Money .UnitPrice}}</td><td class="num">{{$.Money .Discount}}</td><td class="num">{{$.Money .Amount}}</td></tr>
+{{end}}</table>
+<table class="totals">
+<tr><td class="num">Subtotal</td><td class="num">{{.Money .Subtotal}}</td></tr>
+<tr><td class="num">Discount</td><td class="num">-{{.Money .Discount}}</td></tr>
+<tr><td class="num"><strong>Total</strong></td><td class="num"><strong>{{.Money .Total}}</strong></td></tr>
+<tr><td class="num">Includes tax ({{.TaxRate}}%)</td><td class="num">{{.Money .Tax}}</td></tr>
+{{if .StoreCredit}}<tr><td class="num">Paid with store credit</td><td class="num">{{.Money .StoreCredit}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (inv *Invoice) RenderHTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, inv)
+}
@@ -0,0 +1,119 @@
+package invoice
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"bookstore-api/app/config"
+	"bookstore-api/app/models"
+)
+
+type Store struct {
+	Name    string
+	Address string
+	Email   string
+	Phone   string
+	TaxID   string
+}
+
+type Line struct {
+	Title     string
+	Quantity  int
+	UnitPrice float64
+	Discount  float64
+	Amount    float64
+}
+
+// Invoice is the printable view of a paid order. Prices are tax inclusive,
+// so Tax is the portion of Total that is tax at the rate captured at payment.
+// StoreCredit is the part of Total paid from the customer's wallet.
+type Invoice struct {
+	Number        string
+	IssuedAt      time.Time
+	OrderID       uint
+	Store         Store
+	CustomerName  string
+	CustomerEmail string
+	Currency      string
+	Lines         []Line
+	Subtotal      float64
+	Discount      float64
+	TaxRate       float64
+	Tax           float64
+	Total         float64
+	StoreCredit   float64
+}
+
+// New builds the invoice of an order that already has an invoice number.
+// The order must be loaded with its User, Items.Book and Items.Bundle.
+func New(order *models.Order, cfg *config.Config) *Invoice {
+	inv := &Invoice{
+		OrderID:       order.ID,
+		Store:         Store{Name: cfg.StoreName, Address: cfg.StoreAddress, Email: cfg.StoreEmail, Phone: cfg.StorePhone, TaxID: cfg.StoreTaxID},
+		CustomerName:  order.User.Name,
+		CustomerEmail: order.User.Email,
+		Currency:      order.Currency,
+		TaxRate:       order.TaxRate,
+		Total:         order.TotalPrice,
+		StoreCredit:   order.StoreCredit,
+	}
+	if order.InvoiceNumber != nil {
+		inv.Number = *order.InvoiceNumber
+	}
+	if order.PaidAt != nil {
+		inv.IssuedAt = *order.PaidAt
+	}
+	for _, it := range order.Items {
+		gross := it.Price*float64(it.Quantity) + it.Discount
+		title := it.Book.Title
+		if it.Bundle != nil {
+			title = it.Bundle.Title + ": " + title
+		}
+		inv.Lines = append(inv.Lines, Line{
+			Title:     title,
+			Quantity:  it.Quantity,
+			UnitPrice: round(gross / float64(it.Quantity)),
+			Discount:  it.Discount,
+			Amount:    round(it.Price * float64(it.Quantity)),
+		})
+		inv.Subtotal += gross
+		inv.Discount += it.Discount
+	}
+	inv.Subtotal = round(inv.Subtotal)
+	inv.Discount = round(inv.Discount)
+	inv.Tax = round(inv.Total * inv.TaxRate / (100 + inv.TaxRate))
+	return inv
+}
+
+// Filename is a filesystem safe name for the rendered invoice.
+func (inv *Invoice) Filename(ext string) string {
+	return strings.ReplaceAll(inv.Number, "/", "-") + "." + ext
+}
+
+func (inv *Invoice) Money(v float64) string {
+	return inv.Currency + " " + formatAmount(v)
+}
+
+// formatAmount renders v with thousands separators and two decimals.
+func formatAmount(v float64) string {
+	v = round(v) // so what rounds to zero has no minus sign
+	s := fmt.Sprintf("%.2f", math.Abs(v))
+	intPart, frac := s[:len(s)-3], s[len(s)-3:]
+	var b strings.Builder
+	if v < 0 {
+		b.WriteByte('-')
+	}
+	for i, r := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	return b.String() + frac
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
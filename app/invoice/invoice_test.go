@@ -0,0 +1,109 @@
+package invoice
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"bookstore-api/app/config"
+	"bookstore-api/app/models"
+)
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{0, "0.00"},
+		{-0.004, "0.00"},
+		{0.5, "0.50"},
+		{999.99, "999.99"},
+		{1000, "1,000.00"},
+		{123456.789, "123,456.79"},
+		{1234567, "1,234,567.00"},
+		{-5, "-5.00"},
+		{-1234.5, "-1,234.50"},
+		{-999999.999, "-1,000,000.00"},
+	}
+	for _, tt := range tests {
+		if got := formatAmount(tt.in); got != tt.want {
+			t.Errorf("formatAmount(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	number := "INV/2026/000042"
+	paidAt := time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)
+	order := &models.Order{
+		ID:            42,
+		User:          models.User{Name: "Ayu", Email: "ayu@example.com"},
+		Currency:      "IDR",
+		TotalPrice:    117999.99,
+		TaxRate:       11,
+		StoreCredit:   50000,
+		InvoiceNumber: &number,
+		PaidAt:        &paidAt,
+		Items: []models.OrderItem{
+			{Book: models.Book{Title: "Laskar Pelangi"}, Quantity: 2, Price: 9000, Discount: 2000},
+			{Book: models.Book{Title: "Bumi Manusia"}, Bundle: &models.Bundle{Title: "Classics"}, Quantity: 3, Price: 33333.33, Discount: 0.01},
+		},
+	}
+	cfg := &config.Config{StoreName: "Toko Buku", StoreTaxID: "01.234.567.8-901.000"}
+	inv := New(order, cfg)
+
+	if inv.Number != number || !inv.IssuedAt.Equal(paidAt) || inv.OrderID != 42 {
+		t.Errorf("Number, IssuedAt, OrderID = %q, %v, %d", inv.Number, inv.IssuedAt, inv.OrderID)
+	}
+	if inv.Store.Name != "Toko Buku" || inv.Store.TaxID != cfg.StoreTaxID || inv.CustomerEmail != "ayu@example.com" {
+		t.Errorf("Store, CustomerEmail = %+v, %q", inv.Store, inv.CustomerEmail)
+	}
+	want := []Line{
+		{Title: "Laskar Pelangi", Quantity: 2, UnitPrice: 10000, Discount: 2000, Amount: 18000},
+		{Title: "Classics: Bumi Manusia", Quantity: 3, UnitPrice: 33333.33, Discount: 0.01, Amount: 99999.99},
+	}
+	if len(inv.Lines) != len(want) {
+		t.Fatalf("%d lines, want %d", len(inv.Lines), len(want))
+	}
+	for i, l := range inv.Lines {
+		if l != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, l, want[i])
+		}
+	}
+	amounts := []struct {
+		name      string
+		got, want float64
+	}{
+		{"Subtotal", inv.Subtotal, 120000},
+		{"Discount", inv.Discount, 2000.01},
+		{"Total", inv.Total, 117999.99},
+		// prices include tax, so it is 11/111 of the total
+		{"Tax", inv.Tax, 11693.69},
+		{"StoreCredit", inv.StoreCredit, 50000},
+	}
+	for _, a := range amounts {
+		if math.Abs(a.got-a.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", a.name, a.got, a.want)
+		}
+	}
+	if got := inv.Money(inv.Total); got != "IDR 117,999.99" {
+		t.Errorf("Money(Total) = %q", got)
+	}
+	if got := inv.Filename("pdf"); got != "INV-2026-000042.pdf" {
+		t.Errorf("Filename = %q", got)
+	}
+}
+
+func TestNewWithoutTax(t *testing.T) {
+	inv := New(&models.Order{
+		Currency:   "USD",
+		TotalPrice: 25,
+		Items:      []models.OrderItem{{Book: models.Book{Title: "Dune"}, Quantity: 1, Price: 25}},
+	}, &config.Config{})
+	if inv.Tax != 0 || inv.Subtotal != 25 || inv.Discount != 0 {
+		t.Errorf("Tax, Subtotal, Discount = %v, %v, %v, want 0, 25, 0", inv.Tax, inv.Subtotal, inv.Discount)
+	}
+	if inv.Number != "" || !inv.IssuedAt.IsZero() {
+		t.Errorf("unpaid order has Number %q and IssuedAt %v", inv.Number, inv.IssuedAt)
+	}
+}
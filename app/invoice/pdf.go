@@ -0,0 +1,221 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	pageWidth    = 595.0 // A4 in points
+	pageHeight   = 842.0
+	marginX      = 50.0
+	marginTop    = 60.0
+	marginBottom = 60.0
+	lineHeight   = 16.0
+)
+
+type textOp struct {
+	x, y  float64
+	size  float64
+	bold  bool
+	right bool
+	text  string
+}
+
+// pdfWriter lays out text lines on A4 pages using the standard Helvetica
+// fonts, which every PDF reader ships, so no font needs to be embedded.
+type pdfWriter struct {
+	pages [][]textOp
+	y     float64
+}
+
+func newPDFWriter() *pdfWriter {
+	p := &pdfWriter{}
+	p.newPage()
+	return p
+}
+
+func (p *pdfWriter) newPage() {
+	p.pages = append(p.pages, nil)
+	p.y = pageHeight - marginTop
+}
+
+func (p *pdfWriter) add(op textOp) {
+	op.y = p.y
+	p.pages[len(p.pages)-1] = append(p.pages[len(p.pages)-1], op)
+}
+
+// line moves to the next line, breaking the page when it is full.
+func (p *pdfWriter) line() {
+	p.y -= lineHeight
+	if p.y < marginBottom {
+		p.newPage()
+	}
+}
+
+func (inv *Invoice) RenderPDF(w io.Writer) error {
+	p := newPDFWriter()
+	colQty, colUnit, colDisc, colAmount := 300.0, 390.0, 470.0, pageWidth-marginX
+
+	p.add(textOp{x: marginX, size: 18, bold: true, text: "Invoice " + inv.Number})
+	p.line()
+	p.line()
+	p.add(textOp{x: marginX, size: 11, bold: true, text: inv.Store.Name})
+	p.line()
+	for _, s := range []string{inv.Store.Address, inv.Store.Email, inv.Store.Phone} {
+		if s != "" {
+			p.add(textOp{x: marginX, size: 10, text: s})
+			p.line()
+		}
+	}
+	if inv.Store.TaxID != "" {
+		p.add(textOp{x: marginX, size: 10, text: "Tax ID: " + inv.Store.TaxID})
+		p.line()
+	}
+	p.line()
+	p.add(textOp{x: marginX, size: 10, text: "Issued: " + inv.IssuedAt.Format("02 Jan 2006 15:04")})
+	p.line()
+	p.add(textOp{x: marginX, size: 10, text: fmt.Sprintf("Order: #%d", inv.OrderID)})
+	p.line()
+	p.add(textOp{x: marginX, size: 10, text: fmt.Sprintf("Billed to: %s <%s>", inv.CustomerName, inv.CustomerEmail)})
+	p.line()
+	p.line()
+
+	header := func() {
+		p.add(textOp{x: marginX, size: 10, bold: true, text: "Item"})
+		p.add(textOp{x: colQty, size: 10, bold: true, right: true, text: "Qty"})
+		p.add(textOp{x: colUnit, size: 10, bold: true, right: true, text: "Unit price"})
+		p.add(textOp{x: colDisc, size: 10, bold: true, right: true, text: "Discount"})
+		p.add(textOp{x: colAmount, size: 10, bold: true, right: true, text: "Amount"})
+		p.line()
+	}
+	header()
+	for _, l := range inv.Lines {
+		pages := len(p.pages)
+		p.add(textOp{x: marginX, size: 10, text: truncate(l.Title, 40)})
+		p.add(textOp{x: colQty, size: 10, right: true, text: fmt.Sprint(l.Quantity)})
+		p.add(textOp{x: colUnit, size: 10, right: true, text: formatAmount(l.UnitPrice)})
+		p.add(textOp{x: colDisc, size: 10, right: true, text: formatAmount(l.Discount)})
+		p.add(textOp{x: colAmount, size: 10, right: true, text: formatAmount(l.Amount)})
+		p.line()
+		if len(p.pages) != pages {
+			header()
+		}
+	}
+	p.line()
+
+	type total struct {
+		label string
+		value string
+		bold  bool
+	}
+	totals := []total{
+		{"Subtotal", inv.Money(inv.Subtotal), false},
+		{"Discount", "-" + inv.Money(inv.Discount), false},
+		{"Total", inv.Money(inv.Total), true},
+		{fmt.Sprintf("Includes tax (%g%%)", inv.TaxRate), inv.Money(inv.Tax), false},
+	}
+	if inv.StoreCredit > 0 {
+		totals = append(totals, total{"Paid with store credit", inv.Money(inv.StoreCredit), false})
+	}
+	for _, t := range totals {
+		p.add(textOp{x: colDisc, size: 10, bold: t.bold, right: true, text: t.label})
+		p.add(textOp{x: colAmount, size: 10, bold: t.bold, right: true, text: t.value})
+		p.line()
+	}
+	return p.write(w)
+}
+
+func (p *pdfWriter) write(w io.Writer) error {
+	var buf bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Objects 1-4 are fixed; every page then takes a page and a content object.
+	kids := make([]string, len(p.pages))
+	for i := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+i*2)
+	}
+	buf.WriteString("%PDF-1.4\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, ops := range p.pages {
+		var content bytes.Buffer
+		for _, op := range ops {
+			font, x := "F1", op.x
+			if op.bold {
+				font = "F2"
+			}
+			if op.right {
+				x -= textWidth(op.text, op.size)
+			}
+			fmt.Fprintf(&content, "BT /%s %g Tf %.2f %.2f Td (%s) Tj ET\n", font, op.size, x, op.y, escapePDF(op.text))
+		}
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %g %g] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 6+i*2))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// escapePDF escapes a string literal and replaces runes that WinAnsiEncoding
+// cannot represent.
+func escapePDF(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 255:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
+
+// textWidth approximates the Helvetica width of s, good enough to right
+// align amounts and headers.
+func textWidth(s string, size float64) float64 {
+	units := 0
+	for _, r := range s {
+		switch {
+		case r == '.' || r == ',' || r == ' ' || r == 'I' || r == 'i' || r == 'l' || r == 'j' || r == 't' || r == 'f':
+			units += 278
+		case r == '-' || r == 'r' || r == '(' || r == ')':
+			units += 333
+		case r >= 'A' && r <= 'Z':
+			units += 690
+		case r == 'm' || r == 'w':
+			units += 833
+		default:
+			units += 556
+		}
+	}
+	return float64(units) * size / 1000
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-3]) + "..."
+}
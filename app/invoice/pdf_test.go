@@ -0,0 +1,172 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testInvoice(lines int) *Invoice {
+	inv := &Invoice{
+		Number:        "INV/2026/000042",
+		IssuedAt:      time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC),
+		OrderID:       42,
+		Store:         Store{Name: "Toko Buku", Address: "Jl. Braga 1, Bandung", TaxID: "01.234.567.8-901.000"},
+		CustomerName:  "Ayu",
+		CustomerEmail: "ayu@example.com",
+		Currency:      "IDR",
+		TaxRate:       11,
+	}
+	for i := 0; i < lines; i++ {
+		inv.Lines = append(inv.Lines, Line{Title: fmt.Sprintf("Book %d", i+1), Quantity: 1, UnitPrice: 10000, Amount: 10000})
+		inv.Subtotal += 10000
+	}
+	inv.Total = inv.Subtotal
+	inv.Tax = round(inv.Total * 11 / 111)
+	return inv
+}
+
+var (
+	startxrefRe = regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`)
+	sizeRe      = regexp.MustCompile(`trailer\n<< /Size (\d+) /Root 1 0 R >>`)
+	countRe     = regexp.MustCompile(`/Type /Pages /Kids \[[^\]]*\] /Count (\d+)`)
+	streamRe    = regexp.MustCompile(`(?s)<< /Length (\d+) >>\nstream\n(.*?)endstream`)
+)
+
+// checkPDF fails t unless the cross-reference table and stream lengths of
+// out point where they should, and returns the number of pages.
+func checkPDF(t *testing.T, out []byte) int {
+	t.Helper()
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) {
+		t.Fatalf("no PDF header: %q", out[:min(len(out), 20)])
+	}
+	m := startxrefRe.FindSubmatch(out)
+	if m == nil {
+		t.Fatal("no startxref at the end")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if xref >= len(out) || !bytes.HasPrefix(out[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at xref", xref)
+	}
+	table := strings.Split(string(out[xref:]), "\n")
+	var first, n int
+	if _, err := fmt.Sscanf(table[1], "%d %d", &first, &n); err != nil || first != 0 {
+		t.Fatalf("xref subsection %q", table[1])
+	}
+	if table[2] != "0000000000 65535 f " {
+		t.Errorf("xref entry 0 = %q", table[2])
+	}
+	for i := 1; i < n; i++ {
+		entry := table[2+i]
+		if len(entry) != 19 || !strings.HasSuffix(entry, " 00000 n ") {
+			t.Fatalf("xref entry %d = %q", i, entry)
+		}
+		off, _ := strconv.Atoi(entry[:10])
+		if want := fmt.Sprintf("%d 0 obj\n", i); !bytes.HasPrefix(out[off:], []byte(want)) {
+			t.Errorf("xref entry %d points at %q, want %q", i, out[off:min(len(out), off+12)], want)
+		}
+	}
+	if m := sizeRe.FindSubmatch(out); m == nil || string(m[1]) != strconv.Itoa(n) {
+		t.Errorf("trailer /Size doesn't match the %d xref entries", n)
+	}
+	for _, s := range streamRe.FindAllSubmatch(out, -1) {
+		if length, _ := strconv.Atoi(string(s[1])); length != len(s[2]) {
+			t.Errorf("stream /Length %d, holds %d bytes", length, len(s[2]))
+		}
+	}
+	m = countRe.FindSubmatch(out)
+	if m == nil {
+		t.Fatal("no page tree")
+	}
+	pages, _ := strconv.Atoi(string(m[1]))
+	// catalog, page tree, two fonts, then a page and its contents per page
+	if n != 1+4+2*pages {
+		t.Errorf("%d xref entries for %d pages", n, pages)
+	}
+	return pages
+}
+
+func TestRenderPDF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testInvoice(3).RenderPDF(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+	if pages := checkPDF(t, out); pages != 1 {
+		t.Errorf("%d pages, want 1", pages)
+	}
+	for _, want := range []string{"(Invoice INV/2026/000042)", "(Book 3)", "(IDR 30,000.00)", "(IDR 2,972.97)", "(Includes tax \\(11%\\))"} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("PDF doesn't show %s", want)
+		}
+	}
+}
+
+func TestRenderPDFPages(t *testing.T) {
+	const lines = 100
+	var buf bytes.Buffer
+	if err := testInvoice(lines).RenderPDF(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+	pages := checkPDF(t, out)
+	if pages < 3 {
+		t.Fatalf("%d lines on %d pages, want at least 3", lines, pages)
+	}
+	// the table header repeats at the top of every page
+	if headers := bytes.Count(out, []byte("(Item) Tj")); headers != pages {
+		t.Errorf("table header shown %d times on %d pages", headers, pages)
+	}
+	for i := 1; i <= lines; i++ {
+		if !bytes.Contains(out, []byte(fmt.Sprintf("(Book %d) Tj", i))) {
+			t.Errorf("line %d is missing", i)
+		}
+	}
+	if !bytes.Contains(out, []byte("(IDR 1,000,000.00) Tj")) {
+		t.Error("total is missing")
+	}
+}
+
+func TestEscapePDF(t *testing.T) {
+	tests := map[string]string{
+		"plain":         "plain",
+		"(a) \\ b":      "\\(a\\) \\\\ b",
+		"Café":          "Caf\xe9",
+		"Tab\there":     "Tab?here",
+		"€ 5":           "? 5",
+		"日本語":           "???",
+		"line\r\nbreak": "line??break",
+	}
+	for in, want := range tests {
+		if got := escapePDF(in); got != want {
+			t.Errorf("escapePDF(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"Dune", 10, "Dune"},
+		{"exactly10!", 10, "exactly10!"},
+		{"The Lord of the Rings", 10, "The Lor..."},
+		{"Ronggeng Dukuh Paruk é", 21, "Ronggeng Dukuh Par..."},
+		{"ééééééééééé", 10, "ééééééé..."},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.in, tt.n)
+		if got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+		if n := len([]rune(got)); n > tt.n {
+			t.Errorf("truncate(%q, %d) is %d runes long", tt.in, tt.n, n)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Every runs fn right away and then once per interval until ctx is done.
+// Failures are logged and retried at the next tick. A zero interval
+// disables the job.
+func Every(ctx context.Context, name string, interval time.Duration, fn func(context.Context) error) {
+	if interval <= 0 {
+		log.Printf("job %s disabled", name)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		if err := fn(ctx); err != nil {
+			log.Printf("job %s failed: %v", name, err)
+		} else {
+			log.Printf("job %s done in %s", name, time.Since(start).Round(time.Millisecond))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RoleAuthor     = "author"
+	RoleTranslator = "translator"
+	RoleEditor     = "editor"
+)
+
+type Author struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	Name      string          `gorm:"size:150;not null" json:"name"`
+	Slug      string          `gorm:"size:170;not null;uniqueIndex" json:"slug"`
+	Bio       string          `gorm:"type:text" json:"bio"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	DeletedAt *gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+}
+
+// BookAuthor links a book to a contributor. Position orders the
+// contributors the way they are credited on the cover.
+type BookAuthor struct {
+	BookID   uint   `gorm:"primaryKey" json:"book_id"`
+	AuthorID uint   `gorm:"primaryKey" json:"author_id"`
+	Role     string `gorm:"primaryKey;type:VARCHAR(12) CHECK (role IN ('author','translator','editor'))" json:"role"`
+	Position int    `gorm:"not null;default:0" json:"position"`
+	Author   Author `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
+}
@@ -6,22 +6,124 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	FormatHardcover = "hardcover"
+	FormatPaperback = "paperback"
+	FormatEbook     = "ebook"
+)
+
 type Category struct {
-	ID   uint   `gorm:"primaryKey" json:"id"`
-	Name string `gorm:"size:100;not null;unique" json:"name"`
+	ID       uint       `gorm:"primaryKey" json:"id"`
+	Name     string     `gorm:"size:100;not null;unique" json:"name"`
+	Slug     string     `gorm:"size:120;not null;default:'';uniqueIndex:idx_categories_slug,where:slug <> ''" json:"slug"`
+	ParentID *uint      `gorm:"index" json:"parent_id"`
+	Parent   *Category  `gorm:"foreignKey:ParentID" json:"parent,omitempty"`
+	Children []Category `gorm:"foreignKey:ParentID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT" json:"children,omitempty"`
 }
 
+// Book.Author is the byline shown for the book, kept in sync with Authors.
+// Admins are alerted when Stock falls below ReorderThreshold, once until
+// it is restocked; LowStockAlertedAt marks that an alert went out. Until
+// its ReleaseDate a book is on pre-order and every copy ordered waits for
+// release; after that, up to BackorderCap copies can be ordered beyond
+// Stock. Price is the list price, which PriceRules may lower for a while.
+// An ebook has no stock limit; its file is kept in storage under FileKey
+// and only downloaded through signed links once paid for.
 type Book struct {
-	ID          uint            `gorm:"primaryKey" json:"id"`
-	Title       string          `gorm:"size:255;not null" json:"title"`
-	Author      string          `gorm:"size:100;not null" json:"author"`
-	Price       float64         `gorm:"type:decimal(10,2);not null" json:"price"`
-	Stock       int             `gorm:"not null" json:"stock"`
-	Year        int             `json:"year"`
-	CategoryID  uint            `json:"category_id"`
-	Category    Category        `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
-	ImageBase64 string          `gorm:"type:text" json:"image_base64"`
-	CreatedAt   time.Time       `json:"created_at"`
-	UpdatedAt   time.Time       `json:"updated_at"`
-	DeletedAt   *gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	ID                uint             `gorm:"primaryKey" json:"id"`
+	Title             string           `gorm:"size:255;not null" json:"title"`
+	Author            string           `gorm:"size:255;not null" json:"author"`
+	Authors           []BookAuthor     `gorm:"foreignKey:BookID" json:"authors,omitempty"`
+	ISBN              *string          `gorm:"column:isbn;size:13;uniqueIndex:idx_books_isbn,where:deleted_at IS NULL" json:"isbn"`
+	Publisher         string           `gorm:"size:150" json:"publisher"`
+	Language          string           `gorm:"size:35" json:"language"`
+	PageCount         int              `json:"page_count"`
+	Edition           string           `gorm:"size:50" json:"edition"`
+	Format            string           `gorm:"type:VARCHAR(10) CHECK (format IN ('hardcover','paperback','ebook'));default:'paperback'" json:"format"`
+	Description       string           `gorm:"type:text" json:"description"`
+	Price             float64          `gorm:"type:decimal(10,2);not null" json:"price"`
+	Currency          string           `gorm:"size:3;not null;default:'IDR'" json:"currency"`
+	Stock             int              `gorm:"not null" json:"stock"`
+	Warehouses        []WarehouseStock `gorm:"foreignKey:BookID" json:"warehouses,omitempty"`
+	ReorderThreshold  int              `gorm:"not null;default:0" json:"reorder_threshold"`
+	ReorderQuantity   int              `gorm:"not null;default:0" json:"reorder_quantity"`
+	LowStockAlertedAt *time.Time       `json:"low_stock_alerted_at,omitempty"`
+	ReleaseDate       *time.Time       `json:"release_date,omitempty"`
+	BackorderCap      int              `gorm:"not null;default:0" json:"backorder_cap"`
+	SoldCount         int              `gorm:"not null;default:0;index" json:"sold_count"`
+	RatingAvg         float64          `gorm:"type:decimal(3,2);not null;default:0" json:"rating_average"`
+	RatingCount       int              `gorm:"not null;default:0" json:"rating_count"`
+	Year              int              `json:"year"`
+	CategoryID        uint             `json:"category_id"`
+	Category          Category         `gorm:"foreignKey:CategoryID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT" json:"category,omitempty"`
+	ImageKey          string           `gorm:"size:255" json:"-"`
+	ImageExt          string           `gorm:"size:4" json:"-"`
+	ImageURL          string           `gorm:"-" json:"image_url"`
+	ImageURLs         *CoverURLs       `gorm:"-" json:"image_urls,omitempty"`
+	FileKey           string           `gorm:"size:255" json:"-"`
+	FileName          string           `gorm:"size:255" json:"-"`
+	FileType          string           `gorm:"size:100" json:"file_type,omitempty"`
+	FileSize          int64            `gorm:"not null;default:0" json:"file_size,omitempty"`
+	CreatedAt         time.Time        `gorm:"index" json:"created_at"`
+	UpdatedAt         time.Time        `json:"updated_at"`
+	DeletedAt         *gorm.DeletedAt  `gorm:"index" json:"deleted_at,omitempty"`
+
+	// Price after the sale price rules in effect, where it was selected
+	EffectivePrice *float64 `gorm:"->;-:migration" json:"effective_price,omitempty"`
+
+	// the value ListBooks sorted on, as text, for building its cursor
+	SortKey *string `gorm:"->;-:migration" json:"-"`
+
+	// filled in by ListBooks when searching; highlights are HTML escaped
+	// apart from the <mark> tags around matches
+	SearchRank         *float64 `gorm:"->;-:migration" json:"search_rank,omitempty"`
+	TitleHighlight     *string  `gorm:"->;-:migration" json:"title_highlight,omitempty"`
+	DescriptionSnippet *string  `gorm:"->;-:migration" json:"description_snippet,omitempty"`
+}
+
+// CoverURLs are the paths the cover variants are served from.
+type CoverURLs struct {
+	Thumbnail string `json:"thumbnail"`
+	Medium    string `json:"medium"`
+	Original  string `json:"original"`
+}
+
+// CoverKey is the storage key of one variant of the book's cover.
+func (b *Book) CoverKey(variant string) string {
+	return CoverKey(b.ImageKey, variant, b.ImageExt)
+}
+
+// CoverKey is the storage key of one variant of a cover. Covers stored
+// before variants existed have no ext; their base is the key of their only
+// file, which stands in for every variant.
+func CoverKey(base, variant, ext string) string {
+	if ext == "" {
+		return base
+	}
+	return base + "/" + variant + "." + ext
+}
+
+func (b *Book) AfterFind(tx *gorm.DB) error {
+	b.setImageURLs()
+	return nil
+}
+
+func (b *Book) AfterSave(tx *gorm.DB) error {
+	b.setImageURLs()
+	return nil
+}
+
+// setImageURLs fills in ImageURLs and, for clients from before there were
+// variants, ImageURL with the original.
+func (b *Book) setImageURLs() {
+	if b.ImageKey == "" {
+		b.ImageURL, b.ImageURLs = "", nil
+		return
+	}
+	b.ImageURLs = &CoverURLs{
+		Thumbnail: "/" + b.CoverKey("thumbnail"),
+		Medium:    "/" + b.CoverKey("medium"),
+		Original:  "/" + b.CoverKey("original"),
+	}
+	b.ImageURL = b.ImageURLs.Original
 }
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Bundle sells several books together, such as a series box set, at its
+// own Price. It has no stock of its own: ordering one takes its books, and
+// Available is how many complete bundles their stock makes up, nil for a
+// bundle of ebooks, which never runs out.
+type Bundle struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	Title       string          `gorm:"size:255;not null" json:"title"`
+	Description string          `gorm:"type:text" json:"description"`
+	Price       float64         `gorm:"type:decimal(10,2);not null" json:"price"`
+	Currency    string          `gorm:"size:3;not null;default:'IDR'" json:"currency"`
+	Items       []BundleItem    `gorm:"constraint:OnDelete:CASCADE" json:"items,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	DeletedAt   *gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+
+	Available *int `gorm:"->;-:migration" json:"available,omitempty"`
+}
+
+// BundleItem is a book in a bundle and how many copies of it each bundle
+// contains.
+type BundleItem struct {
+	BundleID uint `gorm:"primaryKey;autoIncrement:false" json:"bundle_id"`
+	BookID   uint `gorm:"primaryKey;autoIncrement:false;index" json:"book_id"`
+	Book     Book `gorm:"foreignKey:BookID" json:"book,omitempty"`
+	Quantity int  `gorm:"not null;check:chk_bundle_items_quantity,quantity > 0" json:"quantity"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// BaseCurrency is the currency every exchange rate is expressed against.
+const BaseCurrency = "IDR"
+
+type ExchangeRate struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Currency      string    `gorm:"size:3;not null;index:idx_exchange_rates_currency_effective,priority:1" json:"currency"`
+	Rate          float64   `gorm:"type:decimal(18,8);not null" json:"rate"`
+	EffectiveFrom time.Time `gorm:"not null;index:idx_exchange_rates_currency_effective,priority:2" json:"effective_from"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// EbookDownload logs one download of an ebook bought with an order item.
+// Downloads are counted per item against the download limit.
+type EbookDownload struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	OrderItemID uint      `gorm:"not null;index" json:"order_item_id"`
+	OrderID     uint      `gorm:"not null;index" json:"order_id"`
+	BookID      uint      `gorm:"not null;index" json:"book_id"`
+	UserID      uint      `gorm:"not null;index" json:"user_id"`
+	IP          string    `gorm:"size:45" json:"ip"`
+	UserAgent   string    `gorm:"size:255" json:"user_agent"`
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`
+}
@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+// Flash sale reservation statuses. A reservation holds part of the sale
+// until it is converted into an order, released, or expires.
+const (
+	ReservationHeld      = "held"
+	ReservationConverted = "converted"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+)
+
+// FlashSale sells a limited Quantity of a book at Price, in the book's
+// currency, between StartsAt and EndsAt, at most PerUserLimit copies per
+// customer (0 for no limit). Copies are reserved through a fast counter
+// rather than the book's row lock; see the flashsale package. The copies
+// are taken out of stock into FlashSaleStock when the sale is created, and
+// what is left unsold goes back once the sale has ended, at SettledAt.
+type FlashSale struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	BookID       uint       `gorm:"not null;index" json:"book_id"`
+	Book         Book       `gorm:"foreignKey:BookID" json:"book,omitempty"`
+	Price        float64    `gorm:"type:decimal(10,2);not null" json:"price"`
+	Quantity     int        `gorm:"not null;check:chk_flash_sales_quantity,quantity > 0" json:"quantity"`
+	PerUserLimit int        `gorm:"not null;default:0" json:"per_user_limit"`
+	StartsAt     time.Time  `gorm:"not null" json:"starts_at"`
+	EndsAt       time.Time  `gorm:"not null" json:"ends_at"`
+	UserID       uint       `json:"user_id"`
+	SettledAt    *time.Time `json:"settled_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+
+	// what the counter has left, filled in when shown
+	Left *int `gorm:"-" json:"left,omitempty"`
+}
+
+// FlashSaleReservation is a customer's hold on part of a flash sale, valid
+// until ExpiresAt.
+type FlashSaleReservation struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	FlashSaleID uint       `gorm:"not null;index" json:"flash_sale_id"`
+	FlashSale   *FlashSale `gorm:"foreignKey:FlashSaleID" json:"flash_sale,omitempty"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	Quantity    int        `gorm:"not null" json:"quantity"`
+	Status      string     `gorm:"type:VARCHAR(10) CHECK (status IN ('held','converted','released','expired'));not null;default:'held'" json:"status"`
+	ExpiresAt   time.Time  `gorm:"not null;index:idx_flash_sale_reservations_held,where:status = 'held'" json:"expires_at"`
+	OrderID     *uint      `json:"order_id,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// FlashSaleStock is how many copies of a flash sale are held in a
+// warehouse, out of the book's stock, for orders from its reservations.
+type FlashSaleStock struct {
+	FlashSaleID uint `gorm:"primaryKey"`
+	WarehouseID uint `gorm:"primaryKey"`
+	Quantity    int  `gorm:"not null;check:chk_flash_sale_stocks_quantity,quantity >= 0"`
+}
@@ -1,24 +1,96 @@
 package models
 
 import (
+	"math"
 	"time"
+
+	"gorm.io/gorm"
+)
+
+// Order statuses. An order is BACKORDERED while some of its items wait for
+// stock; it becomes PENDING, ready to pay, once every item is allocated.
+const (
+	OrderBackordered = "BACKORDERED"
+	OrderPending     = "PENDING"
+	OrderPaid        = "PAID"
+	OrderCancelled   = "CANCELLED"
+	OrderRefunded    = "REFUNDED"
+)
+
+// Refund methods. A refund to store credit puts the whole order total in
+// the wallet; one to the original methods only gives back the store credit
+// spent, the rest being refunded outside the store.
+const (
+	RefundStoreCredit = "store_credit"
+	RefundOriginal    = "original"
 )
 
+// Order.ExpectedShipAt is when the last backordered item is expected to
+// ship, if that is known. StoreCredit is the part of TotalPrice paid from
+// the user's wallet when the order was placed, and AmountDue what is left
+// to pay otherwise.
 type Order struct {
-	ID         uint        `gorm:"primaryKey" json:"id"`
-	UserID     uint        `json:"user_id"`
-	User       User        `gorm:"foreignKey:UserID" json:"user,omitempty"`
-	TotalPrice float64     `gorm:"type:decimal(10,2)" json:"total_price"`
-	Status     string      `gorm:"type:VARCHAR(20);default:'PENDING'" json:"status"`
-	CreatedAt  time.Time   `json:"created_at"`
-	Items      []OrderItem `json:"items" gorm:"constraint:OnDelete:CASCADE"`
+	ID             uint        `gorm:"primaryKey" json:"id"`
+	UserID         uint        `json:"user_id"`
+	User           User        `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	TotalPrice     float64     `gorm:"type:decimal(10,2)" json:"total_price"`
+	Currency       string      `gorm:"size:3;not null;default:'IDR'" json:"currency"`
+	ExchangeRate   float64     `gorm:"type:decimal(18,8);not null;default:1" json:"exchange_rate"`
+	Status         string      `gorm:"type:VARCHAR(20);default:'PENDING'" json:"status"`
+	InvoiceNumber  *string     `gorm:"size:30;uniqueIndex" json:"invoice_number,omitempty"`
+	TaxRate        float64     `gorm:"type:decimal(5,2);not null;default:0" json:"tax_rate"`
+	PaidAt         *time.Time  `json:"paid_at,omitempty"`
+	ExpectedShipAt *time.Time  `json:"expected_ship_at,omitempty"`
+	StoreCredit    float64     `gorm:"type:decimal(10,2);not null;default:0" json:"store_credit"`
+	AmountDue      float64     `gorm:"-" json:"amount_due"`
+	RefundedAt     *time.Time  `json:"refunded_at,omitempty"`
+	RefundMethod   string      `gorm:"size:20" json:"refund_method,omitempty"`
+	CreatedAt      time.Time   `json:"created_at"`
+	Items          []OrderItem `json:"items" gorm:"constraint:OnDelete:CASCADE"`
+}
+
+func (o *Order) AfterFind(tx *gorm.DB) error {
+	o.setAmountDue()
+	return nil
+}
+
+func (o *Order) AfterSave(tx *gorm.DB) error {
+	o.setAmountDue()
+	return nil
+}
+
+func (o *Order) setAmountDue() {
+	o.AmountDue = math.Round((o.TotalPrice-o.StoreCredit)*100) / 100
 }
 
+// OrderItem.BackorderedQuantity is how much of the item still waits for
+// stock. Waiting items are allocated first come, first served. Books
+// ordered as part of a bundle have one item each, referring to the Bundle
+// and to how many of it were ordered; their prices add up to the bundle's.
+// A Digital item is an ebook: it takes no stock and is downloaded instead.
 type OrderItem struct {
 	ID       uint    `gorm:"primaryKey" json:"id"`
 	OrderID  uint    `json:"order_id"`
-	BookID   uint    `json:"book_id"`
+	BookID   uint    `gorm:"index:idx_order_items_backordered,where:backordered_quantity > 0" json:"book_id"`
 	Book     Book    `gorm:"foreignKey:BookID" json:"book,omitempty"`
 	Quantity int     `json:"quantity"`
 	Price    float64 `gorm:"type:decimal(10,2)" json:"price"`
+	Discount float64 `gorm:"type:decimal(10,2);not null;default:0" json:"discount"`
+	Digital  bool    `gorm:"not null;default:false" json:"digital,omitempty"`
+
+	BackorderedQuantity int        `gorm:"not null;default:0" json:"backordered_quantity"`
+	ExpectedShipAt      *time.Time `json:"expected_ship_at,omitempty"`
+
+	BundleID       *uint   `gorm:"index" json:"bundle_id,omitempty"`
+	Bundle         *Bundle `gorm:"foreignKey:BundleID" json:"bundle,omitempty"`
+	BundleQuantity int     `gorm:"not null;default:0" json:"bundle_quantity,omitempty"`
+
+	Allocations []OrderItemAllocation `gorm:"foreignKey:OrderItemID;constraint:OnDelete:CASCADE" json:"allocations,omitempty"`
+}
+
+// InvoiceSequence hands out gap-free invoice numbers per year. Its row is
+// locked and incremented in the same transaction that marks an order PAID.
+type InvoiceSequence struct {
+	Year       int `gorm:"primaryKey;autoIncrement:false"`
+	LastNumber int `gorm:"not null;default:0"`
 }
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PriceRule puts a book, or every book in a category and its
+// subcategories, on sale from StartsAt until EndsAt, or until it is deleted
+// when it has no end. The sale is either a fixed SalePrice, in the book's
+// currency and only for a single book, or PercentOff the book's price.
+// Where rules overlap the lowest price wins, and a rule never raises a
+// price. Rules are not edited, so together they record every past sale.
+type PriceRule struct {
+	ID         uint            `gorm:"primaryKey" json:"id"`
+	Name       string          `gorm:"size:100;not null" json:"name"`
+	BookID     *uint           `gorm:"index;check:chk_price_rules_target,(book_id IS NULL) <> (category_id IS NULL)" json:"book_id,omitempty"`
+	Book       *Book           `gorm:"foreignKey:BookID" json:"book,omitempty"`
+	CategoryID *uint           `gorm:"index" json:"category_id,omitempty"`
+	Category   *Category       `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
+	SalePrice  *float64        `gorm:"type:decimal(10,2);check:chk_price_rules_sale,(sale_price IS NULL) <> (percent_off IS NULL)" json:"sale_price,omitempty"`
+	PercentOff *float64        `gorm:"type:decimal(5,2)" json:"percent_off,omitempty"`
+	StartsAt   time.Time       `gorm:"not null;index" json:"starts_at"`
+	EndsAt     *time.Time      `json:"ends_at,omitempty"`
+	UserID     uint            `json:"user_id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	DeletedAt  *gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+}
+
+// BookPriceChange records a change of a book's list price, for audit. A
+// book's first price has no old one. The table is append-only.
+type BookPriceChange struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	BookID      uint      `gorm:"not null;index" json:"book_id"`
+	OldPrice    *float64  `gorm:"type:decimal(10,2)" json:"old_price,omitempty"`
+	OldCurrency string    `gorm:"size:3" json:"old_currency,omitempty"`
+	Price       float64   `gorm:"type:decimal(10,2);not null" json:"price"`
+	Currency    string    `gorm:"size:3;not null" json:"currency"`
+	UserID      *uint     `json:"user_id,omitempty"`
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Purchase order statuses. Drafts can still be edited; once sent, goods are
+// received against the order until every line is in.
+const (
+	PurchaseDraft             = "draft"
+	PurchaseSent              = "sent"
+	PurchasePartiallyReceived = "partially_received"
+	PurchaseReceived          = "received"
+	PurchaseCancelled         = "cancelled"
+)
+
+type Supplier struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	Name        string          `gorm:"size:150;not null" json:"name"`
+	ContactName string          `gorm:"size:100" json:"contact_name"`
+	Email       string          `gorm:"size:150" json:"email"`
+	Phone       string          `gorm:"size:30" json:"phone"`
+	Address     string          `gorm:"size:255" json:"address"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	DeletedAt   *gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+}
+
+// PurchaseOrder restocks books from a supplier into a warehouse. Unit costs
+// are in the order's Currency.
+type PurchaseOrder struct {
+	ID          uint                `gorm:"primaryKey" json:"id"`
+	SupplierID  uint                `gorm:"not null;index" json:"supplier_id"`
+	Supplier    Supplier            `gorm:"foreignKey:SupplierID" json:"supplier,omitempty"`
+	WarehouseID uint                `gorm:"not null" json:"warehouse_id"`
+	Warehouse   Warehouse           `gorm:"foreignKey:WarehouseID" json:"warehouse,omitempty"`
+	Status      string              `gorm:"type:VARCHAR(20) CHECK (status IN ('draft','sent','partially_received','received','cancelled'));not null;default:'draft';index" json:"status"`
+	Currency    string              `gorm:"size:3;not null;default:'IDR'" json:"currency"`
+	ExpectedAt  *time.Time          `json:"expected_at"`
+	SentAt      *time.Time          `json:"sent_at"`
+	ReceivedAt  *time.Time          `json:"received_at"`
+	Notes       string              `gorm:"type:text" json:"notes"`
+	UserID      uint                `json:"user_id"`
+	Items       []PurchaseOrderItem `gorm:"foreignKey:PurchaseOrderID;constraint:OnDelete:CASCADE" json:"items"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+type PurchaseOrderItem struct {
+	ID               uint    `gorm:"primaryKey" json:"id"`
+	PurchaseOrderID  uint    `gorm:"not null;index" json:"purchase_order_id"`
+	BookID           uint    `gorm:"not null;index" json:"book_id"`
+	Book             Book    `gorm:"foreignKey:BookID" json:"book,omitempty"`
+	QuantityOrdered  int     `gorm:"not null" json:"quantity_ordered"`
+	QuantityReceived int     `gorm:"not null;default:0" json:"quantity_received"`
+	UnitCost         float64 `gorm:"type:decimal(10,2);not null" json:"unit_cost"`
+
+	Receipts []PurchaseReceipt `gorm:"foreignKey:PurchaseOrderItemID" json:"receipts,omitempty"`
+}
+
+// PurchaseReceipt records goods received against a purchase order line, at
+// the unit cost actually invoiced.
+type PurchaseReceipt struct {
+	ID                  uint      `gorm:"primaryKey" json:"id"`
+	PurchaseOrderItemID uint      `gorm:"not null;index" json:"purchase_order_item_id"`
+	Quantity            int       `gorm:"not null" json:"quantity"`
+	UnitCost            float64   `gorm:"type:decimal(10,2);not null" json:"unit_cost"`
+	StockMovementID     uint      `json:"stock_movement_id"`
+	UserID              uint      `json:"user_id"`
+	CreatedAt           time.Time `json:"created_at"`
+}
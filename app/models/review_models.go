@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Review is a customer's rating of a book they bought. Hidden reviews are
+// kept but left out of listings and of the book's rating.
+type Review struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	BookID    uint            `gorm:"not null;uniqueIndex:idx_reviews_book_user,where:deleted_at IS NULL" json:"book_id"`
+	Book      Book            `gorm:"foreignKey:BookID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	UserID    uint            `gorm:"not null;index;uniqueIndex:idx_reviews_book_user,where:deleted_at IS NULL" json:"user_id"`
+	User      User            `gorm:"foreignKey:UserID" json:"-"`
+	Rating    int             `gorm:"type:SMALLINT CHECK (rating BETWEEN 1 AND 5);not null" json:"rating"`
+	Title     string          `gorm:"size:150" json:"title"`
+	Body      string          `gorm:"type:text" json:"body"`
+	Hidden    bool            `gorm:"not null;default:false" json:"hidden"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	DeletedAt *gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+
+	// filled in when listing, reviews only show the reviewer's name
+	ReviewerName string `gorm:"->;-:migration" json:"reviewer_name,omitempty"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Stock movement types.
+const (
+	MovementSale         = "sale"
+	MovementRestock      = "restock"
+	MovementAdjustment   = "adjustment"
+	MovementReturn       = "return"
+	MovementCancellation = "cancellation"
+	MovementTransfer     = "transfer"
+	MovementFlashSale    = "flash_sale"
+)
+
+// StockMovement is one change to a book's stock. The table is append-only,
+// so a book's stock is always the sum of its movements' quantities.
+// StockAfter is the book's total stock right after the movement;
+// OrderID, TransferID, PurchaseOrderID and UserID reference what caused
+// it. Movements recorded before warehouses existed have no WarehouseID and
+// belong to the first warehouse.
+type StockMovement struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	BookID          uint      `gorm:"not null;index:idx_stock_movements_book,priority:1" json:"book_id"`
+	Book            Book      `gorm:"foreignKey:BookID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT" json:"-"`
+	WarehouseID     *uint     `gorm:"index" json:"warehouse_id,omitempty"`
+	Type            string    `gorm:"size:20;not null;index" json:"type"`
+	Quantity        int       `gorm:"not null" json:"quantity"`
+	StockAfter      int       `gorm:"not null" json:"stock_after"`
+	OrderID         *uint     `gorm:"index" json:"order_id,omitempty"`
+	TransferID      *uint     `json:"transfer_id,omitempty"`
+	PurchaseOrderID *uint     `gorm:"index" json:"purchase_order_id,omitempty"`
+	UserID          *uint     `json:"user_id,omitempty"`
+	Reason          string    `gorm:"size:255" json:"reason,omitempty"`
+	CreatedAt       time.Time `gorm:"index:idx_stock_movements_book,priority:2" json:"created_at"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Wallet transaction types.
+const (
+	WalletGiftCard = "gift_card"
+	WalletPayment  = "payment"
+	WalletRefund   = "refund"
+)
+
+// Wallet is a user's store credit, in the base currency. Balance only
+// changes together with a WalletTransaction and can't go below zero.
+type Wallet struct {
+	UserID    uint      `gorm:"primaryKey;autoIncrement:false" json:"user_id"`
+	Balance   float64   `gorm:"type:decimal(12,2);not null;default:0;check:chk_wallets_balance,balance >= 0" json:"balance"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WalletTransaction is one entry of the append-only wallet ledger. Amount
+// is what it added to the balance, negative when credit was spent, and
+// BalanceAfter the balance it left. CreatedBy is who cancelled or refunded
+// the order behind a refund.
+type WalletTransaction struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"not null;index" json:"user_id"`
+	Type         string    `gorm:"type:VARCHAR(20);not null;check:chk_wallet_transactions_type,type IN ('gift_card','payment','refund')" json:"type"`
+	Amount       float64   `gorm:"type:decimal(12,2);not null" json:"amount"`
+	BalanceAfter float64   `gorm:"type:decimal(12,2);not null" json:"balance_after"`
+	GiftCardID   *uint     `gorm:"index" json:"gift_card_id,omitempty"`
+	OrderID      *uint     `gorm:"index" json:"order_id,omitempty"`
+	CreatedBy    *uint     `json:"created_by,omitempty"`
+	Reason       string    `gorm:"size:255" json:"reason,omitempty"`
+	CreatedAt    time.Time `gorm:"index" json:"created_at"`
+}
+
+// GiftCard is a code worth Amount of store credit, in the base currency,
+// redeemed once into the wallet of RedeemedBy.
+type GiftCard struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Code       string     `gorm:"size:19;not null;uniqueIndex" json:"code"`
+	Amount     float64    `gorm:"type:decimal(12,2);not null;check:chk_gift_cards_amount,amount > 0" json:"amount"`
+	Note       string     `gorm:"size:255" json:"note,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RedeemedBy *uint      `gorm:"index" json:"redeemed_by,omitempty"`
+	RedeemedAt *time.Time `json:"redeemed_at,omitempty"`
+	UserID     uint       `json:"user_id"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
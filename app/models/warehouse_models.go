@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// Warehouse is a location books ship from. Allocation prefers the primary
+// warehouse, then lower Priority values.
+type Warehouse struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Code      string    `gorm:"size:20;not null;uniqueIndex" json:"code"`
+	Name      string    `gorm:"size:100;not null" json:"name"`
+	Address   string    `gorm:"size:255" json:"address"`
+	IsPrimary bool      `gorm:"not null;default:false;uniqueIndex:idx_warehouses_primary,where:is_primary" json:"is_primary"`
+	Priority  int       `gorm:"not null;default:0" json:"priority"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WarehouseStock is how many copies of a book a warehouse holds. Book.Stock
+// is kept equal to the sum over all warehouses.
+type WarehouseStock struct {
+	WarehouseID uint      `gorm:"primaryKey" json:"warehouse_id"`
+	Warehouse   Warehouse `gorm:"foreignKey:WarehouseID;constraint:OnDelete:RESTRICT" json:"warehouse"`
+	BookID      uint      `gorm:"primaryKey;index" json:"book_id"`
+	Quantity    int       `gorm:"not null;default:0;check:chk_warehouse_stocks_quantity,quantity >= 0" json:"quantity"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// StockTransfer moves copies of a book between warehouses. It is recorded
+// in the ledger as a pair of transfer movements.
+type StockTransfer struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	BookID          uint      `gorm:"not null;index" json:"book_id"`
+	Book            Book      `gorm:"foreignKey:BookID" json:"book,omitempty"`
+	FromWarehouseID uint      `gorm:"not null" json:"from_warehouse_id"`
+	FromWarehouse   Warehouse `gorm:"foreignKey:FromWarehouseID" json:"from_warehouse,omitempty"`
+	ToWarehouseID   uint      `gorm:"not null" json:"to_warehouse_id"`
+	ToWarehouse     Warehouse `gorm:"foreignKey:ToWarehouseID" json:"to_warehouse,omitempty"`
+	Quantity        int       `gorm:"not null" json:"quantity"`
+	UserID          uint      `json:"user_id"`
+	Reason          string    `gorm:"size:255" json:"reason,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// OrderItemAllocation is the part of an order item shipped from one
+// warehouse.
+type OrderItemAllocation struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	OrderItemID uint      `gorm:"not null;index" json:"order_item_id"`
+	WarehouseID uint      `gorm:"not null" json:"warehouse_id"`
+	Warehouse   Warehouse `gorm:"foreignKey:WarehouseID" json:"warehouse,omitempty"`
+	Quantity    int       `gorm:"not null" json:"quantity"`
+}
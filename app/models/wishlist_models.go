@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+type WishlistItem struct {
+	UserID    uint      `gorm:"primaryKey" json:"user_id"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	BookID    uint      `gorm:"primaryKey;index" json:"book_id"`
+	Book      Book      `gorm:"foreignKey:BookID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"book,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RestockEvent records a book's stock going from zero to positive. Stock is
+// the level it was restocked to.
+type RestockEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	BookID    uint      `gorm:"not null;index" json:"book_id"`
+	Stock     int       `gorm:"not null" json:"stock"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RestockNotification is claimed before a back-in-stock message is sent, so
+// its key makes sure each user hears about a restock at most once. Error
+// keeps the reason when delivery failed.
+type RestockNotification struct {
+	RestockEventID uint      `gorm:"primaryKey" json:"restock_event_id"`
+	UserID         uint      `gorm:"primaryKey" json:"user_id"`
+	Error          string    `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
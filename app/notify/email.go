@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type EmailOptions struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Email sends plain-text messages through an SMTP server. Authentication is
+// only used when a username is configured.
+type Email struct {
+	opts   EmailOptions
+	sender string // bare address of From, for the SMTP envelope
+}
+
+func NewEmail(opts EmailOptions) (*Email, error) {
+	if opts.Host == "" || opts.From == "" {
+		return nil, errors.New("email notifier needs SMTP_HOST and SMTP_FROM")
+	}
+	from, err := mail.ParseAddress(opts.From)
+	if err != nil {
+		return nil, fmt.Errorf("SMTP_FROM: %w", err)
+	}
+	if opts.Port == "" {
+		opts.Port = "587"
+	}
+	return &Email{opts: opts, sender: from.Address}, nil
+}
+
+func (e *Email) Notify(ctx context.Context, msg Message) error {
+	if msg.To == "" {
+		return errors.New("message has no recipient")
+	}
+	for _, v := range []string{msg.To, msg.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return errors.New("recipient and subject must be a single line")
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", e.opts.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if e.opts.Username != "" {
+		auth = smtp.PlainAuth("", e.opts.Username, e.opts.Password, e.opts.Host)
+	}
+	addr := net.JoinHostPort(e.opts.Host, e.opts.Port)
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(addr, auth, e.sender, []string{msg.To}, []byte(b.String())) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
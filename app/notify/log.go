@@ -0,0 +1,17 @@
+package notify
+
+import (
+	"context"
+	"log"
+)
+
+// Log writes messages to the application log, for development and for
+// deployments that don't send notifications yet.
+type Log struct{}
+
+func NewLog() *Log { return &Log{} }
+
+func (Log) Notify(_ context.Context, msg Message) error {
+	log.Printf("notify %s to=%s subject=%q", msg.Event, msg.To, msg.Subject)
+	return nil
+}
@@ -0,0 +1,44 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+
+	"bookstore-api/app/config"
+)
+
+// Message is a notification for one recipient. Event names what happened,
+// e.g. "book.back_in_stock", so webhook consumers can route it; Data holds
+// the event's details.
+type Message struct {
+	Event   string                 `json:"event"`
+	To      string                 `json:"to"`
+	Subject string                 `json:"subject"`
+	Body    string                 `json:"body"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// Notifier delivers messages to customers or staff.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// New builds the notifier selected by NOTIFY_DRIVER.
+func New(cfg *config.Config) (Notifier, error) {
+	switch cfg.NotifyDriver {
+	case "", "log":
+		return NewLog(), nil
+	case "email":
+		return NewEmail(EmailOptions{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUser,
+			Password: cfg.SMTPPass,
+			From:     cfg.SMTPFrom,
+		})
+	case "webhook":
+		return NewWebhook(cfg.NotifyWebhookURL, cfg.NotifyWebhookSecret)
+	default:
+		return nil, fmt.Errorf("unknown notify driver %q", cfg.NotifyDriver)
+	}
+}
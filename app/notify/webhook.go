@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Webhook POSTs each message as JSON. When a secret is configured the body
+// is signed with HMAC-SHA256 in the X-Signature header, hex encoded, so the
+// receiver can check it came from us.
+type Webhook struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewWebhook(url, secret string) (*Webhook, error) {
+	if url == "" {
+		return nil, errors.New("webhook notifier needs NOTIFY_WEBHOOK_URL")
+	}
+	return &Webhook{url: url, secret: secret, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (w *Webhook) Notify(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -2,20 +2,27 @@ package routes
 
 import (
 	"bookstore-api/app/config"
+	"bookstore-api/app/flashsale"
 	"bookstore-api/app/handlers"
 	"bookstore-api/app/middleware"
+	"bookstore-api/app/notify"
+	"bookstore-api/app/storage"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func RegisterRoutes(r *gin.Engine, db *gorm.DB, cfg *config.Config) {
+func RegisterRoutes(r *gin.Engine, db *gorm.DB, cfg *config.Config, store storage.Storage, notifier notify.Notifier, counter flashsale.Counter) {
 	r.GET("/", func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"message": "Bookstore API V1.0",
 		})
 	})
 
+	r.GET("/covers/*path", handlers.ServeCover(store))
+
+	r.GET("/downloads/:id", handlers.DownloadEbook(db, cfg, store))
+
 	r.POST("/register", handlers.Register(db))
 	r.POST("/login", handlers.Login(db, cfg))
 
@@ -24,28 +31,128 @@ func RegisterRoutes(r *gin.Engine, db *gorm.DB, cfg *config.Config) {
 	{
 		cat := auth.Group("/categories")
 		cat.GET("", handlers.ListCategories(db))
-		cat.GET("/:id", func(c *gin.Context) {})
+		cat.GET("/tree", handlers.CategoryTree(db))
+		cat.GET("/:id", handlers.GetCategory(db))
 		cat.POST("", middleware.RequireRole("admin"), handlers.CreateCategory(db))
 		cat.PUT("/:id", middleware.RequireRole("admin"), handlers.UpdateCategory(db))
 		cat.DELETE("/:id", middleware.RequireRole("admin"), handlers.DeleteCategory(db))
 
+		authors := auth.Group("/authors")
+		authors.GET("", handlers.ListAuthors(db))
+		authors.GET("/:id", handlers.GetAuthor(db))
+		authors.GET("/:id/books", handlers.ListAuthorBooks(db))
+		authors.POST("", middleware.RequireRole("admin"), handlers.CreateAuthor(db))
+		authors.PUT("/:id", middleware.RequireRole("admin"), handlers.UpdateAuthor(db))
+		authors.DELETE("/:id", middleware.RequireRole("admin"), handlers.DeleteAuthor(db))
+
 		book := auth.Group("/books")
 		book.GET("", handlers.ListBooks(db))
+		book.GET("/suggest", handlers.SuggestBooks(db))
+		book.GET("/isbn/:isbn", handlers.GetBookByISBN(db))
 		book.GET("/:id", handlers.GetBook(db))
-		book.POST("", middleware.RequireRole("admin"), handlers.CreateBook(db))
-		book.PUT("/:id", middleware.RequireRole("admin"), handlers.UpdateBook(db))
+		book.POST("", middleware.RequireRole("admin"), handlers.CreateBook(db, store))
+		book.PUT("/:id", middleware.RequireRole("admin"), handlers.UpdateBook(db, cfg, store, notifier))
+		book.PUT("/:id/file", middleware.RequireRole("admin"), handlers.UploadEbookFile(db, store))
+		book.PUT("/:id/authors", middleware.RequireRole("admin"), handlers.SetBookAuthors(db))
 		book.DELETE("/:id", middleware.RequireRole("admin"), handlers.DeleteBook(db))
+		book.GET("/:id/stock-history", middleware.RequireRole("admin"), handlers.StockHistory(db))
+		book.POST("/:id/stock-movements", middleware.RequireRole("admin"), handlers.RecordStockMovement(db, cfg, notifier))
+		book.GET("/:id/purchase-orders", middleware.RequireRole("admin"), handlers.BookPurchaseOrders(db))
+		book.GET("/:id/price-history", middleware.RequireRole("admin"), handlers.BookPriceHistory(db))
+		book.GET("/:id/related", handlers.RelatedBooks(db))
+		book.GET("/:id/reviews", handlers.ListBookReviews(db))
+		book.POST("/:id/reviews", handlers.CreateReview(db))
+
+		reviews := auth.Group("/reviews")
+		reviews.PUT("/:id", handlers.UpdateReview(db))
+		reviews.PUT("/:id/moderation", middleware.RequireRole("admin"), handlers.ModerateReview(db))
+		reviews.DELETE("/:id", handlers.DeleteReview(db))
+
+		me := auth.Group("/me")
+		me.GET("/wishlist", handlers.ListWishlist(db))
+		me.POST("/wishlist", handlers.AddToWishlist(db))
+		me.DELETE("/wishlist/:book_id", handlers.RemoveFromWishlist(db))
+		me.GET("/recommendations", handlers.MyRecommendations(db))
+		me.GET("/reservations", handlers.ListMyReservations(db))
+		me.GET("/library", handlers.MyLibrary(db, cfg))
+		me.GET("/wallet", handlers.MyWallet(db))
+		me.POST("/wallet/redeem", handlers.RedeemGiftCard(db))
+
+		giftCards := auth.Group("/gift-cards")
+		giftCards.Use(middleware.RequireRole("admin"))
+		giftCards.GET("", handlers.ListGiftCards(db))
+		giftCards.POST("", handlers.IssueGiftCards(db))
+
+		bundles := auth.Group("/bundles")
+		bundles.GET("", handlers.ListBundles(db))
+		bundles.GET("/:id", handlers.GetBundle(db))
+		bundles.POST("", middleware.RequireRole("admin"), handlers.CreateBundle(db))
+		bundles.PUT("/:id", middleware.RequireRole("admin"), handlers.UpdateBundle(db))
+		bundles.DELETE("/:id", middleware.RequireRole("admin"), handlers.DeleteBundle(db))
+
+		flashSales := auth.Group("/flash-sales")
+		flashSales.GET("", handlers.ListFlashSales(db, counter))
+		flashSales.GET("/:id", handlers.GetFlashSale(db, counter))
+		flashSales.POST("", middleware.RequireRole("admin"), handlers.CreateFlashSale(db))
+		flashSales.POST("/:id/reserve", handlers.ReserveFlashSale(db, cfg, counter))
+		flashSales.POST("/reservations/:id/checkout", handlers.CheckoutReservation(db, cfg, notifier, counter))
+		flashSales.DELETE("/reservations/:id", handlers.ReleaseFlashSaleReservation(db, counter))
 
 		orders := auth.Group("/orders")
-		orders.POST("", handlers.CreateOrder(db))
-		orders.POST("/:id/pay", handlers.PayOrder(db))
+		orders.POST("", handlers.CreateOrder(db, cfg, notifier))
+		orders.POST("/:id/pay", handlers.PayOrder(db, cfg))
+		orders.POST("/:id/cancel", handlers.CancelOrder(db, cfg, notifier, counter))
+		orders.POST("/:id/refund", middleware.RequireRole("admin"), handlers.RefundOrder(db))
 		orders.GET("", handlers.ListOrders(db))
 		orders.GET("/:id", handlers.GetOrder(db))
+		orders.GET("/:id/invoice", handlers.GetOrderInvoice(db, cfg))
+
+		warehouses := auth.Group("/warehouses")
+		warehouses.GET("", handlers.ListWarehouses(db))
+		warehouses.POST("", middleware.RequireRole("admin"), handlers.CreateWarehouse(db))
+		warehouses.GET("/transfers", middleware.RequireRole("admin"), handlers.ListStockTransfers(db))
+		warehouses.POST("/transfers", middleware.RequireRole("admin"), handlers.TransferStock(db))
+		warehouses.PUT("/:id", middleware.RequireRole("admin"), handlers.UpdateWarehouse(db))
+		warehouses.DELETE("/:id", middleware.RequireRole("admin"), handlers.DeleteWarehouse(db))
+
+		inventory := auth.Group("/inventory")
+		inventory.Use(middleware.RequireRole("admin"))
+		inventory.GET("/low-stock", handlers.LowStockReport(db))
+
+		suppliers := auth.Group("/suppliers")
+		suppliers.Use(middleware.RequireRole("admin"))
+		suppliers.GET("", handlers.ListSuppliers(db))
+		suppliers.GET("/:id", handlers.GetSupplier(db))
+		suppliers.POST("", handlers.CreateSupplier(db))
+		suppliers.PUT("/:id", handlers.UpdateSupplier(db))
+		suppliers.DELETE("/:id", handlers.DeleteSupplier(db))
+
+		purchases := auth.Group("/purchase-orders")
+		purchases.Use(middleware.RequireRole("admin"))
+		purchases.GET("", handlers.ListPurchaseOrders(db))
+		purchases.GET("/:id", handlers.GetPurchaseOrder(db))
+		purchases.POST("", handlers.CreatePurchaseOrder(db))
+		purchases.PUT("/:id", handlers.UpdatePurchaseOrder(db))
+		purchases.POST("/:id/send", handlers.SendPurchaseOrder(db))
+		purchases.POST("/:id/receive", handlers.ReceivePurchaseOrder(db, cfg, notifier))
+		purchases.POST("/:id/cancel", handlers.CancelPurchaseOrder(db))
+
+		priceRules := auth.Group("/price-rules")
+		priceRules.Use(middleware.RequireRole("admin"))
+		priceRules.GET("", handlers.ListPriceRules(db))
+		priceRules.POST("", handlers.CreatePriceRule(db))
+		priceRules.DELETE("/:id", handlers.DeletePriceRule(db))
+
+		rates := auth.Group("/exchange-rates")
+		rates.GET("", handlers.ListExchangeRates(db))
+		rates.POST("", middleware.RequireRole("admin"), handlers.CreateExchangeRate(db))
+		rates.DELETE("/:id", middleware.RequireRole("admin"), handlers.DeleteExchangeRate(db))
 
 		reports := auth.Group("/reports")
 		reports.Use(middleware.RequireRole("admin"))
 		reports.GET("/sales", handlers.SalesReport(db))
 		reports.GET("/bestseller", handlers.BestsellerReport(db))
 		reports.GET("/prices", handlers.PriceStatsReport(db))
+		reports.GET("/bundles", handlers.BundleSalesReport(db))
 	}
 }
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+
+	"bookstore-api/app/models"
+
+	"gorm.io/gorm"
+)
+
+// Strategies for allocating order items to warehouses.
+const (
+	// AllocatePrimaryFirst takes each item from the warehouses in order of
+	// preference, splitting it only when the first runs out.
+	AllocatePrimaryFirst = "primary_first"
+	// AllocateFewestSplits ships from as few warehouses as it can, so an
+	// order goes out in as few parcels as possible.
+	AllocateFewestSplits = "fewest_splits"
+)
+
+// AllocationLine is one order item to be allocated.
+type AllocationLine struct {
+	BookID   uint
+	Quantity int
+}
+
+// Allocation assigns Quantity copies of order item Line to a warehouse.
+type Allocation struct {
+	Line        int
+	WarehouseID uint
+	Quantity    int
+}
+
+// ShortageError is returned when the warehouses together don't hold enough
+// copies of a book. It matches ErrInsufficientStock.
+type ShortageError struct {
+	BookID uint
+}
+
+func (e *ShortageError) Error() string {
+	return fmt.Sprintf("insufficient stock for book %d", e.BookID)
+}
+
+func (e *ShortageError) Unwrap() error { return ErrInsufficientStock }
+
+// StockLevels holds how many copies of each book every warehouse has, with
+// the warehouses in order of preference.
+type StockLevels struct {
+	Warehouses []uint
+	Quantity   map[uint]map[uint]int // warehouse id -> book id -> copies
+}
+
+// LoadStockLevels reads the stock of the given books in every warehouse.
+// Call it after locking the books so the levels can't change underneath.
+func LoadStockLevels(tx *gorm.DB, bookIDs []uint) (StockLevels, error) {
+	levels := StockLevels{Quantity: map[uint]map[uint]int{}}
+	var warehouses []models.Warehouse
+	if err := tx.Order("is_primary DESC, priority, id").Find(&warehouses).Error; err != nil {
+		return levels, err
+	}
+	for _, w := range warehouses {
+		levels.Warehouses = append(levels.Warehouses, w.ID)
+		levels.Quantity[w.ID] = map[uint]int{}
+	}
+	var stocks []models.WarehouseStock
+	if err := tx.Where("book_id IN ? AND quantity > 0", bookIDs).Find(&stocks).Error; err != nil {
+		return levels, err
+	}
+	for _, s := range stocks {
+		if q, ok := levels.Quantity[s.WarehouseID]; ok {
+			q[s.BookID] = s.Quantity
+		}
+	}
+	return levels, nil
+}
+
+// Allocate decides which warehouses the lines ship from, using strategy.
+// It doesn't change levels. Allocations come back ordered by line, then by
+// warehouse preference.
+func Allocate(strategy string, lines []AllocationLine, levels StockLevels) ([]Allocation, error) {
+	left := make(map[uint]map[uint]int, len(levels.Quantity))
+	for w, books := range levels.Quantity {
+		left[w] = make(map[uint]int, len(books))
+		for b, q := range books {
+			left[w][b] = q
+		}
+	}
+
+	need := map[uint]int{}
+	for _, l := range lines {
+		need[l.BookID] += l.Quantity
+	}
+	for _, l := range lines {
+		have := 0
+		for _, w := range levels.Warehouses {
+			have += left[w][l.BookID]
+		}
+		if have < need[l.BookID] {
+			return nil, &ShortageError{BookID: l.BookID}
+		}
+	}
+
+	var out []Allocation
+	done := make([]bool, len(lines))
+	if strategy == AllocateFewestSplits {
+		// keep picking the warehouse that can ship the most remaining lines
+		// whole, until none can ship any
+		for {
+			best, bestLines := uint(0), []int(nil)
+			for _, w := range levels.Warehouses {
+				if whole := wholeLines(lines, done, left[w]); len(whole) > len(bestLines) {
+					best, bestLines = w, whole
+				}
+			}
+			if len(bestLines) == 0 {
+				break
+			}
+			for _, i := range bestLines {
+				left[best][lines[i].BookID] -= lines[i].Quantity
+				out = append(out, Allocation{Line: i, WarehouseID: best, Quantity: lines[i].Quantity})
+				done[i] = true
+			}
+		}
+	}
+	// whatever is left is split across warehouses in order of preference
+	for i, l := range lines {
+		if done[i] {
+			continue
+		}
+		rest := l.Quantity
+		for _, w := range levels.Warehouses {
+			take := left[w][l.BookID]
+			if take > rest {
+				take = rest
+			}
+			if take == 0 {
+				continue
+			}
+			left[w][l.BookID] -= take
+			out = append(out, Allocation{Line: i, WarehouseID: w, Quantity: take})
+			if rest -= take; rest == 0 {
+				break
+			}
+		}
+	}
+
+	rank := make(map[uint]int, len(levels.Warehouses))
+	for i, w := range levels.Warehouses {
+		rank[w] = i
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Line != out[j].Line {
+			return out[i].Line < out[j].Line
+		}
+		return rank[out[i].WarehouseID] < rank[out[j].WarehouseID]
+	})
+	return out, nil
+}
+
+// wholeLines returns the lines not yet done that stock can ship in full,
+// taking earlier lines for the same book into account.
+func wholeLines(lines []AllocationLine, done []bool, stock map[uint]int) []int {
+	used := map[uint]int{}
+	var whole []int
+	for i, l := range lines {
+		if done[i] || stock[l.BookID]-used[l.BookID] < l.Quantity {
+			continue
+		}
+		used[l.BookID] += l.Quantity
+		whole = append(whole, i)
+	}
+	return whole
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	// warehouse 1 is the primary, then 2, then 3
+	levels := func(stock map[uint]map[uint]int) StockLevels {
+		return StockLevels{Warehouses: []uint{1, 2, 3}, Quantity: stock}
+	}
+	tests := []struct {
+		name     string
+		strategy string
+		lines    []AllocationLine
+		levels   StockLevels
+		want     []Allocation
+	}{
+		{
+			name:     "primary first splits when the primary runs out",
+			strategy: AllocatePrimaryFirst,
+			lines:    []AllocationLine{{BookID: 10, Quantity: 4}},
+			levels:   levels(map[uint]map[uint]int{1: {10: 2}, 2: {10: 5}, 3: {}}),
+			want:     []Allocation{{Line: 0, WarehouseID: 1, Quantity: 2}, {Line: 0, WarehouseID: 2, Quantity: 2}},
+		},
+		{
+			name:     "fewest splits ships the line whole",
+			strategy: AllocateFewestSplits,
+			lines:    []AllocationLine{{BookID: 10, Quantity: 4}},
+			levels:   levels(map[uint]map[uint]int{1: {10: 2}, 2: {10: 5}, 3: {}}),
+			want:     []Allocation{{Line: 0, WarehouseID: 2, Quantity: 4}},
+		},
+		{
+			name:     "primary first takes each book from the first warehouse holding it",
+			strategy: AllocatePrimaryFirst,
+			lines:    []AllocationLine{{BookID: 10, Quantity: 2}, {BookID: 20, Quantity: 2}},
+			levels:   levels(map[uint]map[uint]int{1: {10: 5}, 2: {10: 5, 20: 5}, 3: {}}),
+			want:     []Allocation{{Line: 0, WarehouseID: 1, Quantity: 2}, {Line: 1, WarehouseID: 2, Quantity: 2}},
+		},
+		{
+			name:     "fewest splits ships the whole order from one warehouse",
+			strategy: AllocateFewestSplits,
+			lines:    []AllocationLine{{BookID: 10, Quantity: 2}, {BookID: 20, Quantity: 2}},
+			levels:   levels(map[uint]map[uint]int{1: {10: 5}, 2: {10: 5, 20: 5}, 3: {}}),
+			want:     []Allocation{{Line: 0, WarehouseID: 2, Quantity: 2}, {Line: 1, WarehouseID: 2, Quantity: 2}},
+		},
+		{
+			name:     "fewest splits falls back to splitting",
+			strategy: AllocateFewestSplits,
+			lines:    []AllocationLine{{BookID: 10, Quantity: 6}},
+			levels:   levels(map[uint]map[uint]int{1: {10: 2}, 2: {10: 3}, 3: {10: 1}}),
+			want: []Allocation{
+				{Line: 0, WarehouseID: 1, Quantity: 2},
+				{Line: 0, WarehouseID: 2, Quantity: 3},
+				{Line: 0, WarehouseID: 3, Quantity: 1},
+			},
+		},
+		{
+			name:     "primary first with duplicate book lines",
+			strategy: AllocatePrimaryFirst,
+			lines:    []AllocationLine{{BookID: 10, Quantity: 2}, {BookID: 10, Quantity: 2}},
+			levels:   levels(map[uint]map[uint]int{1: {10: 3}, 2: {10: 3}, 3: {}}),
+			want: []Allocation{
+				{Line: 0, WarehouseID: 1, Quantity: 2},
+				{Line: 1, WarehouseID: 1, Quantity: 1},
+				{Line: 1, WarehouseID: 2, Quantity: 1},
+			},
+		},
+		{
+			name:     "fewest splits with duplicate book lines",
+			strategy: AllocateFewestSplits,
+			lines:    []AllocationLine{{BookID: 10, Quantity: 2}, {BookID: 10, Quantity: 2}},
+			levels:   levels(map[uint]map[uint]int{1: {10: 3}, 2: {10: 3}, 3: {}}),
+			want:     []Allocation{{Line: 0, WarehouseID: 1, Quantity: 2}, {Line: 1, WarehouseID: 2, Quantity: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := tt.levels.Quantity[1][10]
+			got, err := Allocate(tt.strategy, tt.lines, tt.levels)
+			if err != nil {
+				t.Fatalf("Allocate: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Allocate = %+v, want %+v", got, tt.want)
+			}
+			if tt.levels.Quantity[1][10] != before {
+				t.Errorf("Allocate changed the stock levels")
+			}
+		})
+	}
+}
+
+func TestAllocateShortage(t *testing.T) {
+	levels := StockLevels{
+		Warehouses: []uint{1, 2},
+		Quantity:   map[uint]map[uint]int{1: {10: 3, 20: 1}, 2: {10: 1}},
+	}
+	tests := []struct {
+		name  string
+		lines []AllocationLine
+		book  uint
+	}{
+		{"more than all warehouses hold", []AllocationLine{{BookID: 10, Quantity: 5}}, 10},
+		{"duplicate lines that only fit one at a time", []AllocationLine{{BookID: 10, Quantity: 3}, {BookID: 10, Quantity: 2}}, 10},
+		{"book no warehouse holds", []AllocationLine{{BookID: 10, Quantity: 1}, {BookID: 30, Quantity: 1}}, 30},
+	}
+	for _, strategy := range []string{AllocatePrimaryFirst, AllocateFewestSplits} {
+		for _, tt := range tests {
+			t.Run(strategy+"/"+tt.name, func(t *testing.T) {
+				got, err := Allocate(strategy, tt.lines, levels)
+				var shortage *ShortageError
+				if !errors.As(err, &shortage) {
+					t.Fatalf("Allocate = %+v, %v, want a *ShortageError", got, err)
+				}
+				if shortage.BookID != tt.book {
+					t.Errorf("shortage of book %d, want %d", shortage.BookID, tt.book)
+				}
+				if !errors.Is(err, ErrInsufficientStock) {
+					t.Errorf("%v does not match ErrInsufficientStock", err)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"bookstore-api/app/models"
+	"bookstore-api/app/utils"
+
+	"gorm.io/gorm"
+)
+
+type AuthorCredit struct {
+	AuthorID uint
+	Role     string
+}
+
+var authorSeparators = regexp.MustCompile(`\s*(?:;|&|\band\b|\bdan\b)\s*`)
+
+// SplitAuthorNames turns a free-text byline into individual names.
+// "Hirata, Andrea" is read as one inverted name, while "Andrea Hirata, Tere
+// Liye" and "A & B" list several authors.
+func SplitAuthorNames(byline string) []string {
+	var names []string
+	for _, part := range authorSeparators.Split(byline, -1) {
+		pieces := strings.Split(part, ",")
+		if len(pieces) == 2 && len(strings.Fields(pieces[0])) == 1 && len(strings.Fields(pieces[1])) == 1 {
+			pieces = []string{strings.TrimSpace(pieces[1]) + " " + strings.TrimSpace(pieces[0])}
+		}
+		for _, p := range pieces {
+			if name := strings.Join(strings.Fields(p), " "); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// FindOrCreateAuthor returns the author whose slug matches name, creating
+// it when there is none, so spelling variants collapse into one row.
+func FindOrCreateAuthor(tx *gorm.DB, name string) (models.Author, error) {
+	author := models.Author{Name: name, Slug: utils.Slugify(name)}
+	if author.Slug == "" {
+		return author, fmt.Errorf("invalid author name %q", name)
+	}
+	if err := tx.Unscoped().Where(models.Author{Slug: author.Slug}).FirstOrCreate(&author).Error; err != nil {
+		return author, err
+	}
+	// a deleted author with the same slug is the same person coming back
+	if author.DeletedAt != nil && author.DeletedAt.Valid {
+		if err := tx.Unscoped().Model(&author).Update("deleted_at", nil).Error; err != nil {
+			return author, err
+		}
+		author.DeletedAt = nil
+	}
+	return author, nil
+}
+
+// UniqueAuthorSlug builds a slug for a new author, suffixing a number when
+// another author already uses it.
+func UniqueAuthorSlug(tx *gorm.DB, name string, exceptID uint) string {
+	base := utils.Slugify(name)
+	slug := base
+	for i := 2; ; i++ {
+		var count int64
+		tx.Unscoped().Model(&models.Author{}).Where("slug = ? AND id <> ?", slug, exceptID).Count(&count)
+		if count == 0 {
+			return slug
+		}
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// LinkAuthorNames credits the authors named in byline on the book, creating
+// missing authors, and replaces the book's existing credits.
+func LinkAuthorNames(tx *gorm.DB, bookID uint, byline string) error {
+	var credits []AuthorCredit
+	for _, name := range SplitAuthorNames(byline) {
+		author, err := FindOrCreateAuthor(tx, name)
+		if err != nil {
+			return err
+		}
+		credits = append(credits, AuthorCredit{AuthorID: author.ID, Role: models.RoleAuthor})
+	}
+	return SetBookAuthors(tx, bookID, credits)
+}
+
+// SetBookAuthors replaces the book's credits, keeping their order, and
+// rewrites its byline from them.
+func SetBookAuthors(tx *gorm.DB, bookID uint, credits []AuthorCredit) error {
+	if err := tx.Where("book_id = ?", bookID).Delete(&models.BookAuthor{}).Error; err != nil {
+		return err
+	}
+	for i, cr := range credits {
+		link := models.BookAuthor{BookID: bookID, AuthorID: cr.AuthorID, Role: cr.Role, Position: i}
+		if err := tx.Create(&link).Error; err != nil {
+			return err
+		}
+	}
+	return RefreshByline(tx, bookID)
+}
+
+// RefreshByline rebuilds Book.Author from the credited authors, falling
+// back to every contributor when nobody is credited as author.
+func RefreshByline(tx *gorm.DB, bookID uint) error {
+	var links []models.BookAuthor
+	if err := tx.Preload("Author").Where("book_id = ?", bookID).Order("position").Find(&links).Error; err != nil {
+		return err
+	}
+	var authors, others []string
+	for _, l := range links {
+		if l.Role == models.RoleAuthor {
+			authors = append(authors, l.Author.Name)
+		} else {
+			others = append(others, l.Author.Name)
+		}
+	}
+	if len(authors) == 0 {
+		authors = others
+	}
+	if len(authors) == 0 {
+		return nil
+	}
+	byline := []rune(strings.Join(authors, ", "))
+	if len(byline) > 255 {
+		byline = append(byline[:252], []rune("...")...)
+	}
+	return tx.Model(&models.Book{}).Where("id = ?", bookID).Update("author", string(byline)).Error
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"bookstore-api/app/models"
+	"bookstore-api/app/notify"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OnPreorder reports whether the book is not released yet at now.
+func OnPreorder(book models.Book, now time.Time) bool {
+	return book.ReleaseDate != nil && book.ReleaseDate.After(now)
+}
+
+// BackorderedQuantity is how many copies of the book wait on backorder.
+func BackorderedQuantity(tx *gorm.DB, bookID uint) (int, error) {
+	var n int
+	err := tx.Model(&models.OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("order_items.book_id = ? AND order_items.backordered_quantity > 0 AND orders.status = ?", bookID, models.OrderBackordered).
+		Select("COALESCE(SUM(order_items.backordered_quantity), 0)").Scan(&n).Error
+	return n, err
+}
+
+// waitingItems returns the backordered items of a book, first come first.
+// Orders locked by someone else, e.g. being cancelled, are skipped.
+func waitingItems(tx *gorm.DB, bookID uint) ([]models.OrderItem, error) {
+	var items []models.OrderItem
+	err := tx.Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("order_items.book_id = ? AND order_items.backordered_quantity > 0 AND orders.status = ?", bookID, models.OrderBackordered).
+		Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "orders"}, Options: "SKIP LOCKED"}).
+		Order("orders.created_at, orders.id, order_items.id").
+		Find(&items).Error
+	return items, err
+}
+
+// FulfilBackorders allocates a book's stock to its backordered items,
+// first come first served, once the book is released. Orders with nothing
+// left waiting become PENDING, or PAID at taxRate if store credit covers
+// them in full, and their ids are returned, for NotifyOrdersReadyAsync once
+// tx has committed. The expected ship dates of the items still waiting are
+// refreshed.
+func FulfilBackorders(tx *gorm.DB, bookID uint, taxRate float64) ([]uint, error) {
+	var book models.Book
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "stock", "release_date").First(&book, bookID).Error; err != nil {
+		return nil, err
+	}
+	if OnPreorder(book, time.Now()) || book.Stock == 0 {
+		return nil, RefreshExpectedShipDates(tx, bookID)
+	}
+	items, err := waitingItems(tx, bookID)
+	if err != nil {
+		return nil, err
+	}
+	levels, err := LoadStockLevels(tx, []uint{bookID})
+	if err != nil {
+		return nil, err
+	}
+
+	var ready []uint
+	for _, it := range items {
+		available := 0
+		for _, w := range levels.Warehouses {
+			available += levels.Quantity[w][bookID]
+		}
+		if available == 0 {
+			break
+		}
+		take := it.BackorderedQuantity
+		if take > available {
+			take = available
+		}
+		allocations, err := Allocate(AllocatePrimaryFirst, []AllocationLine{{BookID: bookID, Quantity: take}}, levels)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range allocations {
+			orderID := it.OrderID
+			_, _, err := ApplyStockChange(tx, StockChange{
+				BookID: bookID, WarehouseID: a.WarehouseID, Delta: -a.Quantity, Type: models.MovementSale,
+				OrderID: &orderID, Reason: "backorder allocated",
+			})
+			if err == nil {
+				err = tx.Create(&models.OrderItemAllocation{OrderItemID: it.ID, WarehouseID: a.WarehouseID, Quantity: a.Quantity}).Error
+			}
+			if err != nil {
+				return nil, err
+			}
+			levels.Quantity[a.WarehouseID][bookID] -= a.Quantity
+		}
+
+		it.BackorderedQuantity -= take
+		updates := map[string]interface{}{"backordered_quantity": it.BackorderedQuantity}
+		if it.BackorderedQuantity == 0 {
+			updates["expected_ship_at"] = nil
+		}
+		if err := tx.Model(&models.OrderItem{}).Where("id = ?", it.ID).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+		if it.BackorderedQuantity > 0 {
+			continue
+		}
+		res := tx.Model(&models.Order{}).
+			Where("id = ? AND NOT EXISTS (SELECT 1 FROM order_items WHERE order_id = orders.id AND backordered_quantity > 0)", it.OrderID).
+			Updates(map[string]interface{}{"status": models.OrderPending, "expected_ship_at": nil})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		var order models.Order
+		if err := tx.Select("id", "total_price", "store_credit").First(&order, it.OrderID).Error; err != nil {
+			return nil, err
+		}
+		if order.StoreCredit == order.TotalPrice {
+			// paid in full with store credit when it was placed
+			if err := MarkOrderPaid(tx, &order, taxRate); err != nil {
+				return nil, err
+			}
+		}
+		ready = append(ready, it.OrderID)
+	}
+	return ready, RefreshExpectedShipDates(tx, bookID)
+}
+
+// RefreshExpectedShipDates estimates when each backordered item of a book
+// ships. Pre-orders ship on release. Otherwise items are matched, first
+// come first served, against what sent purchase orders still have to
+// deliver, soonest expected first; an item beyond that has no date.
+func RefreshExpectedShipDates(tx *gorm.DB, bookID uint) error {
+	var book models.Book
+	if err := tx.Select("id", "release_date").First(&book, bookID).Error; err != nil {
+		return err
+	}
+	items, err := waitingItems(tx, bookID)
+	if err != nil || len(items) == 0 {
+		return err
+	}
+	var supply []struct {
+		ExpectedAt  *time.Time
+		Outstanding int
+	}
+	err = tx.Raw(`
+		SELECT po.expected_at, SUM(i.quantity_ordered - i.quantity_received) AS outstanding
+		FROM purchase_order_items i JOIN purchase_orders po ON po.id = i.purchase_order_id
+		WHERE i.book_id = ? AND po.status IN ? AND i.quantity_received < i.quantity_ordered
+		GROUP BY po.id, po.expected_at
+		ORDER BY po.expected_at ASC NULLS LAST, po.id`,
+		bookID, []string{models.PurchaseSent, models.PurchasePartiallyReceived}).Scan(&supply).Error
+	if err != nil {
+		return err
+	}
+
+	orderIDs := make([]uint, 0, len(items))
+	queued, supplied, next := 0, 0, 0
+	for _, it := range items {
+		var at *time.Time
+		if OnPreorder(book, time.Now()) {
+			at = book.ReleaseDate
+		} else {
+			queued += it.BackorderedQuantity
+			for next < len(supply) && supplied < queued {
+				supplied += supply[next].Outstanding
+				next++
+			}
+			if supplied >= queued && next > 0 {
+				at = supply[next-1].ExpectedAt
+			}
+		}
+		if err := tx.Model(&models.OrderItem{}).Where("id = ?", it.ID).Update("expected_ship_at", at).Error; err != nil {
+			return err
+		}
+		orderIDs = append(orderIDs, it.OrderID)
+	}
+	// an order ships when its last item does, and that is unknown if any is
+	return tx.Exec(`
+		UPDATE orders SET expected_ship_at = s.at
+		FROM (
+			SELECT order_id, CASE WHEN COUNT(*) = COUNT(expected_ship_at) THEN MAX(expected_ship_at) END AS at
+			FROM order_items WHERE order_id IN ? AND backordered_quantity > 0
+			GROUP BY order_id
+		) s
+		WHERE orders.id = s.order_id`, orderIDs).Error
+}
+
+// BooksWithBackorders returns the books that have items waiting for stock.
+func BooksWithBackorders(db *gorm.DB) ([]uint, error) {
+	var ids []uint
+	err := db.Model(&models.OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("order_items.backordered_quantity > 0 AND orders.status = ?", models.OrderBackordered).
+		Distinct().Pluck("order_items.book_id", &ids).Error
+	return ids, err
+}
+
+// FulfilAllBackorders runs FulfilBackorders for every book with items
+// waiting, each in its own transaction, and notifies the customers whose
+// orders became ready. It picks up pre-orders once their book is released.
+func FulfilAllBackorders(ctx context.Context, db *gorm.DB, n notify.Notifier, taxRate float64) error {
+	bookIDs, err := BooksWithBackorders(db)
+	if err != nil {
+		return err
+	}
+	for _, id := range bookIDs {
+		var ready []uint
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			ready, err = FulfilBackorders(tx, id, taxRate)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("book %d: %w", id, err)
+		}
+		if err := NotifyOrdersReady(ctx, db, n, ready); err != nil {
+			log.Printf("notify ready orders %v: %v", ready, err)
+		}
+	}
+	return nil
+}
+
+// NotifyOrdersReady tells the customers that their backordered orders are
+// complete and can be paid, or have been paid with store credit.
+func NotifyOrdersReady(ctx context.Context, db *gorm.DB, n notify.Notifier, orderIDs []uint) error {
+	if len(orderIDs) == 0 {
+		return nil
+	}
+	var orders []models.Order
+	if err := db.Preload("User").Where("id IN ?", orderIDs).Find(&orders).Error; err != nil {
+		return err
+	}
+	for _, o := range orders {
+		next := "Complete the payment and we'll ship it."
+		if o.Status == models.OrderPaid {
+			next = "It is paid with your store credit and we'll ship it."
+		}
+		err := n.Notify(ctx, notify.Message{
+			Event:   "order.ready",
+			To:      o.User.Email,
+			Subject: fmt.Sprintf("Order #%d is ready", o.ID),
+			Body: fmt.Sprintf("Hi %s,\n\nEverything on your order #%d is now in stock and reserved for you. %s\n",
+				o.User.Name, o.ID, next),
+			Data: map[string]interface{}{"order_id": o.ID, "user_id": o.UserID},
+		})
+		if err != nil {
+			log.Printf("order ready notification for order %d: %v", o.ID, err)
+		}
+	}
+	return nil
+}
+
+// NotifyOrdersReadyAsync runs NotifyOrdersReady in the background.
+func NotifyOrdersReadyAsync(db *gorm.DB, n notify.Notifier, orderIDs []uint) {
+	if len(orderIDs) == 0 {
+		return
+	}
+	go func() {
+		if err := NotifyOrdersReady(context.Background(), db, n, orderIDs); err != nil {
+			log.Printf("notify ready orders %v: %v", orderIDs, err)
+		}
+	}()
+}
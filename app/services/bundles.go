@@ -0,0 +1,61 @@
+package services
+
+import (
+	"fmt"
+
+	"bookstore-api/app/models"
+
+	"gorm.io/gorm"
+)
+
+// BundleAvailableSQL is how many complete bundles the stock of their books
+// makes up. A bundle with a deleted book is not available at all; ebooks
+// take no stock, so a bundle of only ebooks has no limit and gives NULL.
+const BundleAvailableSQL = `(SELECT MIN(CASE WHEN books.id IS NULL THEN 0 WHEN books.format = 'ebook' THEN NULL ELSE GREATEST(books.stock, 0) / bi.quantity END)
+	FROM bundle_items bi LEFT JOIN books ON books.id = bi.book_id AND books.deleted_at IS NULL
+	WHERE bi.bundle_id = bundles.id)`
+
+// expandBundles turns the order lines into item lines, one per book of
+// every bundle ordered, and returns the bundles by id.
+func expandBundles(tx *gorm.DB, lines []OrderLine) ([]itemLine, map[uint]models.Bundle, error) {
+	var ids []uint
+	for _, l := range lines {
+		if l.BundleID != 0 {
+			ids = append(ids, l.BundleID)
+		}
+	}
+	bundles := map[uint]models.Bundle{}
+	if len(ids) > 0 {
+		var found []models.Bundle
+		err := tx.Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("book_id") }).
+			Where("id IN ?", ids).Find(&found).Error
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, b := range found {
+			bundles[b.ID] = b
+		}
+	}
+
+	items := make([]itemLine, 0, len(lines))
+	seen := map[uint]bool{}
+	for _, l := range lines {
+		if l.BundleID == 0 {
+			items = append(items, itemLine{BookID: l.BookID, Quantity: l.Quantity, FixedPrice: l.FixedPrice, FlashSaleID: l.FlashSaleID})
+			continue
+		}
+		b, ok := bundles[l.BundleID]
+		if !ok || len(b.Items) == 0 {
+			return nil, nil, &OrderError{Message: "bundle not found"}
+		}
+		if seen[b.ID] {
+			return nil, nil, &OrderError{Message: fmt.Sprintf("bundle %d is listed more than once", b.ID)}
+		}
+		seen[b.ID] = true
+		for _, bi := range b.Items {
+			id := b.ID
+			items = append(items, itemLine{BookID: bi.BookID, Quantity: bi.Quantity * l.Quantity, BundleID: &id, BundleQuantity: l.Quantity})
+		}
+	}
+	return items, bundles, nil
+}
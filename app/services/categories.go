@@ -0,0 +1,87 @@
+package services
+
+import (
+	"fmt"
+
+	"bookstore-api/app/models"
+	"bookstore-api/app/utils"
+
+	"gorm.io/gorm"
+)
+
+// CategoryDescendantIDs returns the given categories together with every
+// category below them.
+func CategoryDescendantIDs(db *gorm.DB, rootIDs []uint) ([]uint, error) {
+	var ids []uint
+	if len(rootIDs) == 0 {
+		return ids, nil
+	}
+	err := db.Raw(`
+		WITH RECURSIVE tree AS (
+			SELECT id FROM categories WHERE id IN ?
+			UNION
+			SELECT c.id FROM categories c JOIN tree t ON c.parent_id = t.id
+		)
+		SELECT id FROM tree
+	`, rootIDs).Scan(&ids).Error
+	return ids, err
+}
+
+// CategoryAncestorIDs returns the category together with every category
+// above it.
+func CategoryAncestorIDs(db *gorm.DB, id uint) ([]uint, error) {
+	var ids []uint
+	err := db.Raw(`
+		WITH RECURSIVE up AS (
+			SELECT id, parent_id FROM categories WHERE id = ?
+			UNION
+			SELECT c.id, c.parent_id FROM categories c JOIN up ON c.id = up.parent_id
+		)
+		SELECT id FROM up
+	`, id).Scan(&ids).Error
+	return ids, err
+}
+
+// CategoryCreatesCycle reports whether moving category id under parentID
+// would make it its own ancestor.
+func CategoryCreatesCycle(db *gorm.DB, id, parentID uint) (bool, error) {
+	descendants, err := CategoryDescendantIDs(db, []uint{id})
+	if err != nil {
+		return false, err
+	}
+	for _, d := range descendants {
+		if d == parentID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CategoryBookCounts counts the books filed directly under each category.
+func CategoryBookCounts(db *gorm.DB) (map[uint]int64, error) {
+	var rows []struct {
+		CategoryID uint
+		Count      int64
+	}
+	err := db.Model(&models.Book{}).Select("category_id, COUNT(*) AS count").Group("category_id").Scan(&rows).Error
+	counts := make(map[uint]int64, len(rows))
+	for _, r := range rows {
+		counts[r.CategoryID] = r.Count
+	}
+	return counts, err
+}
+
+// UniqueCategorySlug slugifies s, suffixing a number when another category
+// already uses the slug.
+func UniqueCategorySlug(db *gorm.DB, s string, exceptID uint) string {
+	base := utils.Slugify(s)
+	slug := base
+	for i := 2; ; i++ {
+		var count int64
+		db.Model(&models.Category{}).Where("slug = ? AND id <> ?", slug, exceptID).Count(&count)
+		if count == 0 {
+			return slug
+		}
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+}
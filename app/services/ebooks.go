@@ -0,0 +1,132 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"bookstore-api/app/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrDownloadLink      = errors.New("download link is invalid or has expired")
+	ErrDownloadLimit     = errors.New("download limit reached for this order")
+	ErrEbookNotAvailable = errors.New("ebook is not available for download")
+)
+
+// Download is an ebook file about to be sent: the book it belongs to, the
+// order item that bought it and the user who ordered it.
+type Download struct {
+	Item   models.OrderItem
+	Book   models.Book
+	UserID uint
+}
+
+// DownloadURL returns a link to download the ebook bought with the order
+// item, signed with secret and valid for ttl.
+func DownloadURL(secret string, itemID uint, ttl time.Duration) (string, time.Time) {
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("signature", downloadSignature(secret, itemID, expires.Unix()))
+	return fmt.Sprintf("/downloads/%d?%s", itemID, q.Encode()), expires
+}
+
+// VerifyDownloadURL checks the expires and signature a download link
+// carries for the order item.
+func VerifyDownloadURL(secret string, itemID uint, expires, signature string) error {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !time.Now().Before(time.Unix(unix, 0)) {
+		return ErrDownloadLink
+	}
+	want := downloadSignature(secret, itemID, unix)
+	if !hmac.Equal([]byte(signature), []byte(want)) {
+		return ErrDownloadLink
+	}
+	return nil
+}
+
+func downloadSignature(secret string, itemID uint, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d:%d", itemID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// FindDownload returns the ebook bought with the order item, if the order
+// is paid and the ebook is out and has a file.
+func FindDownload(db *gorm.DB, itemID uint) (*Download, error) {
+	var item models.OrderItem
+	if err := db.Where("id = ? AND digital", itemID).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrEbookNotAvailable
+		}
+		return nil, err
+	}
+	var order models.Order
+	if err := db.Select("id", "user_id", "status").First(&order, item.OrderID).Error; err != nil {
+		return nil, err
+	}
+	if order.Status != models.OrderPaid {
+		return nil, ErrEbookNotAvailable
+	}
+	// a book taken off sale stays downloadable for those who bought it
+	var book models.Book
+	if err := db.Unscoped().First(&book, item.BookID).Error; err != nil {
+		return nil, err
+	}
+	if book.FileKey == "" || OnPreorder(book, time.Now()) {
+		return nil, ErrEbookNotAvailable
+	}
+	return &Download{Item: item, Book: book, UserID: order.UserID}, nil
+}
+
+// RecordDownload logs a download of the order item, refusing it once the
+// item has been downloaded limit times. The item is locked so concurrent
+// downloads can't both take the last one.
+func RecordDownload(tx *gorm.DB, d *Download, limit int, ip, userAgent string) error {
+	item := d.Item
+	var locked models.OrderItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&locked, item.ID).Error; err != nil {
+		return err
+	}
+	var count int64
+	if err := tx.Model(&models.EbookDownload{}).Where("order_item_id = ?", item.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count >= int64(limit) {
+		return ErrDownloadLimit
+	}
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	return tx.Create(&models.EbookDownload{
+		OrderItemID: item.ID, OrderID: item.OrderID, BookID: item.BookID, UserID: d.UserID, IP: ip, UserAgent: userAgent,
+	}).Error
+}
+
+// DownloadCounts returns how many times each of the order items has been
+// downloaded.
+func DownloadCounts(db *gorm.DB, itemIDs []uint) (map[uint]int, error) {
+	var rows []struct {
+		OrderItemID uint
+		Count       int
+	}
+	counts := make(map[uint]int, len(itemIDs))
+	if len(itemIDs) == 0 {
+		return counts, nil
+	}
+	err := db.Model(&models.EbookDownload{}).Select("order_item_id, COUNT(*) AS count").
+		Where("order_item_id IN ?", itemIDs).Group("order_item_id").Scan(&rows).Error
+	for _, r := range rows {
+		counts[r.OrderItemID] = r.Count
+	}
+	return counts, err
+}
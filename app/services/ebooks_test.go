@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadURL(t *testing.T) {
+	link, expires := DownloadURL("secret", 42, time.Minute)
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("parse %q: %v", link, err)
+	}
+	if u.Path != "/downloads/42" {
+		t.Errorf("path = %q, want /downloads/42", u.Path)
+	}
+	q := u.Query()
+	if got := q.Get("expires"); got != strconv.FormatInt(expires.Unix(), 10) {
+		t.Errorf("expires = %q, want %d", got, expires.Unix())
+	}
+	if err := VerifyDownloadURL("secret", 42, q.Get("expires"), q.Get("signature")); err != nil {
+		t.Errorf("VerifyDownloadURL of a fresh link: %v", err)
+	}
+}
+
+func TestVerifyDownloadURLRejects(t *testing.T) {
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Second).Unix()
+	valid := downloadSignature("secret", 42, future)
+	tampered := []byte(valid)
+	if tampered[0] == 'a' {
+		tampered[0] = 'b'
+	} else {
+		tampered[0] = 'a'
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		itemID    uint
+		expires   string
+		signature string
+	}{
+		{"expired", "secret", 42, strconv.FormatInt(past, 10), downloadSignature("secret", 42, past)},
+		{"expiry pushed back", "secret", 42, strconv.FormatInt(future+3600, 10), valid},
+		{"expires not a number", "secret", 42, "tomorrow", valid},
+		{"tampered signature", "secret", 42, strconv.FormatInt(future, 10), string(tampered)},
+		{"truncated signature", "secret", 42, strconv.FormatInt(future, 10), valid[:32]},
+		{"upper case signature", "secret", 42, strconv.FormatInt(future, 10), strings.ToUpper(valid)},
+		{"missing signature", "secret", 42, strconv.FormatInt(future, 10), ""},
+		{"wrong item", "secret", 43, strconv.FormatInt(future, 10), valid},
+		{"other secret", "other", 42, strconv.FormatInt(future, 10), valid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyDownloadURL(tt.secret, tt.itemID, tt.expires, tt.signature)
+			if !errors.Is(err, ErrDownloadLink) {
+				t.Errorf("VerifyDownloadURL = %v, want ErrDownloadLink", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"bookstore-api/app/flashsale"
+	"bookstore-api/app/models"
+	"bookstore-api/app/notify"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrFlashSaleNotRunning = errors.New("flash sale is not running")
+	ErrReservationNotHeld  = errors.New("reservation is no longer held")
+)
+
+// LoadFlashSale puts the sale into the counter unless it is there already,
+// working out what is left from the reservations still held or converted.
+func LoadFlashSale(ctx context.Context, db *gorm.DB, counter flashsale.Counter, sale models.FlashSale) error {
+	var rows []struct {
+		UserID   uint
+		Quantity int
+	}
+	err := db.Model(&models.FlashSaleReservation{}).Select("user_id, SUM(quantity) AS quantity").
+		Where("flash_sale_id = ? AND status IN ?", sale.ID, []string{models.ReservationHeld, models.ReservationConverted}).
+		Group("user_id").Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	left := sale.Quantity
+	held := make(map[uint]int, len(rows))
+	for _, r := range rows {
+		held[r.UserID] = r.Quantity
+		left -= r.Quantity
+	}
+	return counter.Load(ctx, sale.ID, left, held)
+}
+
+// FlashSaleLeft returns what the counter has left of the sale. An ended
+// sale has nothing left to reserve and is never loaded into the counter
+// again, as settling it dropped it from there.
+func FlashSaleLeft(ctx context.Context, db *gorm.DB, counter flashsale.Counter, sale models.FlashSale) (int, error) {
+	if sale.SettledAt != nil || !time.Now().Before(sale.EndsAt) {
+		return 0, nil
+	}
+	left, err := counter.Left(ctx, sale.ID)
+	if errors.Is(err, flashsale.ErrUnknownSale) {
+		if err = LoadFlashSale(ctx, db, counter, sale); err == nil {
+			left, err = counter.Left(ctx, sale.ID)
+		}
+	}
+	return left, err
+}
+
+// ReserveFlashSale holds n copies of the sale for the user for hold. Only
+// the counter decides who gets them; the reservation is written afterwards
+// and the copies are given back if that fails.
+func ReserveFlashSale(ctx context.Context, db *gorm.DB, counter flashsale.Counter, sale models.FlashSale, userID uint, n int, hold time.Duration) (*models.FlashSaleReservation, error) {
+	now := time.Now()
+	if now.Before(sale.StartsAt) || !now.Before(sale.EndsAt) {
+		return nil, ErrFlashSaleNotRunning
+	}
+	err := counter.Reserve(ctx, sale.ID, userID, n, sale.PerUserLimit)
+	if errors.Is(err, flashsale.ErrUnknownSale) {
+		if err = LoadFlashSale(ctx, db, counter, sale); err == nil {
+			err = counter.Reserve(ctx, sale.ID, userID, n, sale.PerUserLimit)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := models.FlashSaleReservation{
+		FlashSaleID: sale.ID, UserID: userID, Quantity: n, Status: models.ReservationHeld, ExpiresAt: now.Add(hold),
+	}
+	if err := db.Create(&r).Error; err != nil {
+		if err := counter.Release(context.Background(), sale.ID, userID, n); err != nil {
+			log.Printf("flash sale %d: giving back %d for user %d: %v", sale.ID, n, userID, err)
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+// HoldFlashSaleStock takes the copies of a new flash sale out of the
+// book's stock, inside tx, and holds them for the sale's reservations.
+// Copies owed to backorders can't be held. Ebooks hold nothing.
+func HoldFlashSaleStock(tx *gorm.DB, sale models.FlashSale) error {
+	var book models.Book
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "format").First(&book, sale.BookID).Error; err != nil {
+		return err
+	}
+	if book.Format == models.FormatEbook {
+		return nil
+	}
+	levels, err := LoadStockLevels(tx, []uint{book.ID})
+	if err != nil {
+		return err
+	}
+	promised, err := BackorderedQuantity(tx, book.ID)
+	if err != nil {
+		return err
+	}
+	have := -promised
+	for _, w := range levels.Warehouses {
+		have += levels.Quantity[w][book.ID]
+	}
+	if sale.Quantity > have {
+		return &ShortageError{BookID: book.ID}
+	}
+	allocations, err := Allocate(AllocatePrimaryFirst, []AllocationLine{{BookID: book.ID, Quantity: sale.Quantity}}, levels)
+	if err != nil {
+		return err
+	}
+	for _, a := range allocations {
+		_, _, err := ApplyStockChange(tx, StockChange{
+			BookID: book.ID, WarehouseID: a.WarehouseID, Delta: -a.Quantity, Type: models.MovementFlashSale,
+			UserID: &sale.UserID, Reason: fmt.Sprintf("held for flash sale %d", sale.ID),
+		})
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&models.FlashSaleStock{FlashSaleID: sale.ID, WarehouseID: a.WarehouseID, Quantity: a.Quantity}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drawFlashSaleStock takes up to n copies from what the sale holds, in
+// order of warehouse preference. The sale must be locked in tx.
+func drawFlashSaleStock(tx *gorm.DB, saleID uint, n int) ([]models.FlashSaleStock, error) {
+	var held []models.FlashSaleStock
+	err := tx.Joins("JOIN warehouses ON warehouses.id = flash_sale_stocks.warehouse_id").
+		Where("flash_sale_stocks.flash_sale_id = ? AND flash_sale_stocks.quantity > 0", saleID).
+		Order("warehouses.is_primary DESC, warehouses.priority, warehouses.id").Find(&held).Error
+	if err != nil {
+		return nil, err
+	}
+	var drawn []models.FlashSaleStock
+	for _, h := range held {
+		if n == 0 {
+			break
+		}
+		take := min(n, h.Quantity)
+		err := tx.Model(&models.FlashSaleStock{}).Where("flash_sale_id = ? AND warehouse_id = ?", saleID, h.WarehouseID).
+			Update("quantity", gorm.Expr("quantity - ?", take)).Error
+		if err != nil {
+			return nil, err
+		}
+		drawn = append(drawn, models.FlashSaleStock{FlashSaleID: saleID, WarehouseID: h.WarehouseID, Quantity: take})
+		n -= take
+	}
+	return drawn, nil
+}
+
+// lockFlashSale locks the sale in tx. Every change to what a sale holds
+// locks the sale first, so they can't deadlock over the book's rows.
+func lockFlashSale(tx *gorm.DB, id uint) (models.FlashSale, error) {
+	var sale models.FlashSale
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sale, id).Error
+	return sale, err
+}
+
+// ConvertReservation places the order for a held reservation inside tx, at
+// the flash sale price, and marks the reservation converted. The copies
+// come out of what the sale holds. in supplies everything about the order
+// but its lines.
+func ConvertReservation(tx *gorm.DB, id uint, in NewOrder) (*PlaceOrderResult, error) {
+	var r models.FlashSaleReservation
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&r, id).Error; err != nil {
+		return nil, err
+	}
+	if r.Status != models.ReservationHeld || !time.Now().Before(r.ExpiresAt) {
+		return nil, ErrReservationNotHeld
+	}
+	sale, err := lockFlashSale(tx, r.FlashSaleID)
+	if err != nil {
+		return nil, err
+	}
+	in.Lines = []OrderLine{{BookID: sale.BookID, Quantity: r.Quantity, FixedPrice: &sale.Price, FlashSaleID: sale.ID}}
+	placed, err := PlaceOrder(tx, in)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Model(&r).Updates(map[string]interface{}{"status": models.ReservationConverted, "order_id": placed.Order.ID}).Error
+	return placed, err
+}
+
+// ReleaseOrderReservation releases the converted reservation an order was
+// placed from, if any, as the order is cancelled inside tx. While the sale
+// still holds its unsold copies the order's copies go back to that hold
+// and rehold is true: the caller must not put them back in stock. Either
+// way the returned reservation's copies are to be given back to the
+// counter once tx has committed.
+func ReleaseOrderReservation(tx *gorm.DB, orderID uint, items []models.OrderItem) (r *models.FlashSaleReservation, rehold bool, err error) {
+	var found models.FlashSaleReservation
+	err = tx.Where("order_id = ? AND status = ?", orderID, models.ReservationConverted).First(&found).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	sale, err := lockFlashSale(tx, found.FlashSaleID)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := tx.Model(&found).Update("status", models.ReservationReleased).Error; err != nil {
+		return nil, false, err
+	}
+	if sale.SettledAt != nil {
+		return &found, false, nil
+	}
+	var holds int64
+	if err := tx.Model(&models.FlashSaleStock{}).Where("flash_sale_id = ?", sale.ID).Count(&holds).Error; err != nil {
+		return nil, false, err
+	}
+	if holds == 0 {
+		// created before copies were held
+		return &found, false, nil
+	}
+	for _, it := range items {
+		for _, a := range it.Allocations {
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "flash_sale_id"}, {Name: "warehouse_id"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{"quantity": gorm.Expr("flash_sale_stocks.quantity + ?", a.Quantity)}),
+			}).Create(&models.FlashSaleStock{FlashSaleID: sale.ID, WarehouseID: a.WarehouseID, Quantity: a.Quantity}).Error
+			if err != nil {
+				return nil, false, err
+			}
+		}
+	}
+	return &found, true, nil
+}
+
+// ReleaseReservation ends a held reservation as released or expired and
+// gives its copies back to the counter.
+func ReleaseReservation(ctx context.Context, db *gorm.DB, counter flashsale.Counter, r models.FlashSaleReservation, status string) error {
+	res := db.Model(&models.FlashSaleReservation{}).Where("id = ? AND status = ?", r.ID, models.ReservationHeld).Update("status", status)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrReservationNotHeld
+	}
+	return counter.Release(ctx, r.FlashSaleID, r.UserID, r.Quantity)
+}
+
+// ExpireReservations ends every held reservation past its expiry and gives
+// the copies back for someone else to reserve.
+func ExpireReservations(ctx context.Context, db *gorm.DB, counter flashsale.Counter) error {
+	var expired []models.FlashSaleReservation
+	err := db.Raw(`
+		UPDATE flash_sale_reservations SET status = ?, updated_at = now()
+		WHERE status = ? AND expires_at <= now()
+		RETURNING id, flash_sale_id, user_id, quantity`,
+		models.ReservationExpired, models.ReservationHeld).Scan(&expired).Error
+	if err != nil {
+		return err
+	}
+	// keep going past a failure, the reservations are already expired
+	var firstErr error
+	for _, r := range expired {
+		if err := counter.Release(ctx, r.FlashSaleID, r.UserID, r.Quantity); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// SettleFlashSales gives what ended flash sales still hold back to stock,
+// once none of their reservations is held any more, and drops them from
+// the counter. Like any restock the copies go to backorders first, see
+// FulfilBackorders for taxRate.
+func SettleFlashSales(ctx context.Context, db *gorm.DB, counter flashsale.Counter, n notify.Notifier, taxRate float64) error {
+	var sales []models.FlashSale
+	err := db.Where("ends_at <= ? AND settled_at IS NULL", time.Now()).
+		Where("NOT EXISTS (SELECT 1 FROM flash_sale_reservations r WHERE r.flash_sale_id = flash_sales.id AND r.status = ?)", models.ReservationHeld).
+		Find(&sales).Error
+	if err != nil {
+		return err
+	}
+	for _, s := range sales {
+		var restocks []*models.RestockEvent
+		var ready []uint
+		err := db.Transaction(func(tx *gorm.DB) error {
+			sale, err := lockFlashSale(tx, s.ID)
+			if err != nil || sale.SettledAt != nil {
+				return err
+			}
+			var held []models.FlashSaleStock
+			if err := tx.Where("flash_sale_id = ? AND quantity > 0", sale.ID).Find(&held).Error; err != nil {
+				return err
+			}
+			for _, h := range held {
+				_, ev, err := ApplyStockChange(tx, StockChange{
+					BookID: sale.BookID, WarehouseID: h.WarehouseID, Delta: h.Quantity, Type: models.MovementFlashSale,
+					Reason: fmt.Sprintf("flash sale %d ended", sale.ID),
+				})
+				if err != nil {
+					return err
+				}
+				if ev != nil {
+					restocks = append(restocks, ev)
+				}
+			}
+			if err := tx.Where("flash_sale_id = ?", sale.ID).Delete(&models.FlashSaleStock{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&sale).Update("settled_at", time.Now()).Error; err != nil {
+				return err
+			}
+			if len(held) > 0 {
+				ready, err = FulfilBackorders(tx, sale.BookID, taxRate)
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("flash sale %d: %w", s.ID, err)
+		}
+		if err := counter.Drop(ctx, s.ID); err != nil {
+			log.Printf("flash sale %d: dropping from the counter: %v", s.ID, err)
+		}
+		for _, ev := range restocks {
+			NotifyRestockAsync(db, n, ev)
+		}
+		if err := NotifyOrdersReady(ctx, db, n, ready); err != nil {
+			log.Printf("notify ready orders %v: %v", ready, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"bookstore-api/app/db"
+	"bookstore-api/app/flashsale"
+	"bookstore-api/app/models"
+	"bookstore-api/app/notify"
+	"bookstore-api/app/services"
+
+	"gorm.io/gorm"
+)
+
+// TestFlashSaleContention runs against a real database, which it migrates
+// and adds its own rows to, e.g.
+//
+//	TEST_DATABASE_URL="host=localhost user=postgres password=postgres dbname=bookstore_test sslmode=disable" go test -race ./app/services/
+//
+// Customers race to reserve and order the copies of a flash sale while
+// others order the same book at its normal price. The database must show
+// no more sold than the sale had, no customer over the limit, and the
+// sale's copies never going to the normal orders.
+func TestFlashSaleContention(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	gdb, err := db.Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	counter := flashsale.NewMemory()
+	run := time.Now().UnixNano()
+
+	const (
+		stock     = 20
+		quantity  = 5
+		limit     = 2
+		customers = 12
+		attempts  = 3
+		shoppers  = 6
+		orders    = 4
+	)
+	category := models.Category{Name: fmt.Sprintf("Flash sale test %d", run)}
+	if err := gdb.Create(&category).Error; err != nil {
+		t.Fatal(err)
+	}
+	book := models.Book{Title: "Flash sale test", Author: "Test", Price: 100000, Currency: models.BaseCurrency, CategoryID: category.ID}
+	if err := gdb.Create(&book).Error; err != nil {
+		t.Fatal(err)
+	}
+	err = gdb.Transaction(func(tx *gorm.DB) error {
+		_, _, err := services.ApplyStockChange(tx, services.StockChange{
+			BookID: book.ID, Delta: stock, Type: models.MovementRestock, Reason: "flash sale test",
+		})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := make([]models.User, customers+shoppers)
+	for i := range users {
+		users[i] = models.User{Name: "Test", Email: fmt.Sprintf("flash-%d-%d@example.com", run, i), Password: "x"}
+	}
+	if err := gdb.Create(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	sale := models.FlashSale{
+		BookID: book.ID, Price: 50000, Quantity: quantity, PerUserLimit: limit,
+		StartsAt: time.Now().Add(-time.Minute), EndsAt: time.Now().Add(time.Hour), UserID: users[0].ID,
+	}
+	err = gdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&sale).Error; err != nil {
+			return err
+		}
+		return services.HoldFlashSaleStock(tx, sale)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newOrder := func(userID uint) services.NewOrder {
+		return services.NewOrder{
+			UserID: userID, Currency: models.BaseCurrency, ExchangeRate: 1, Strategy: services.AllocatePrimaryFirst,
+			Convert: func(amount float64, currency string) (float64, error) { return amount, nil },
+		}
+	}
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, u := range users[:customers] {
+		wg.Add(1)
+		go func(userID uint) {
+			defer wg.Done()
+			<-start
+			for i := 0; i < attempts; i++ {
+				r, err := services.ReserveFlashSale(ctx, gdb, counter, sale, userID, 1, time.Minute)
+				if errors.Is(err, flashsale.ErrSoldOut) || errors.Is(err, flashsale.ErrLimitReached) {
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				err = gdb.Transaction(func(tx *gorm.DB) error {
+					_, err := services.ConvertReservation(tx, r.ID, newOrder(userID))
+					return err
+				})
+				if err != nil {
+					t.Errorf("convert reservation %d: %v", r.ID, err)
+					return
+				}
+			}
+		}(u.ID)
+	}
+	for _, u := range users[customers:] {
+		wg.Add(1)
+		go func(userID uint) {
+			defer wg.Done()
+			<-start
+			for i := 0; i < orders; i++ {
+				in := newOrder(userID)
+				in.Lines = []services.OrderLine{{BookID: book.ID, Quantity: 1}}
+				err := gdb.Transaction(func(tx *gorm.DB) error {
+					_, err := services.PlaceOrder(tx, in)
+					return err
+				})
+				var orderErr *services.OrderError
+				if err != nil && !errors.As(err, &orderErr) {
+					t.Error(err)
+					return
+				}
+			}
+		}(u.ID)
+	}
+	close(start)
+	wg.Wait()
+
+	var perUser []struct {
+		UserID   uint
+		Quantity int
+	}
+	err = gdb.Model(&models.OrderItem{}).Select("orders.user_id, SUM(order_items.quantity) AS quantity").
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Joins("JOIN flash_sale_reservations r ON r.order_id = orders.id").
+		Where("r.flash_sale_id = ? AND r.status = ?", sale.ID, models.ReservationConverted).
+		Group("orders.user_id").Scan(&perUser).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	sold := 0
+	for _, u := range perUser {
+		sold += u.Quantity
+		if u.Quantity > limit {
+			t.Errorf("user %d ordered %d from the sale, over the limit of %d", u.UserID, u.Quantity, limit)
+		}
+	}
+	if sold != quantity {
+		t.Errorf("%d sold in the flash sale, want all %d", sold, quantity)
+	}
+
+	var normal int
+	err = gdb.Model(&models.OrderItem{}).Select("COALESCE(SUM(order_items.quantity), 0)").
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("order_items.book_id = ? AND orders.user_id IN ?", book.ID, userIDs(users[customers:])).
+		Scan(&normal).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if normal != stock-quantity {
+		t.Errorf("%d sold at the normal price, want the %d not held for the sale", normal, stock-quantity)
+	}
+
+	var held int
+	if err := gdb.Model(&models.FlashSaleStock{}).Select("COALESCE(SUM(quantity), 0)").Where("flash_sale_id = ?", sale.ID).Scan(&held).Error; err != nil {
+		t.Fatal(err)
+	}
+	if held != quantity-sold {
+		t.Errorf("sale still holds %d, want %d", held, quantity-sold)
+	}
+	checkStock(t, gdb, book.ID, stock-sold-normal-held)
+
+	// once ended the sale gives back anything it holds
+	if err := gdb.Model(&sale).Update("ends_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := services.SettleFlashSales(ctx, gdb, counter, notify.NewLog(), 0); err != nil {
+		t.Fatal(err)
+	}
+	checkStock(t, gdb, book.ID, stock-sold-normal)
+}
+
+func userIDs(users []models.User) []uint {
+	ids := make([]uint, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	return ids
+}
+
+// checkStock fails t unless the book has want in stock, in total and
+// across its warehouses.
+func checkStock(t *testing.T, gdb *gorm.DB, bookID uint, want int) {
+	t.Helper()
+	var book models.Book
+	if err := gdb.Select("id", "stock").First(&book, bookID).Error; err != nil {
+		t.Fatal(err)
+	}
+	var inWarehouses int
+	if err := gdb.Model(&models.WarehouseStock{}).Select("COALESCE(SUM(quantity), 0)").Where("book_id = ?", bookID).Scan(&inWarehouses).Error; err != nil {
+		t.Fatal(err)
+	}
+	if book.Stock != want || inWarehouses != want {
+		t.Errorf("stock = %d, %d across warehouses, want %d", book.Stock, inWarehouses, want)
+	}
+}
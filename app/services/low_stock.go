@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"bookstore-api/app/models"
+	"bookstore-api/app/notify"
+
+	"gorm.io/gorm"
+)
+
+// CheckLowStock alerts the admins about each of the books whose stock is
+// below its reorder threshold. A book is marked alerted by the same
+// statement that finds it, so concurrent checks alert once, and stays
+// marked until ApplyStockChange sees it restocked to the threshold.
+func CheckLowStock(ctx context.Context, db *gorm.DB, n notify.Notifier, bookIDs []uint) error {
+	var books []models.Book
+	err := db.Raw(`
+		UPDATE books SET low_stock_alerted_at = now()
+		WHERE id IN ? AND deleted_at IS NULL AND stock < reorder_threshold AND low_stock_alerted_at IS NULL
+		RETURNING id, title, author, stock, reorder_threshold, reorder_quantity`, bookIDs).Scan(&books).Error
+	if err != nil || len(books) == 0 {
+		return err
+	}
+	var admins []models.User
+	if err := db.Where("role = ? AND is_active", "admin").Find(&admins).Error; err != nil {
+		return err
+	}
+
+	for _, b := range books {
+		for _, a := range admins {
+			err := n.Notify(ctx, notify.Message{
+				Event:   "book.low_stock",
+				To:      a.Email,
+				Subject: fmt.Sprintf("Low stock: %s", b.Title),
+				Body: fmt.Sprintf("Hi %s,\n\n%s by %s is down to %d copies, below its reorder threshold of %d. Suggested reorder: %d copies.\n",
+					a.Name, b.Title, b.Author, b.Stock, b.ReorderThreshold, b.ReorderQuantity),
+				Data: map[string]interface{}{
+					"book_id": b.ID, "title": b.Title, "stock": b.Stock,
+					"reorder_threshold": b.ReorderThreshold, "reorder_quantity": b.ReorderQuantity,
+				},
+			})
+			if err != nil {
+				log.Printf("low-stock alert for book %d to user %d: %v", b.ID, a.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckLowStockAsync runs CheckLowStock in the background, after the
+// request that lowered the stock has been answered.
+func CheckLowStockAsync(db *gorm.DB, n notify.Notifier, bookIDs ...uint) {
+	if len(bookIDs) == 0 {
+		return
+	}
+	go func() {
+		if err := CheckLowStock(context.Background(), db, n, bookIDs); err != nil {
+			log.Printf("check low stock of books %v: %v", bookIDs, err)
+		}
+	}()
+}
+
+// ClearLowStockAlert ends a book's low-stock alert if its stock meets the
+// reorder threshold, e.g. after the threshold was lowered.
+func ClearLowStockAlert(tx *gorm.DB, bookID uint) error {
+	return tx.Model(&models.Book{}).
+		Where("id = ? AND low_stock_alerted_at IS NOT NULL AND stock >= reorder_threshold", bookID).
+		Update("low_stock_alerted_at", nil).Error
+}
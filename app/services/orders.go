@@ -0,0 +1,363 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"bookstore-api/app/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OrderLine is a book, or a bundle of books, and how many of it are
+// ordered. Exactly one of BookID and BundleID is set. A book can be sold
+// at a FixedPrice, in its own currency, instead of its effective price,
+// and taken from what a flash sale holds rather than from stock.
+type OrderLine struct {
+	BookID      uint
+	BundleID    uint
+	Quantity    int
+	FixedPrice  *float64
+	FlashSaleID uint
+}
+
+// NewOrder is what PlaceOrder needs to place an order. Convert converts an
+// amount into the order's currency.
+type NewOrder struct {
+	UserID       uint
+	Currency     string
+	ExchangeRate float64
+	Lines        []OrderLine
+	Strategy     string
+	Convert      func(amount float64, currency string) (float64, error)
+}
+
+// OrderError is a problem with what was ordered, meant for a 400 response.
+type OrderError struct {
+	Message string
+}
+
+func (e *OrderError) Error() string { return e.Message }
+
+// PlaceOrderResult is the placed order and the books whose stock it took.
+type PlaceOrderResult struct {
+	Order   models.Order
+	BookIDs []uint
+}
+
+// itemLine is one order item to be created: a book ordered on its own or
+// as part of a bundle. Price is per unit and Discount for the whole line,
+// both in the order's currency.
+type itemLine struct {
+	BookID         uint
+	Quantity       int
+	BundleID       *uint
+	BundleQuantity int
+	FixedPrice     *float64
+	FlashSaleID    uint
+	Price          float64
+	Discount       float64
+}
+
+// PlaceOrder creates an order inside tx. Bundles are ordered as the books
+// in them, which are locked in id order so concurrent orders can't
+// deadlock. What is in stock is allocated to
+// warehouses with the order's strategy and taken from stock. The rest is
+// backordered if the book is on pre-order or allows backorders within its
+// cap, which makes the order BACKORDERED; otherwise the order is refused
+// with an OrderError. Lines of a flash sale are taken from what the sale
+// holds first, its sale locked by the caller. Ebooks take no stock; they
+// can be downloaded once the order is paid.
+func PlaceOrder(tx *gorm.DB, in NewOrder) (*PlaceOrderResult, error) {
+	order := models.Order{UserID: in.UserID, Status: models.OrderPending, Currency: in.Currency, ExchangeRate: in.ExchangeRate}
+	if err := tx.Create(&order).Error; err != nil {
+		return nil, err
+	}
+
+	items, bundles, err := expandBundles(tx, in.Lines)
+	if err != nil {
+		return nil, err
+	}
+	bookIDs := make([]uint, 0, len(items))
+	for _, it := range items {
+		bookIDs = append(bookIDs, it.BookID)
+	}
+	var books []models.Book
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", bookIDs).Order("id").Find(&books).Error; err != nil {
+		return nil, err
+	}
+	prices, err := EffectivePrices(tx, bookIDs)
+	if err != nil {
+		return nil, err
+	}
+	booksByID := make(map[uint]models.Book, len(books))
+	for _, b := range books {
+		price := prices[b.ID]
+		b.EffectivePrice = &price
+		booksByID[b.ID] = b
+	}
+	levels, err := LoadStockLevels(tx, bookIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// split each line into what ships from stock and what is backordered.
+	// Copies already promised to earlier backorders aren't in stock for
+	// this order, whether or not those have been fulfilled yet.
+	now := time.Now()
+	inStock := map[uint]int{}
+	for _, w := range levels.Warehouses {
+		for b, q := range levels.Quantity[w] {
+			inStock[b] += q
+		}
+	}
+	promised := map[uint]int{}
+	for _, b := range books {
+		if b.Format == models.FormatEbook {
+			continue
+		}
+		n, err := BackorderedQuantity(tx, b.ID)
+		if err != nil {
+			return nil, err
+		}
+		promised[b.ID] = n
+		inStock[b.ID] = max(0, inStock[b.ID]-n)
+	}
+	backorderRoom := map[uint]int{}
+	backordered := make([]int, len(items))
+	fromSale := make([][]models.FlashSaleStock, len(items))
+	var lines []AllocationLine
+	var lineOf []int
+	for i, l := range items {
+		book, ok := booksByID[l.BookID]
+		if !ok {
+			return nil, &OrderError{Message: "book not found"}
+		}
+		if book.Format == models.FormatEbook {
+			// ebooks take no stock and are never backordered
+			if book.FileKey == "" {
+				return nil, &OrderError{Message: "ebook " + book.Title + " is not available for download yet"}
+			}
+			continue
+		}
+		need := l.Quantity
+		if l.FlashSaleID != 0 {
+			if fromSale[i], err = drawFlashSaleStock(tx, l.FlashSaleID, need); err != nil {
+				return nil, err
+			}
+			for _, h := range fromSale[i] {
+				need -= h.Quantity
+			}
+		}
+		take := need
+		if OnPreorder(book, now) {
+			take = 0
+		} else if take > inStock[book.ID] {
+			take = inStock[book.ID]
+		}
+		inStock[book.ID] -= take
+		backordered[i] = need - take
+		if take > 0 {
+			lines = append(lines, AllocationLine{BookID: book.ID, Quantity: take})
+			lineOf = append(lineOf, i)
+		}
+		if backordered[i] == 0 || OnPreorder(book, now) {
+			continue
+		}
+		if book.BackorderCap == 0 {
+			return nil, &OrderError{Message: "quantity exceeds stock for book " + book.Title}
+		}
+		room, ok := backorderRoom[book.ID]
+		if !ok {
+			room = book.BackorderCap - promised[book.ID]
+		}
+		if backordered[i] > room {
+			if room < 0 {
+				room = 0
+			}
+			return nil, &OrderError{Message: fmt.Sprintf("quantity exceeds stock for book %s, and only %d more copies can be backordered", book.Title, room)}
+		}
+		backorderRoom[book.ID] = room - backordered[i]
+	}
+	allocations, err := Allocate(in.Strategy, lines, levels)
+	if err != nil {
+		return nil, err
+	}
+
+	if order.TotalPrice, err = priceItems(items, booksByID, bundles, in.Convert); err != nil {
+		return nil, &OrderError{Message: err.Error()}
+	}
+
+	var waiting []uint
+	for i, l := range items {
+		book := booksByID[l.BookID]
+		oi := models.OrderItem{
+			OrderID: order.ID, BookID: book.ID, Quantity: l.Quantity, Price: l.Price, Discount: l.Discount,
+			BackorderedQuantity: backordered[i], BundleID: l.BundleID, BundleQuantity: l.BundleQuantity,
+			Digital: book.Format == models.FormatEbook,
+		}
+		if err := tx.Create(&oi).Error; err != nil {
+			return nil, err
+		}
+		// held copies are already out of stock
+		for _, h := range fromSale[i] {
+			if err := tx.Create(&models.OrderItemAllocation{OrderItemID: oi.ID, WarehouseID: h.WarehouseID, Quantity: h.Quantity}).Error; err != nil {
+				return nil, err
+			}
+		}
+		for _, a := range allocations {
+			if lineOf[a.Line] != i {
+				continue
+			}
+			_, _, err := ApplyStockChange(tx, StockChange{
+				BookID: book.ID, WarehouseID: a.WarehouseID, Delta: -a.Quantity, Type: models.MovementSale,
+				OrderID: &order.ID, UserID: &in.UserID,
+			})
+			if err == nil {
+				err = tx.Create(&models.OrderItemAllocation{OrderItemID: oi.ID, WarehouseID: a.WarehouseID, Quantity: a.Quantity}).Error
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if backordered[i] > 0 {
+			waiting = append(waiting, book.ID)
+		}
+	}
+
+	if len(waiting) > 0 {
+		order.Status = models.OrderBackordered
+	}
+	if err := tx.Save(&order).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range waiting {
+		if err := RefreshExpectedShipDates(tx, id); err != nil {
+			return nil, err
+		}
+	}
+	return &PlaceOrderResult{Order: order, BookIDs: bookIDs}, nil
+}
+
+// priceItems sets the price of every item and returns the order's total.
+// Books on their own are charged their fixed or effective price, what they
+// are cheaper by being their discount. The books of a bundle share its price in proportion to
+// their list prices, what they are cheaper by being their discount; the
+// last book takes the rounding.
+func priceItems(items []itemLine, books map[uint]models.Book, bundles map[uint]models.Bundle, convert func(float64, string) (float64, error)) (float64, error) {
+	var total float64
+	for i := range items {
+		book := books[items[i].BookID]
+		price, err := convert(book.Price, book.Currency)
+		if err != nil {
+			return 0, err
+		}
+		items[i].Price = price
+		if items[i].BundleID != nil {
+			continue
+		}
+		charged := *book.EffectivePrice
+		if items[i].FixedPrice != nil {
+			charged = *items[i].FixedPrice
+		}
+		effective, err := convert(charged, book.Currency)
+		if err != nil {
+			return 0, err
+		}
+		// charged in whole cents, so the items add up to the total
+		effective = roundCents(effective)
+		items[i].Price = effective
+		items[i].Discount = math.Max(0, roundCents((price-effective)*float64(items[i].Quantity)))
+		total += effective * float64(items[i].Quantity)
+	}
+	for i := 0; i < len(items); {
+		if items[i].BundleID == nil {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(items) && items[j].BundleID != nil && *items[j].BundleID == *items[i].BundleID {
+			j++
+		}
+		group := items[i:j]
+		i = j
+
+		bundle := bundles[*group[0].BundleID]
+		price, err := convert(bundle.Price, bundle.Currency)
+		if err != nil {
+			return 0, err
+		}
+		amount := roundCents(price * float64(group[0].BundleQuantity))
+		total += amount
+		// a bundle of free books is shared by quantity instead
+		weight := func(it itemLine) float64 { return it.Price * float64(it.Quantity) }
+		var weights float64
+		for _, it := range group {
+			weights += weight(it)
+		}
+		if weights == 0 {
+			weight = func(it itemLine) float64 { return float64(it.Quantity) }
+			for _, it := range group {
+				weights += weight(it)
+			}
+		}
+		left := amount
+		for k := range group {
+			it := &group[k]
+			line := left
+			if k < len(group)-1 {
+				line = roundCents(amount * weight(*it) / weights)
+				left -= line
+			}
+			it.Discount = math.Max(0, roundCents(it.Price*float64(it.Quantity)-line))
+			it.Price = line / float64(it.Quantity)
+		}
+	}
+	return roundCents(total), nil
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// MarkOrderPaid marks a pending order, locked in tx, as PAID and assigns its
+// invoice number.
+func MarkOrderPaid(tx *gorm.DB, order *models.Order, taxRate float64) error {
+	now := time.Now()
+	number, err := nextInvoiceNumber(tx, now)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(order).Updates(map[string]interface{}{
+		"status":         models.OrderPaid,
+		"paid_at":        now,
+		"invoice_number": number,
+		"tax_rate":       taxRate,
+	}).Error; err != nil {
+		return err
+	}
+	// popularity sorting counts units sold in paid orders
+	return tx.Exec(`UPDATE books SET sold_count = sold_count + oi.quantity
+		FROM (SELECT book_id, SUM(quantity) AS quantity FROM order_items WHERE order_id = ? GROUP BY book_id) oi
+		WHERE books.id = oi.book_id`, order.ID).Error
+}
+
+// nextInvoiceNumber takes the next number of the year's sequence. It must run
+// inside the transaction that marks the order paid, so a rollback also gives
+// the number back and the sequence stays gap-free.
+func nextInvoiceNumber(tx *gorm.DB, now time.Time) (string, error) {
+	seq := models.InvoiceSequence{Year: now.Year()}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&seq).Error; err != nil {
+		return "", err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&seq, "year = ?", seq.Year).Error; err != nil {
+		return "", err
+	}
+	seq.LastNumber++
+	if err := tx.Save(&seq).Error; err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("INV/%d/%06d", seq.Year, seq.LastNumber), nil
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"math"
+	"testing"
+
+	"bookstore-api/app/models"
+)
+
+func TestPriceItems(t *testing.T) {
+	book := func(id uint, price, effective float64) models.Book {
+		return models.Book{ID: id, Price: price, EffectivePrice: &effective, Currency: "IDR"}
+	}
+	books := map[uint]models.Book{
+		1: book(1, 10000, 10000),
+		2: book(2, 20000, 15000), // on sale
+		3: book(3, 30333, 30333),
+		4: book(4, 0, 0),
+		5: book(5, 0, 0),
+	}
+	bundles := map[uint]models.Bundle{
+		1: {ID: 1, Price: 50000, Currency: "IDR"},
+		2: {ID: 2, Price: 99999, Currency: "IDR"},
+		3: {ID: 3, Price: 100000, Currency: "IDR"}, // dearer than its books
+		4: {ID: 4, Price: 10001, Currency: "IDR"},  // of free books
+	}
+	inBundle := func(bundle uint, book uint, quantity, bundles int) itemLine {
+		return itemLine{BookID: book, Quantity: quantity * bundles, BundleID: &bundle, BundleQuantity: bundles}
+	}
+	identity := func(amount float64, currency string) (float64, error) { return amount, nil }
+	fixed := 12000.0
+
+	tests := []struct {
+		name    string
+		items   []itemLine
+		convert func(float64, string) (float64, error)
+		total   float64
+	}{
+		{
+			name:  "books on their own",
+			items: []itemLine{{BookID: 1, Quantity: 2}, {BookID: 2, Quantity: 3}},
+			total: 2*10000 + 3*15000,
+		},
+		{
+			name:  "fixed price",
+			items: []itemLine{{BookID: 1, Quantity: 1, FixedPrice: &fixed}},
+			total: 12000,
+		},
+		{
+			name:  "bundle",
+			items: []itemLine{inBundle(1, 1, 1, 1), inBundle(1, 2, 1, 1), inBundle(1, 3, 1, 1)},
+			total: 50000,
+		},
+		{
+			name:  "several bundles with several copies",
+			items: []itemLine{inBundle(2, 1, 2, 3), inBundle(2, 3, 1, 3)},
+			total: 3 * 99999,
+		},
+		{
+			name:  "bundle dearer than its books",
+			items: []itemLine{inBundle(3, 1, 1, 1), inBundle(3, 2, 1, 1)},
+			total: 100000,
+		},
+		{
+			name:  "bundle of free books",
+			items: []itemLine{inBundle(4, 4, 1, 1), inBundle(4, 5, 2, 1)},
+			total: 10001,
+		},
+		{
+			name:  "bundle next to books",
+			items: []itemLine{{BookID: 2, Quantity: 1}, inBundle(1, 1, 1, 2), inBundle(1, 2, 1, 2), inBundle(1, 3, 1, 2), {BookID: 3, Quantity: 1}},
+			total: 15000 + 2*50000 + 30333,
+		},
+		{
+			name:    "converted into another currency",
+			items:   []itemLine{inBundle(2, 1, 2, 3), inBundle(2, 3, 1, 3), {BookID: 2, Quantity: 1}},
+			convert: func(amount float64, currency string) (float64, error) { return amount / 16321, nil },
+			total:   roundCents(99999.0/16321*3) + roundCents(15000.0/16321),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			convert := tt.convert
+			if convert == nil {
+				convert = identity
+			}
+			total, err := priceItems(tt.items, books, bundles, convert)
+			if err != nil {
+				t.Fatalf("priceItems: %v", err)
+			}
+			if math.Abs(total-tt.total) > 1e-9 {
+				t.Errorf("total = %v, want %v", total, tt.total)
+			}
+
+			charged := map[uint]float64{}
+			var sum float64
+			for _, it := range tt.items {
+				if it.Discount < 0 {
+					t.Errorf("book %d has a negative discount %v", it.BookID, it.Discount)
+				}
+				if it.Price < 0 {
+					t.Errorf("book %d has a negative price %v", it.BookID, it.Price)
+				}
+				line := it.Price * float64(it.Quantity)
+				sum += line
+				if it.BundleID != nil {
+					charged[*it.BundleID] += line
+				}
+			}
+			if math.Abs(sum-tt.total) > 1e-6 {
+				t.Errorf("items add up to %v, want %v", sum, tt.total)
+			}
+			for id, got := range charged {
+				var quantity int
+				for _, it := range tt.items {
+					if it.BundleID != nil && *it.BundleID == id {
+						quantity = it.BundleQuantity
+					}
+				}
+				price, _ := convert(bundles[id].Price, bundles[id].Currency)
+				if want := roundCents(price * float64(quantity)); math.Abs(got-want) > 1e-6 {
+					t.Errorf("items of bundle %d add up to %v, want %v", id, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"bookstore-api/app/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EffectivePriceSQL is a book's price, in its own currency, after the
+// cheapest sale in effect for the book, its category or any category above
+// it. See models.PriceRule.
+const EffectivePriceSQL = `LEAST(books.price, (
+	SELECT MIN(COALESCE(pr.sale_price, ROUND(books.price * (100 - pr.percent_off) / 100, 2)))
+	FROM price_rules pr
+	WHERE pr.deleted_at IS NULL AND pr.starts_at <= now() AND (pr.ends_at IS NULL OR pr.ends_at > now())
+		AND (pr.book_id = books.id OR pr.category_id IN (
+			WITH RECURSIVE up AS (
+				SELECT id, parent_id FROM categories WHERE id = books.category_id
+				UNION
+				SELECT c.id, c.parent_id FROM categories c JOIN up ON c.id = up.parent_id
+			)
+			SELECT id FROM up))))`
+
+// EffectivePrices returns the effective price of each of the books, in
+// their own currencies.
+func EffectivePrices(db *gorm.DB, bookIDs []uint) (map[uint]float64, error) {
+	var rows []struct {
+		ID             uint
+		EffectivePrice float64
+	}
+	err := db.Model(&models.Book{}).Select("books.id, "+EffectivePriceSQL+" AS effective_price").
+		Where("books.id IN ?", bookIDs).Scan(&rows).Error
+	prices := make(map[uint]float64, len(rows))
+	for _, r := range rows {
+		prices[r.ID] = r.EffectivePrice
+	}
+	return prices, err
+}
+
+// SetBookPrice changes the book's list price, its currency, or both inside
+// tx and records the change in its price history. A nil price or currency
+// is left as it is, and nothing is recorded if neither changes.
+func SetBookPrice(tx *gorm.DB, bookID uint, price *float64, currency *string, userID *uint) error {
+	var book models.Book
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "price", "currency").First(&book, bookID).Error; err != nil {
+		return err
+	}
+	change := models.BookPriceChange{
+		BookID: bookID, OldPrice: &book.Price, OldCurrency: book.Currency,
+		Price: book.Price, Currency: book.Currency, UserID: userID,
+	}
+	if price != nil {
+		change.Price = *price
+	}
+	if currency != nil {
+		change.Currency = *currency
+	}
+	if change.Price == book.Price && change.Currency == book.Currency {
+		return nil
+	}
+	if err := tx.Model(&book).Updates(map[string]interface{}{"price": change.Price, "currency": change.Currency}).Error; err != nil {
+		return err
+	}
+	return tx.Create(&change).Error
+}
+
+// RecordInitialPrice starts the price history of a newly created book.
+func RecordInitialPrice(tx *gorm.DB, book models.Book, userID *uint) error {
+	return tx.Create(&models.BookPriceChange{BookID: book.ID, Price: book.Price, Currency: book.Currency, UserID: userID}).Error
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"bookstore-api/app/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPurchaseOrderNotOpen = errors.New("purchase order is not awaiting goods")
+	ErrInvalidReceipt       = errors.New("invalid receipt")
+)
+
+// ReceiptLine is a quantity of one purchase order line that arrived. A nil
+// UnitCost means the cost agreed on the order.
+type ReceiptLine struct {
+	ItemID   uint
+	Quantity int
+	UnitCost *float64
+}
+
+// ReceivePurchaseOrder books goods that arrived against a sent purchase
+// order, in tx. Each line restocks the order's warehouse through
+// ApplyStockChange and is kept as a receipt with its unit cost. Lines may
+// not receive more than is still outstanding. The order becomes received
+// once every line is complete, partially received otherwise. The goods go
+// to backorders first, see FulfilBackorders for taxRate. Restock events
+// and the orders that became ready are returned, for NotifyRestockAsync
+// and NotifyOrdersReadyAsync once tx has committed.
+func ReceivePurchaseOrder(tx *gorm.DB, poID, userID uint, lines []ReceiptLine, taxRate float64) ([]*models.RestockEvent, []uint, error) {
+	var po models.PurchaseOrder
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&po, poID).Error; err != nil {
+		return nil, nil, err
+	}
+	if po.Status != models.PurchaseSent && po.Status != models.PurchasePartiallyReceived {
+		return nil, nil, ErrPurchaseOrderNotOpen
+	}
+	var items []models.PurchaseOrderItem
+	if err := tx.Where("purchase_order_id = ?", po.ID).Order("id").Find(&items).Error; err != nil {
+		return nil, nil, err
+	}
+	byID := make(map[uint]*models.PurchaseOrderItem, len(items))
+	for i := range items {
+		byID[items[i].ID] = &items[i]
+	}
+
+	var restocks []*models.RestockEvent
+	for _, l := range lines {
+		item, ok := byID[l.ItemID]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: item %d is not on purchase order %d", ErrInvalidReceipt, l.ItemID, po.ID)
+		}
+		if outstanding := item.QuantityOrdered - item.QuantityReceived; l.Quantity > outstanding {
+			return nil, nil, fmt.Errorf("%w: item %d has only %d outstanding", ErrInvalidReceipt, item.ID, outstanding)
+		}
+		mv, ev, err := ApplyStockChange(tx, StockChange{
+			BookID: item.BookID, WarehouseID: po.WarehouseID, Delta: l.Quantity, Type: models.MovementRestock,
+			PurchaseOrderID: &po.ID, UserID: &userID, Reason: fmt.Sprintf("received on purchase order %d", po.ID),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if ev != nil {
+			restocks = append(restocks, ev)
+		}
+		receipt := models.PurchaseReceipt{
+			PurchaseOrderItemID: item.ID, Quantity: l.Quantity, UnitCost: item.UnitCost,
+			StockMovementID: mv.ID, UserID: userID,
+		}
+		if l.UnitCost != nil {
+			receipt.UnitCost = *l.UnitCost
+		}
+		if err := tx.Create(&receipt).Error; err != nil {
+			return nil, nil, err
+		}
+		item.QuantityReceived += l.Quantity
+		if err := tx.Model(item).Update("quantity_received", item.QuantityReceived).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	updates := map[string]interface{}{"status": models.PurchaseReceived, "received_at": time.Now()}
+	for _, item := range items {
+		if item.QuantityReceived < item.QuantityOrdered {
+			updates = map[string]interface{}{"status": models.PurchasePartiallyReceived}
+			break
+		}
+	}
+	if err := tx.Model(&po).Updates(updates).Error; err != nil {
+		return nil, nil, err
+	}
+	var ready []uint
+	for _, item := range items {
+		ids, err := FulfilBackorders(tx, item.BookID, taxRate)
+		if err != nil {
+			return nil, nil, err
+		}
+		ready = append(ready, ids...)
+	}
+	return restocks, ready, nil
+}
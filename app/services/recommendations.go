@@ -0,0 +1,135 @@
+package services
+
+import (
+	"bookstore-api/app/models"
+
+	"gorm.io/gorm"
+)
+
+// Reasons a book is recommended, most relevant first.
+const (
+	ReasonBoughtTogether = "bought_together"
+	ReasonSameAuthor     = "same_author"
+	ReasonSameCategory   = "same_category"
+	ReasonBestseller     = "bestseller"
+)
+
+type Recommendation struct {
+	BookID uint
+	Reason string
+}
+
+// RefreshCoPurchases recomputes the book_co_purchases materialized view
+// without blocking readers.
+func RefreshCoPurchases(db *gorm.DB) error {
+	return db.Exec("REFRESH MATERIALIZED VIEW CONCURRENTLY book_co_purchases").Error
+}
+
+// RelatedBooks returns up to limit books bought together with the book,
+// topped up with books by the same authors and then from the same category.
+func RelatedBooks(db *gorm.DB, book models.Book, limit int) ([]Recommendation, error) {
+	r := newRecommender(limit, book.ID)
+	err := r.add(db, ReasonBoughtTogether, `
+		SELECT cp.related_book_id FROM book_co_purchases cp
+		JOIN books ON books.id = cp.related_book_id AND books.deleted_at IS NULL
+		WHERE cp.book_id = ?
+		ORDER BY cp.orders DESC, books.sold_count DESC
+		LIMIT ?`, book.ID)
+	if err == nil {
+		err = r.add(db, ReasonSameAuthor, `
+			SELECT books.id FROM books
+			WHERE books.deleted_at IS NULL AND books.id IN (
+				SELECT ba.book_id FROM book_authors ba
+				WHERE ba.author_id IN (SELECT author_id FROM book_authors WHERE book_id = ?))
+			ORDER BY books.sold_count DESC, books.id DESC
+			LIMIT ?`, book.ID)
+	}
+	if err == nil {
+		err = r.add(db, ReasonSameCategory, `
+			SELECT books.id FROM books
+			WHERE books.deleted_at IS NULL AND books.category_id = ?
+			ORDER BY books.sold_count DESC, books.id DESC
+			LIMIT ?`, book.CategoryID)
+	}
+	return r.recs, err
+}
+
+// RecommendForUser ranks books by how often they were bought together with
+// the user's own paid purchases, leaving out what the user already bought.
+// It is topped up with bestsellers from the categories the user buys from,
+// then with bestsellers overall.
+func RecommendForUser(db *gorm.DB, userID uint, limit int) ([]Recommendation, error) {
+	var bought []uint
+	err := db.Model(&models.OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("orders.user_id = ? AND orders.status = ?", userID, models.OrderPaid).
+		Distinct().Pluck("order_items.book_id", &bought).Error
+	if err != nil {
+		return nil, err
+	}
+	r := newRecommender(limit, bought...)
+	if len(bought) > 0 {
+		err = r.add(db, ReasonBoughtTogether, `
+			SELECT cp.related_book_id FROM book_co_purchases cp
+			JOIN books ON books.id = cp.related_book_id AND books.deleted_at IS NULL
+			WHERE cp.book_id IN ?
+			GROUP BY cp.related_book_id
+			ORDER BY SUM(cp.orders) DESC, MAX(books.sold_count) DESC
+			LIMIT ?`, bought)
+		if err == nil {
+			err = r.add(db, ReasonSameCategory, `
+				SELECT books.id FROM books
+				WHERE books.deleted_at IS NULL
+					AND books.category_id IN (SELECT category_id FROM books WHERE id IN ?)
+				ORDER BY books.sold_count DESC, books.id DESC
+				LIMIT ?`, bought)
+		}
+	}
+	if err == nil {
+		err = r.add(db, ReasonBestseller, `
+			SELECT books.id FROM books
+			WHERE books.deleted_at IS NULL AND books.sold_count > 0
+			ORDER BY books.sold_count DESC, books.id DESC
+			LIMIT ?`)
+	}
+	return r.recs, err
+}
+
+// recommender collects distinct recommendations up to a limit, skipping
+// the books it was told to exclude.
+type recommender struct {
+	limit int
+	seen  map[uint]bool
+	recs  []Recommendation
+}
+
+func newRecommender(limit int, exclude ...uint) *recommender {
+	r := &recommender{limit: limit, seen: map[uint]bool{}, recs: []Recommendation{}}
+	for _, id := range exclude {
+		r.seen[id] = true
+	}
+	return r
+}
+
+// add runs query, whose last parameter must be the LIMIT, and keeps the
+// unseen ids it returns. It asks for enough rows to fill the limit even if
+// every excluded book comes back.
+func (r *recommender) add(db *gorm.DB, reason, query string, args ...interface{}) error {
+	if len(r.recs) >= r.limit {
+		return nil
+	}
+	var ids []uint
+	if err := db.Raw(query, append(args, r.limit+len(r.seen))...).Scan(&ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if len(r.recs) >= r.limit {
+			break
+		}
+		if !r.seen[id] {
+			r.seen[id] = true
+			r.recs = append(r.recs, Recommendation{BookID: id, Reason: reason})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"bookstore-api/app/models"
+	"bookstore-api/app/notify"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RecordRestock records a restock event when a book's stock goes from zero
+// (or below) to positive, and returns nil otherwise. It must run in the
+// transaction that changes the stock, with oldStock read under lock.
+func RecordRestock(tx *gorm.DB, bookID uint, oldStock, newStock int) (*models.RestockEvent, error) {
+	if oldStock > 0 || newStock <= 0 {
+		return nil, nil
+	}
+	ev := &models.RestockEvent{BookID: bookID, Stock: newStock}
+	if err := tx.Create(ev).Error; err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+// NotifyRestock tells every active user with the book on their wishlist
+// that it is back in stock. Each user is claimed in restock_notifications
+// before the message goes out, so running it twice for the same event
+// never notifies anyone twice.
+func NotifyRestock(ctx context.Context, db *gorm.DB, n notify.Notifier, ev *models.RestockEvent) error {
+	var book models.Book
+	if err := db.First(&book, ev.BookID).Error; err != nil {
+		return err
+	}
+	if book.Stock <= 0 {
+		// backorders took the new copies before anyone could be told
+		return nil
+	}
+	var users []models.User
+	err := db.Joins("JOIN wishlist_items ON wishlist_items.user_id = users.id").
+		Where("wishlist_items.book_id = ? AND users.is_active", ev.BookID).
+		Find(&users).Error
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		claim := models.RestockNotification{RestockEventID: ev.ID, UserID: u.ID}
+		res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&claim)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		err := n.Notify(ctx, notify.Message{
+			Event:   "book.back_in_stock",
+			To:      u.Email,
+			Subject: fmt.Sprintf("%s is back in stock", book.Title),
+			Body: fmt.Sprintf("Hi %s,\n\n%s by %s is back in stock. It is on your wishlist, so we thought you'd like to know.\n",
+				u.Name, book.Title, book.Author),
+			Data: map[string]interface{}{
+				"user_id": u.ID, "book_id": book.ID, "title": book.Title,
+				"stock": ev.Stock, "restock_event_id": ev.ID,
+			},
+		})
+		if err != nil {
+			log.Printf("back-in-stock notification for user %d, book %d: %v", u.ID, book.ID, err)
+			db.Model(&claim).Update("error", err.Error())
+		}
+	}
+	return nil
+}
+
+// NotifyRestockAsync runs NotifyRestock in the background, after the
+// request that restocked the book has been answered. ev may be nil.
+func NotifyRestockAsync(db *gorm.DB, n notify.Notifier, ev *models.RestockEvent) {
+	if ev == nil {
+		return
+	}
+	go func() {
+		if err := NotifyRestock(context.Background(), db, n, ev); err != nil {
+			log.Printf("notify restock of book %d: %v", ev.BookID, err)
+		}
+	}()
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"bookstore-api/app/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HasPurchasedBook reports whether the user has a paid order containing
+// the book.
+func HasPurchasedBook(db *gorm.DB, userID, bookID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("orders.user_id = ? AND order_items.book_id = ? AND orders.status = ?", userID, bookID, models.OrderPaid).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// RefreshBookRating recomputes the book's average rating and review count
+// from its visible reviews. It locks the book first, so concurrent review
+// changes recompute one after another and the last one sees them all.
+func RefreshBookRating(tx *gorm.DB, bookID uint) error {
+	var book models.Book
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&book, bookID).Error; err != nil {
+		return err
+	}
+	return tx.Exec(`
+		UPDATE books SET rating_count = r.count, rating_avg = r.avg
+		FROM (
+			SELECT COUNT(*) AS count, COALESCE(ROUND(AVG(rating), 2), 0) AS avg
+			FROM reviews WHERE book_id = ? AND NOT hidden AND deleted_at IS NULL
+		) r
+		WHERE books.id = ?`, bookID, bookID).Error
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var searchTokens = regexp.MustCompile(`"([^"]*)"|(\S+)`)
+
+// BookSearchQuery turns the q parameter into a tsquery expression over
+// books.search_vector, with its bind arguments. Quoted text is matched as a
+// phrase, words ending in * as prefixes and every other word as is; all of
+// them must match. ok is false when q holds nothing searchable.
+func BookSearchQuery(q string) (expr string, args []interface{}, ok bool) {
+	var parts []string
+	for _, m := range searchTokens.FindAllStringSubmatch(q, -1) {
+		switch {
+		case m[1] != "":
+			parts = append(parts, "phraseto_tsquery('bookstore_id', ?)")
+			args = append(args, m[1])
+		case strings.HasSuffix(m[2], "*"):
+			// only letters and digits may reach to_tsquery's syntax
+			word := strings.Map(func(r rune) rune {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) {
+					return r
+				}
+				return -1
+			}, m[2])
+			if word == "" {
+				continue
+			}
+			parts = append(parts, "to_tsquery('bookstore_simple', ?)")
+			args = append(args, word+":*")
+		case m[2] != "":
+			parts = append(parts, "plainto_tsquery('bookstore_id', ?)")
+			args = append(args, m[2])
+		}
+	}
+	if len(parts) == 0 {
+		return "", nil, false
+	}
+	return "(" + strings.Join(parts, " && ") + ")", args, true
+}
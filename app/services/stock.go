@@ -0,0 +1,188 @@
+package services
+
+import (
+	"errors"
+
+	"bookstore-api/app/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// StockChange describes a change to a book's stock in one warehouse: Delta
+// is added to it and recorded as a movement of the given type. A zero
+// WarehouseID means the primary warehouse.
+type StockChange struct {
+	BookID          uint
+	WarehouseID     uint
+	Delta           int
+	Type            string
+	OrderID         *uint
+	TransferID      *uint
+	PurchaseOrderID *uint
+	UserID          *uint
+	Reason          string
+}
+
+// ApplyStockChange is the only way stock should change. Inside tx it locks
+// the book and its warehouse stock, moves both by Delta and appends the
+// movement to the ledger. Stock never goes below zero in any warehouse;
+// ErrInsufficientStock is returned instead. When the change brings the
+// book back in stock the restock event is returned too, for
+// NotifyRestockAsync once tx has committed.
+func ApplyStockChange(tx *gorm.DB, ch StockChange) (*models.StockMovement, *models.RestockEvent, error) {
+	book, err := lockBookStock(tx, ch.BookID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ch.WarehouseID == 0 {
+		if ch.WarehouseID, err = PrimaryWarehouseID(tx); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := moveWarehouseStock(tx, ch.WarehouseID, ch.BookID, ch.Delta); err != nil {
+		return nil, nil, err
+	}
+	after := book.Stock + ch.Delta
+	updates := map[string]interface{}{"stock": after}
+	if book.LowStockAlertedAt != nil && after >= book.ReorderThreshold {
+		// restocked, so the next time it runs low is alerted again
+		updates["low_stock_alerted_at"] = nil
+	}
+	if err := tx.Model(&book).Updates(updates).Error; err != nil {
+		return nil, nil, err
+	}
+	mv := &models.StockMovement{
+		BookID: ch.BookID, WarehouseID: &ch.WarehouseID, Type: ch.Type, Quantity: ch.Delta, StockAfter: after,
+		OrderID: ch.OrderID, TransferID: ch.TransferID, PurchaseOrderID: ch.PurchaseOrderID, UserID: ch.UserID, Reason: ch.Reason,
+	}
+	if err := tx.Create(mv).Error; err != nil {
+		return nil, nil, err
+	}
+	ev, err := RecordRestock(tx, ch.BookID, book.Stock, after)
+	return mv, ev, err
+}
+
+// TransferStock moves t.Quantity copies of a book from one warehouse to
+// another and records the transfer with a pair of transfer movements. The
+// book's total stock is unchanged.
+func TransferStock(tx *gorm.DB, t *models.StockTransfer) error {
+	book, err := lockBookStock(tx, t.BookID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Create(t).Error; err != nil {
+		return err
+	}
+	legs := []struct {
+		warehouseID uint
+		delta       int
+	}{{t.FromWarehouseID, -t.Quantity}, {t.ToWarehouseID, t.Quantity}}
+	for _, leg := range legs {
+		if err := moveWarehouseStock(tx, leg.warehouseID, t.BookID, leg.delta); err != nil {
+			return err
+		}
+		warehouseID := leg.warehouseID
+		err := tx.Create(&models.StockMovement{
+			BookID: t.BookID, WarehouseID: &warehouseID, Type: models.MovementTransfer, Quantity: leg.delta,
+			StockAfter: book.Stock, TransferID: &t.ID, UserID: &t.UserID, Reason: t.Reason,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrimaryWarehouseID returns the warehouse stock goes to when none is given.
+func PrimaryWarehouseID(tx *gorm.DB) (uint, error) {
+	var w models.Warehouse
+	err := tx.Select("id").Order("is_primary DESC, priority, id").First(&w).Error
+	return w.ID, err
+}
+
+func lockBookStock(tx *gorm.DB, bookID uint) (models.Book, error) {
+	var book models.Book
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id", "stock", "reorder_threshold", "low_stock_alerted_at").First(&book, bookID).Error
+	return book, err
+}
+
+// moveWarehouseStock adds delta to the warehouse's stock of a book, creating
+// the row on first use. The book must already be locked.
+func moveWarehouseStock(tx *gorm.DB, warehouseID, bookID uint, delta int) error {
+	ws := models.WarehouseStock{WarehouseID: warehouseID, BookID: bookID}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&ws).Error; err != nil {
+		return err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("warehouse_id = ? AND book_id = ?", warehouseID, bookID).First(&ws).Error; err != nil {
+		return err
+	}
+	if ws.Quantity+delta < 0 {
+		return ErrInsufficientStock
+	}
+	return tx.Model(&models.WarehouseStock{}).
+		Where("warehouse_id = ? AND book_id = ?", warehouseID, bookID).
+		Update("quantity", ws.Quantity+delta).Error
+}
+
+// RecordInitialStock records the stock a book was created with as a
+// restock into the primary warehouse, so the ledger covers it.
+func RecordInitialStock(tx *gorm.DB, book models.Book, userID *uint) error {
+	if book.Stock == 0 {
+		return nil
+	}
+	warehouseID, err := PrimaryWarehouseID(tx)
+	if err != nil {
+		return err
+	}
+	ws := models.WarehouseStock{WarehouseID: warehouseID, BookID: book.ID, Quantity: book.Stock}
+	if err := tx.Create(&ws).Error; err != nil {
+		return err
+	}
+	return tx.Create(&models.StockMovement{
+		BookID: book.ID, WarehouseID: &warehouseID, Type: models.MovementRestock, Quantity: book.Stock,
+		StockAfter: book.Stock, UserID: userID, Reason: "initial stock",
+	}).Error
+}
+
+// StockDrift is a book whose stock differs from the sum of its movements,
+// either in total or, when WarehouseID is set, in one warehouse.
+type StockDrift struct {
+	BookID      uint
+	Title       string
+	WarehouseID *uint
+	Stock       int
+	Ledger      int
+}
+
+// FindStockDrift compares every book's total stock, and its stock in each
+// warehouse, with its ledger. Movements from before warehouses existed
+// count against the first warehouse. When both match, the total also
+// equals the sum over the warehouses.
+func FindStockDrift(db *gorm.DB) ([]StockDrift, error) {
+	var drift []StockDrift
+	err := db.Raw(`
+		SELECT b.id AS book_id, b.title, NULL AS warehouse_id, b.stock, COALESCE(SUM(m.quantity), 0) AS ledger
+		FROM books b LEFT JOIN stock_movements m ON m.book_id = b.id
+		WHERE b.deleted_at IS NULL
+		GROUP BY b.id
+		HAVING b.stock <> COALESCE(SUM(m.quantity), 0)
+		UNION ALL
+		SELECT b.id, b.title, s.warehouse_id, s.stock, s.ledger
+		FROM (
+			SELECT COALESCE(ws.book_id, ml.book_id) AS book_id, COALESCE(ws.warehouse_id, ml.warehouse_id) AS warehouse_id,
+				COALESCE(ws.quantity, 0) AS stock, COALESCE(ml.quantity, 0) AS ledger
+			FROM warehouse_stocks ws
+			FULL JOIN (
+				SELECT book_id, COALESCE(warehouse_id, (SELECT MIN(id) FROM warehouses)) AS warehouse_id, SUM(quantity) AS quantity
+				FROM stock_movements GROUP BY 1, 2
+			) ml ON ml.book_id = ws.book_id AND ml.warehouse_id = ws.warehouse_id
+		) s JOIN books b ON b.id = s.book_id
+		WHERE b.deleted_at IS NULL AND s.stock <> s.ledger
+		ORDER BY book_id, warehouse_id NULLS FIRST`).Scan(&drift).Error
+	return drift, err
+}
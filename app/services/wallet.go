@@ -0,0 +1,184 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"bookstore-api/app/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInsufficientCredit = errors.New("insufficient store credit")
+	ErrGiftCardNotFound   = errors.New("gift card not found")
+	ErrGiftCardRedeemed   = errors.New("gift card has already been redeemed")
+	ErrGiftCardExpired    = errors.New("gift card has expired")
+)
+
+// WalletChange describes a change to a user's store credit: Delta, in the
+// base currency, is added to the balance and recorded on the ledger.
+type WalletChange struct {
+	UserID     uint
+	Delta      float64
+	Type       string
+	GiftCardID *uint
+	OrderID    *uint
+	CreatedBy  *uint
+	Reason     string
+}
+
+// ApplyWalletChange is the only way a wallet balance should change. Inside
+// tx it locks the wallet, creating it on first use, moves the balance by
+// Delta and appends the transaction to the ledger. The balance never goes
+// below zero; ErrInsufficientCredit is returned instead.
+func ApplyWalletChange(tx *gorm.DB, ch WalletChange) (*models.WalletTransaction, error) {
+	w := models.Wallet{UserID: ch.UserID}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&w).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&w, "user_id = ?", ch.UserID).Error; err != nil {
+		return nil, err
+	}
+	after := roundCents(w.Balance + ch.Delta)
+	if after < 0 {
+		return nil, ErrInsufficientCredit
+	}
+	if err := tx.Model(&w).Update("balance", after).Error; err != nil {
+		return nil, err
+	}
+	t := &models.WalletTransaction{
+		UserID: ch.UserID, Type: ch.Type, Amount: roundCents(ch.Delta), BalanceAfter: after,
+		GiftCardID: ch.GiftCardID, OrderID: ch.OrderID, CreatedBy: ch.CreatedBy, Reason: ch.Reason,
+	}
+	if err := tx.Create(t).Error; err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// WalletBalance returns the user's store credit, zero without a wallet.
+func WalletBalance(db *gorm.DB, userID uint) (float64, error) {
+	var w models.Wallet
+	err := db.Where("user_id = ?", userID).Limit(1).Find(&w).Error
+	return w.Balance, err
+}
+
+// PayWithStoreCredit pays up to amount of the order, in its currency, from
+// the wallet of the user who placed it. The order must have just been
+// placed inside tx.
+func PayWithStoreCredit(tx *gorm.DB, order *models.Order, amount float64) error {
+	amount = roundCents(amount)
+	if amount > order.TotalPrice {
+		amount = order.TotalPrice
+	}
+	if amount <= 0 {
+		return nil
+	}
+	_, err := ApplyWalletChange(tx, WalletChange{
+		UserID: order.UserID, Delta: -roundCents(amount * order.ExchangeRate), Type: models.WalletPayment, OrderID: &order.ID,
+	})
+	if err != nil {
+		return err
+	}
+	order.StoreCredit = amount
+	return tx.Model(order).Update("store_credit", amount).Error
+}
+
+// RefundStoreCredit gives back to the wallet the store credit spent on the
+// order, exactly as it was taken, and returns how much that was.
+func RefundStoreCredit(tx *gorm.DB, order models.Order, createdBy *uint, reason string) (float64, error) {
+	var spent float64
+	err := tx.Model(&models.WalletTransaction{}).Select("COALESCE(-SUM(amount), 0)").
+		Where("order_id = ? AND type IN ?", order.ID, []string{models.WalletPayment, models.WalletRefund}).Scan(&spent).Error
+	if err != nil || spent <= 0 {
+		return 0, err
+	}
+	_, err = ApplyWalletChange(tx, WalletChange{
+		UserID: order.UserID, Delta: spent, Type: models.WalletRefund, OrderID: &order.ID, CreatedBy: createdBy, Reason: reason,
+	})
+	return spent, err
+}
+
+// RefundToStoreCredit puts the whole order total, converted to the base
+// currency, in the wallet of the user who placed it.
+func RefundToStoreCredit(tx *gorm.DB, order models.Order, createdBy *uint, reason string) (float64, error) {
+	// store credit already given back is not given twice
+	var refunded float64
+	err := tx.Model(&models.WalletTransaction{}).Select("COALESCE(SUM(amount), 0)").
+		Where("order_id = ? AND type = ?", order.ID, models.WalletRefund).Scan(&refunded).Error
+	if err != nil {
+		return 0, err
+	}
+	amount := roundCents(order.TotalPrice*order.ExchangeRate - refunded)
+	if amount <= 0 {
+		return 0, nil
+	}
+	_, err = ApplyWalletChange(tx, WalletChange{
+		UserID: order.UserID, Delta: amount, Type: models.WalletRefund, OrderID: &order.ID, CreatedBy: createdBy, Reason: reason,
+	})
+	return amount, err
+}
+
+// giftCardAlphabet leaves out letters and digits easily confused.
+const giftCardAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewGiftCardCode returns a random code such as "ABCD-EFGH-JKLM-NPQR".
+func NewGiftCardCode() (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(giftCardAlphabet)))
+	for i := 0; i < 16; i++ {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(giftCardAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// NormalizeGiftCardCode accepts a code in any case, with or without
+// dashes and spaces, and formats it the way it is stored.
+func NormalizeGiftCardCode(code string) string {
+	var raw []byte
+	for _, r := range strings.ToUpper(code) {
+		if r != '-' && r != ' ' {
+			raw = append(raw, byte(r))
+		}
+	}
+	if len(raw) != 16 {
+		return string(raw)
+	}
+	return string(raw[0:4]) + "-" + string(raw[4:8]) + "-" + string(raw[8:12]) + "-" + string(raw[12:16])
+}
+
+// RedeemGiftCard adds the gift card's amount to the user's wallet. The card
+// is locked so it can only be redeemed once.
+func RedeemGiftCard(tx *gorm.DB, code string, userID uint) (*models.WalletTransaction, error) {
+	var card models.GiftCard
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code = ?", NormalizeGiftCardCode(code)).First(&card).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrGiftCardNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if card.RedeemedAt != nil {
+		return nil, ErrGiftCardRedeemed
+	}
+	now := time.Now()
+	if card.ExpiresAt != nil && !now.Before(*card.ExpiresAt) {
+		return nil, ErrGiftCardExpired
+	}
+	if err := tx.Model(&card).Updates(map[string]interface{}{"redeemed_by": userID, "redeemed_at": now}).Error; err != nil {
+		return nil, err
+	}
+	return ApplyWalletChange(tx, WalletChange{UserID: userID, Delta: card.Amount, Type: models.WalletGiftCard, GiftCardID: &card.ID})
+}
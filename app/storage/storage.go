@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"bookstore-api/app/config"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Storage keeps binary objects such as book covers outside the database.
+// Keys are slash separated paths like "covers/2025-01/<uuid>.jpg".
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// New builds the backend selected by STORAGE_DRIVER.
+func New(cfg *config.Config) (Storage, error) {
+	switch cfg.StorageDriver {
+	case "", "local":
+		return NewLocal(cfg.StorageLocalDir), nil
+	case "s3":
+		return NewS3(S3Options{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.StorageDriver)
+	}
+}
@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NewFileKey builds a unique storage key for an uploaded file, grouped by
+// upload month, e.g. "covers/2025-01/<uuid>_<unix>.jpg". An empty ext gives
+// a base key other keys can be nested under.
+func NewFileKey(prefix, ext string) string {
+	now := time.Now()
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	filename := fmt.Sprintf("%s_%d%s", uuid.New().String(), now.Unix(), strings.ToLower(ext))
+	return path.Join(prefix, now.Format("2006-01"), filename)
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidISBN = errors.New("isbn must be a valid ISBN-10 or ISBN-13")
+
+// NormalizeISBN validates an ISBN-10 or ISBN-13, ignoring hyphens and
+// spaces, and returns it as a 13 digit ISBN so both forms of the same book
+// compare equal.
+func NormalizeISBN(s string) (string, error) {
+	s = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(s))
+	switch len(s) {
+	case 10:
+		if !validISBN10(s) {
+			return "", ErrInvalidISBN
+		}
+		isbn := "978" + s[:9]
+		return isbn + string(ISBN13CheckDigit(isbn)), nil
+	case 13:
+		if !allDigits(s) || ISBN13CheckDigit(s[:12]) != s[12] {
+			return "", ErrInvalidISBN
+		}
+		return s, nil
+	}
+	return "", ErrInvalidISBN
+}
+
+// ISBN13CheckDigit computes the check digit of the first 12 digits of an
+// ISBN-13.
+func ISBN13CheckDigit(first12 string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		d := int(first12[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func validISBN10(s string) bool {
+	sum := 0
+	for i := 0; i < 10; i++ {
+		var d int
+		switch {
+		case s[i] >= '0' && s[i] <= '9':
+			d = int(s[i] - '0')
+		case s[i] == 'X' && i == 9:
+			d = 10
+		default:
+			return false
+		}
+		sum += d * (10 - i)
+	}
+	return sum%11 == 0
+}
+
+func allDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"9780306406157", "9780306406157"},
+		{"978-0-306-40615-7", "9780306406157"},
+		{"978 0 306 40615 7", "9780306406157"},
+		{"9791090636071", "9791090636071"},
+		{"0306406152", "9780306406157"},
+		{"0-306-40615-2", "9780306406157"},
+		{"080442957X", "9780804429573"},
+		{"080442957x", "9780804429573"},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeISBN(tt.in)
+		if err != nil {
+			t.Errorf("NormalizeISBN(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeISBN(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeISBNRejectsInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"9780306406158",  // wrong ISBN-13 check digit
+		"978030640615X",  // X is not an ISBN-13 digit
+		"97803064061a7",  // letter inside an ISBN-13
+		"0306406153",     // wrong ISBN-10 check digit
+		"0804429570",     // X check digit given as 0
+		"X306406152",     // X only allowed as the last ISBN-10 digit
+		"030640615",      // too short
+		"97803064061570", // too long
+	} {
+		if got, err := NormalizeISBN(in); !errors.Is(err, ErrInvalidISBN) {
+			t.Errorf("NormalizeISBN(%q) = %q, %v, want ErrInvalidISBN", in, got, err)
+		}
+	}
+}
+
+func TestISBN13CheckDigit(t *testing.T) {
+	tests := map[string]byte{
+		"978030640615": '7',
+		"978080442957": '3',
+		"979109063607": '1',
+		"978000000000": '2',
+	}
+	for first12, want := range tests {
+		if got := ISBN13CheckDigit(first12); got != want {
+			t.Errorf("ISBN13CheckDigit(%q) = %c, want %c", first12, got, want)
+		}
+	}
+}
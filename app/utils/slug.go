@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Slugify lowercases s and joins its words with hyphens, e.g.
+// "Andrea Hirata" becomes "andrea-hirata".
+func Slugify(s string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+			continue
+		}
+		hyphen = true
+	}
+	return b.String()
+}
@@ -2,12 +2,20 @@ package main
 
 import (
 	"bookstore-api/app/config"
+	"bookstore-api/app/database/migrations"
 	"bookstore-api/app/database/seeders"
 	"bookstore-api/app/db"
+	"bookstore-api/app/flashsale"
+	"bookstore-api/app/jobs"
+	"bookstore-api/app/notify"
 	"bookstore-api/app/routes"
+	"bookstore-api/app/services"
+	"bookstore-api/app/storage"
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -39,22 +47,98 @@ func main() {
 		log.Fatalf("db connect: %v", err)
 	}
 
+	store, err := storage.New(cfg)
+	if err != nil {
+		log.Fatalf("storage: %v", err)
+	}
+	notifier, err := notify.New(cfg)
+	if err != nil {
+		log.Fatalf("notify: %v", err)
+	}
+	counter, err := flashsale.New(cfg)
+	if err != nil {
+		log.Fatalf("flash sales: %v", err)
+	}
+
 	if len(os.Args) > 1 {
 		switch os.Args[1] {
 		case "seed:db":
 			fmt.Println("Running Seeders...")
 			seeders.UserSeeder(gormDB)
-			seeders.BookSeeder(gormDB)
+			if err := seeders.BookSeeder(gormDB); err != nil {
+				log.Fatalf("seed books: %v", err)
+			}
 			fmt.Println("Database Ready")
+		case "migrate:images":
+			fmt.Println("Moving book images into storage...")
+			if err := migrations.BookImages(gormDB, store); err != nil {
+				log.Fatalf("migrate images: %v", err)
+			}
+			if err := migrations.SingleFileCovers(gormDB, store); err != nil {
+				log.Fatalf("migrate images: %v", err)
+			}
+			fmt.Println("Book images migrated")
+			return
+		case "migrate:authors":
+			fmt.Println("Linking book authors...")
+			if err := migrations.BookAuthors(gormDB); err != nil {
+				log.Fatalf("migrate authors: %v", err)
+			}
+			fmt.Println("Book authors migrated")
+			return
+		case "inventory:reconcile":
+			drift, err := services.FindStockDrift(gormDB)
+			if err != nil {
+				log.Fatalf("reconcile inventory: %v", err)
+			}
+			if len(drift) == 0 {
+				fmt.Println("Stock matches the ledger for every book")
+				return
+			}
+			fmt.Printf("%-8s %-10s %-8s %-8s %s\n", "BOOK", "WAREHOUSE", "STOCK", "LEDGER", "TITLE")
+			for _, d := range drift {
+				warehouse := "total"
+				if d.WarehouseID != nil {
+					warehouse = fmt.Sprint(*d.WarehouseID)
+				}
+				fmt.Printf("%-8d %-10s %-8d %-8d %s\n", d.BookID, warehouse, d.Stock, d.Ledger, d.Title)
+			}
+			fmt.Printf("%d stock levels drifted from the ledger\n", len(drift))
+			os.Exit(1)
+		case "refresh:recommendations":
+			fmt.Println("Refreshing co-purchases...")
+			if err := services.RefreshCoPurchases(gormDB); err != nil {
+				log.Fatalf("refresh recommendations: %v", err)
+			}
+			fmt.Println("Recommendations refreshed")
+			return
 		default:
 			fmt.Println("Command not found")
 		}
 	}
 
+	go jobs.Every(context.Background(), "refresh recommendations", cfg.RecommendationsRefresh, func(ctx context.Context) error {
+		return services.RefreshCoPurchases(gormDB.WithContext(ctx))
+	})
+
+	// pre-orders ship once their book is released
+	go jobs.Every(context.Background(), "fulfil backorders", cfg.BackorderCheck, func(ctx context.Context) error {
+		return services.FulfilAllBackorders(ctx, gormDB.WithContext(ctx), notifier, cfg.TaxRate)
+	})
+
+	// held flash sale copies go back on sale when their hold runs out, and
+	// what ended sales didn't sell goes back to stock
+	go jobs.Every(context.Background(), "expire flash sale reservations", 30*time.Second, func(ctx context.Context) error {
+		if err := services.ExpireReservations(ctx, gormDB.WithContext(ctx), counter); err != nil {
+			return err
+		}
+		return services.SettleFlashSales(ctx, gormDB.WithContext(ctx), counter, notifier, cfg.TaxRate)
+	})
+
 	docs.SwaggerInfo.BasePath = "/"
 	r := gin.Default()
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	routes.RegisterRoutes(r, gormDB, cfg)
+	routes.RegisterRoutes(r, gormDB, cfg, store, notifier, counter)
 
 	addr := ":" + cfg.AppPort
 	log.Println("listening on", addr)